@@ -2,22 +2,36 @@ package collections
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"sys.io/challenge-service/config"
 	"sys.io/challenge-service/models"
 )
 
-var attemptCollection *mongo.Collection = config.OpenCollection(config.Client, "attempt")
+func attemptCollection() *mongo.Collection {
+	return config.OpenCollection(config.Client, "attempt")
+}
 
 func CreateAttempt(attempt *models.Attempt) (result *mongo.InsertOneResult, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
-	return attemptCollection.InsertOne(ctx, attempt)
+	return attemptCollection().InsertOne(ctx, attempt)
 }
 
+func GetAttempt(token string) (attempt models.Attempt, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+	filter := bson.D{{Key: "token", Value: token}}
+	err = attemptCollection().FindOne(ctx, filter).Decode(&attempt)
+	return attempt, err
+}
+
+// UpdateAttempt records where a started attempt runs.
 func UpdateAttempt(attempt *models.Attempt) (updatedAttempt *models.Attempt, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
@@ -25,10 +39,245 @@ func UpdateAttempt(attempt *models.Attempt) (updatedAttempt *models.Attempt, err
 	token := attempt.Token
 	filter := bson.D{{Key: "token", Value: token}}
 
+	err = attemptCollection().FindOneAndUpdate(ctx, filter, startUpdate(attempt)).Decode(&attempt)
+
+	return attempt, err
+}
+
+// startUpdate sets the fields of a started attempt. A retried attempt starts
+// over, so the end and submission of its earlier run are cleared.
+func startUpdate(attempt *models.Attempt) bson.D {
+	return bson.D{
+		{Key: "$set", Value: bson.D{{Key: "ipaddress", Value: attempt.Ipaddress}, {Key: "port", Value: attempt.Port}, {Key: "sshkey", Value: attempt.Sshkey}, {Key: "namespace", Value: attempt.Namespace}, {Key: "startedAt", Value: attempt.StartedAt}, {Key: "releaseName", Value: attempt.ReleaseName}}},
+		{Key: "$unset", Value: bson.D{{Key: "endedAt", Value: ""}, {Key: "submittedAt", Value: ""}}},
+	}
+}
+
+// CountUnstartedAttempts counts the attempts of a challenge that have not
+// been started yet.
+func CountUnstartedAttempts(creatorName, challengeName string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}, {Key: "startedAt", Value: bson.D{{Key: "$exists", Value: false}}}}
+	return attemptCollection().CountDocuments(ctx, filter)
+}
+
+// ListAttempts returns every attempt of a challenge.
+func ListAttempts(creatorName, challengeName string) (attempts []models.Attempt, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	cursor, err := attemptCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts = []models.Attempt{}
+	err = cursor.All(ctx, &attempts)
+	return attempts, err
+}
+
+func DeleteAttempt(token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	_, err := attemptCollection().DeleteOne(ctx, bson.D{{Key: "token", Value: token}})
+	return err
+}
+
+// DeleteAttempts deletes every attempt of a challenge.
+func DeleteAttempts(creatorName, challengeName string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	result, err := attemptCollection().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// SetUnstartedAttemptsImage points the attempts of a challenge that have
+// not started yet at a new image.
+func SetUnstartedAttemptsImage(creatorName, challengeName, imageRegistryLink string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := unstartedFilter(creatorName, challengeName)
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "imageRegistryLink", Value: imageRegistryLink}}}}
+	_, err := attemptCollection().UpdateMany(ctx, filter, update)
+	return err
+}
+
+// unstartedFilter selects the attempts of a challenge still in the created
+// state. A start time alone does not tell, a retried attempt has it cleared
+// while it starts over.
+func unstartedFilter(creatorName, challengeName string) bson.D {
+	// attempts stored before states existed have no status field
+	status := bson.D{{Key: "$in", Value: bson.A{models.AttemptCreated, nil}}}
+	return bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}, {Key: "status", Value: status}, {Key: "startedAt", Value: bson.D{{Key: "$exists", Value: false}}}}
+}
+
+// ListStartedAttempts returns every attempt that has been started.
+func ListStartedAttempts() (attempts []models.Attempt, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "startedAt", Value: bson.D{{Key: "$exists", Value: true}}}}
+	cursor, err := attemptCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts = []models.Attempt{}
+	err = cursor.All(ctx, &attempts)
+	return attempts, err
+}
+
+// ListStartingAttempts returns every attempt in the starting state. They
+// have no start time yet, ListStartedAttempts leaves them out.
+func ListStartingAttempts() (attempts []models.Attempt, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "status", Value: models.AttemptStarting}}
+	cursor, err := attemptCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts = []models.Attempt{}
+	err = cursor.All(ctx, &attempts)
+	return attempts, err
+}
+
+// EndAttempt records that an attempt is over and its address is gone.
+func EndAttempt(token string, endedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "token", Value: token}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "endedAt", Value: endedAt}, {Key: "ipaddress", Value: ""}, {Key: "port", Value: ""}}}}
+	_, err := attemptCollection().UpdateOne(ctx, filter, update)
+	return err
+}
+
+// SubmitAttempt moves an attempt to submitted, recording when and
+// withdrawing its address from the participant in the same update.
+func SubmitAttempt(token string, submittedAt time.Time) (models.Attempt, error) {
+	return transitionAttempt(token, models.AttemptSubmitted, submitFields(submittedAt), bson.D{})
+}
 
-	// Create an 4update document to update the value of the object.
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "ipaddress", Value: attempt.Ipaddress},{Key: "port", Value: attempt.Port},{Key: "sshkey", Value: attempt.Sshkey}}}}
-	err = attemptCollection.FindOneAndUpdate(ctx, filter, update).Decode(&attempt)
+func submitFields(submittedAt time.Time) bson.D {
+	return bson.D{{Key: "submittedAt", Value: submittedAt}, {Key: "ipaddress", Value: ""}, {Key: "port", Value: ""}}
+}
+
+// GradeAttempt moves a submitted attempt to graded and stores its result,
+// feedback and per-check outcomes in the same update, a graded attempt
+// always has its result.
+func GradeAttempt(token string, result float64, feedback string, checks []models.GradeCheck, gradedAt time.Time) (models.Attempt, error) {
+	return transitionAttempt(token, models.AttemptGraded, gradeFields(result, feedback, checks, gradedAt), bson.D{})
+}
+
+func gradeFields(result float64, feedback string, checks []models.GradeCheck, gradedAt time.Time) bson.D {
+	return bson.D{{Key: "result", Value: result}, {Key: "feedback", Value: feedback}, {Key: "checks", Value: checks}, {Key: "gradedAt", Value: gradedAt}}
+}
 
+// TransitionError is returned for state changes the attempt's current state
+// does not allow.
+type TransitionError struct {
+	Token string
+	From  string
+	To    string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("attempt %s cannot go from %s to %s", e.Token, e.From, e.To)
+}
+
+// TransitionAttempt moves an attempt to a new state and records when. The
+// update only applies if the state did not change since it was read, so
+// concurrent commands cannot both win.
+func TransitionAttempt(token, to string) (attempt models.Attempt, err error) {
+	return transitionAttempt(token, to, bson.D{}, bson.D{})
+}
+
+// BeginAttempt moves an attempt to starting and records the release it
+// starts on in the same update, so whoever finds it starting can find its
+// release.
+func BeginAttempt(token, releaseName, namespace string) (models.Attempt, error) {
+	set, unset := beginFields(releaseName, namespace)
+	return transitionAttempt(token, models.AttemptStarting, set, unset)
+}
+
+// beginFields are the fields BeginAttempt sets and unsets. A retried attempt
+// starts over, the times of its earlier run are cleared until it is running
+// again.
+func beginFields(releaseName, namespace string) (set, unset bson.D) {
+	set = bson.D{{Key: "releaseName", Value: releaseName}, {Key: "namespace", Value: namespace}}
+	unset = bson.D{{Key: "startedAt", Value: ""}, {Key: "endedAt", Value: ""}, {Key: "submittedAt", Value: ""}}
+	return set, unset
+}
+
+// SetAttemptRelease records the release a starting attempt moved to. It
+// returns mongo.ErrNoDocuments if the attempt is no longer starting.
+func SetAttemptRelease(token, releaseName, namespace string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "token", Value: token}, {Key: "status", Value: models.AttemptStarting}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "releaseName", Value: releaseName}, {Key: "namespace", Value: namespace}}}}
+	result, err := attemptCollection().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// transitionAttempt is TransitionAttempt setting and unsetting further
+// fields along with the state.
+func transitionAttempt(token, to string, set, unset bson.D) (attempt models.Attempt, err error) {
+	attempt, err = GetAttempt(token)
+	if err != nil {
+		return attempt, err
+	}
+	from := models.AttemptStatus(&attempt)
+	if !models.CanTransition(from, to) {
+		return attempt, &TransitionError{Token: token, From: from, To: to}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	// attempts stored before states existed have no status field
+	statusFilter := bson.E{Key: "status", Value: from}
+	if from == models.AttemptCreated {
+		statusFilter = bson.E{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{from, nil}}}}
+	}
+	filter := bson.D{{Key: "token", Value: token}, statusFilter}
+	update := bson.D{
+		{Key: "$set", Value: append(bson.D{{Key: "status", Value: to}}, set...)},
+		{Key: "$push", Value: bson.D{{Key: "transitions", Value: models.AttemptTransition{From: from, To: to, At: time.Now()}}}},
+	}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err = attemptCollection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&attempt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// lost the race, report the state the winner left it in
+		current, getErr := GetAttempt(token)
+		if getErr != nil {
+			return attempt, getErr
+		}
+		return current, &TransitionError{Token: token, From: models.AttemptStatus(&current), To: to}
+	}
 	return attempt, err
-}
\ No newline at end of file
+}
@@ -0,0 +1,82 @@
+package collections
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"sys.io/challenge-service/models"
+)
+
+// field returns the value of a key in a document.
+func field(d bson.D, key string) (interface{}, bool) {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func TestStartUpdateClearsEarlierRun(t *testing.T) {
+	startedAt := time.Now()
+	update := startUpdate(&models.Attempt{Token: "token", Ipaddress: "10.0.0.1", Port: "22", StartedAt: &startedAt})
+
+	set, _ := field(update, "$set")
+	if value, _ := field(set.(bson.D), "startedAt"); value != &startedAt {
+		t.Errorf("$set = %v, want the start time", set)
+	}
+
+	unset, ok := field(update, "$unset")
+	if !ok {
+		t.Fatal("start update unsets nothing")
+	}
+	for _, key := range []string{"endedAt", "submittedAt"} {
+		if _, ok := field(unset.(bson.D), key); !ok {
+			t.Errorf("$unset = %v, want %s cleared", unset, key)
+		}
+	}
+}
+
+func TestBeginFieldsRecordRelease(t *testing.T) {
+	set, unset := beginFields("pwarm", "challenge")
+
+	if value, _ := field(set, "releaseName"); value != "pwarm" {
+		t.Errorf("$set = %v, want the release recorded", set)
+	}
+	if value, _ := field(set, "namespace"); value != "challenge" {
+		t.Errorf("$set = %v, want the namespace recorded", set)
+	}
+	for _, key := range []string{"startedAt", "endedAt", "submittedAt"} {
+		if _, ok := field(unset, key); !ok {
+			t.Errorf("$unset = %v, want %s cleared", unset, key)
+		}
+	}
+}
+
+func TestUnstartedFilterUsesStatus(t *testing.T) {
+	filter := unstartedFilter("creator", "ctf")
+
+	status, ok := field(filter, "status")
+	if !ok {
+		t.Fatalf("filter = %v, want attempts selected by status", filter)
+	}
+	in, _ := field(status.(bson.D), "$in")
+	if values := in.(bson.A); len(values) != 2 || values[0] != models.AttemptCreated || values[1] != nil {
+		t.Errorf("status $in = %v, want created or unset", values)
+	}
+}
+
+func TestSubmitFieldsWithdrawAddress(t *testing.T) {
+	submittedAt := time.Now()
+	set := submitFields(submittedAt)
+
+	if value, _ := field(set, "submittedAt"); value != submittedAt {
+		t.Errorf("$set = %v, want the submission time", set)
+	}
+	for _, key := range []string{"ipaddress", "port"} {
+		if value, ok := field(set, key); !ok || value != "" {
+			t.Errorf("$set = %v, want %s cleared", set, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package collections
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/models"
+)
+
+func auditCollection() *mongo.Collection {
+	return config.OpenCollection(config.Client, "audit")
+}
+
+func CreateAuditEntry(entry *models.AuditEntry) (result *mongo.InsertOneResult, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+	return auditCollection().InsertOne(ctx, entry)
+}
+
+// ListAuditEntries returns the entries matching query, newest first.
+func ListAuditEntries(query models.AuditQuery) (entries []models.AuditEntry, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{}
+	if query.Token != "" {
+		filter = append(filter, bson.E{Key: "token", Value: query.Token})
+	}
+	if query.CreatorName != "" {
+		filter = append(filter, bson.E{Key: "creatorName", Value: query.CreatorName})
+	}
+	if query.ChallengeName != "" {
+		filter = append(filter, bson.E{Key: "challengeName", Value: query.ChallengeName})
+	}
+	if query.CorID != "" {
+		filter = append(filter, bson.E{Key: "corId", Value: query.CorID})
+	}
+	at := bson.D{}
+	if !query.Since.IsZero() {
+		at = append(at, bson.E{Key: "$gte", Value: query.Since})
+	}
+	if !query.Until.IsZero() {
+		at = append(at, bson.E{Key: "$lte", Value: query.Until})
+	}
+	if len(at) > 0 {
+		filter = append(filter, bson.E{Key: "at", Value: at})
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+	cursor, err := auditCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries = []models.AuditEntry{}
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
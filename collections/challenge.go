@@ -2,17 +2,186 @@ package collections
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"sys.io/challenge-service/config"
 	"sys.io/challenge-service/models"
 )
 
-var challengeCollection *mongo.Collection = config.OpenCollection(config.Client, "challenge")
+func challengeCollection() *mongo.Collection {
+	return config.OpenCollection(config.Client, "challenge")
+}
 
 func CreateChallenge(challenge *models.Challenge) (result *mongo.InsertOneResult, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
-	return challengeCollection.InsertOne(ctx, challenge)
-}
\ No newline at end of file
+	return challengeCollection().InsertOne(ctx, challenge)
+}
+
+func GetChallenge(creatorName, challengeName string) (challenge models.Challenge, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	err = challengeCollection().FindOne(ctx, filter).Decode(&challenge)
+	if err != nil {
+		return challenge, err
+	}
+
+	challenge.Values, err = plainMap(challenge.Values)
+	return challenge, err
+}
+
+// UpdateChallenge stores the fields of a challenge an update may change.
+func UpdateChallenge(challenge *models.Challenge) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: challenge.CreatorName}, {Key: "challengeName", Value: challenge.ChallengeName}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "duration", Value: challenge.Duration},
+		{Key: "imageTag", Value: challenge.ImageTag},
+		{Key: "imageRegistryLink", Value: challenge.ImageRegistryLink},
+		{Key: "imageDigest", Value: challenge.ImageDigest},
+		{Key: "resources", Value: challenge.Resources},
+		{Key: "participants", Value: challenge.Participants},
+		{Key: "revision", Value: challenge.Revision},
+	}}}
+	result, err := challengeCollection().UpdateOne(ctx, filter, update)
+	if err == nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
+
+func DeleteChallenge(creatorName, challengeName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	_, err := challengeCollection().DeleteOne(ctx, filter)
+	return err
+}
+
+// ArchiveChallenge marks a challenge deleted while keeping it.
+func ArchiveChallenge(creatorName, challengeName string, archivedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "archivedAt", Value: archivedAt}}}}
+	_, err := challengeCollection().UpdateOne(ctx, filter, update)
+	return err
+}
+
+// ListClosingChallenges returns the challenges whose window ended by now
+// and that were not closed yet.
+func ListClosingChallenges(now time.Time) (challenges []models.Challenge, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "endsAt", Value: bson.D{{Key: "$lte", Value: now}}},
+		{Key: "closedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "archivedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	cursor, err := challengeCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	challenges = []models.Challenge{}
+	if err = cursor.All(ctx, &challenges); err != nil {
+		return nil, err
+	}
+	for i := range challenges {
+		if challenges[i].Values, err = plainMap(challenges[i].Values); err != nil {
+			return nil, err
+		}
+	}
+	return challenges, nil
+}
+
+// CloseChallenge records that a challenge's attempts were torn down at the
+// end of its window.
+func CloseChallenge(creatorName, challengeName string, closedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "closedAt", Value: closedAt}}}}
+	_, err := challengeCollection().UpdateOne(ctx, filter, update)
+	return err
+}
+
+// AddChallengeParticipants adds participants to a challenge's list, those
+// already on it are skipped.
+func AddChallengeParticipants(creatorName, challengeName string, participants []string) error {
+	return updateChallengeParticipants(creatorName, challengeName, bson.D{{Key: "$addToSet", Value: bson.D{{Key: "participants", Value: bson.D{{Key: "$each", Value: participants}}}}}})
+}
+
+// RemoveChallengeParticipants removes participants from a challenge's list.
+func RemoveChallengeParticipants(creatorName, challengeName string, participants []string) error {
+	return updateChallengeParticipants(creatorName, challengeName, bson.D{{Key: "$pullAll", Value: bson.D{{Key: "participants", Value: participants}}}})
+}
+
+func updateChallengeParticipants(creatorName, challengeName string, update bson.D) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	result, err := challengeCollection().UpdateOne(ctx, filter, update)
+	if err == nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
+
+// ListPoolChallenges returns the challenges that keep a warm pool or
+// pre-start.
+func ListPoolChallenges() (challenges []models.Challenge, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "warmPool", Value: bson.D{{Key: "$gt", Value: 0}}}},
+			bson.D{{Key: "preStart", Value: bson.D{{Key: "$gt", Value: 0}}}},
+		}},
+		{Key: "archivedAt", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+	cursor, err := challengeCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	challenges = []models.Challenge{}
+	if err = cursor.All(ctx, &challenges); err != nil {
+		return nil, err
+	}
+	for i := range challenges {
+		if challenges[i].Values, err = plainMap(challenges[i].Values); err != nil {
+			return nil, err
+		}
+	}
+	return challenges, nil
+}
+
+// plainMap turns the bson.D sub-documents the driver decodes into plain maps.
+func plainMap(m map[string]interface{}) (map[string]interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	b, err := bson.MarshalExtJSON(m, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var out map[string]interface{}
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
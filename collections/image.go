@@ -2,26 +2,95 @@ package collections
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"sys.io/challenge-service/config"
 	"sys.io/challenge-service/models"
 )
 
-var imageCollection *mongo.Collection = config.OpenCollection(config.Client, "image_builder")
+// image_builder is owned by the image builder service, it is only read here.
+func imageCollection() *mongo.Collection {
+	return config.OpenCollection(config.Client, "image_builder")
+}
 
-func GetImage(creatorName ,imageName, imageTag string) (result models.Image, err error) {
+var (
+	ErrImageNotFound    = errors.New("image not found")
+	ErrImageTagNotFound = errors.New("image exists but tag not found")
+)
+
+func GetImage(creatorName, imageName, imageTag string) (result models.Image, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
 
-
 	var image models.Image
 
 	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "imageName", Value: imageName}, {Key: "imageTag", Value: imageTag}}
-	err = imageCollection.FindOne(ctx, filter).Decode(&image)
+	err = imageCollection().FindOne(ctx, filter).Decode(&image)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return image, err
+	}
+
+	// tell a missing tag apart from a missing image
+	count, err := imageCollection().CountDocuments(ctx, bson.D{{Key: "creatorName", Value: creatorName}, {Key: "imageName", Value: imageName}})
+	if err != nil {
+		return image, err
+	}
+	if count > 0 {
+		return image, ErrImageTagNotFound
+	}
+	return image, ErrImageNotFound
+}
+
+func ListImageVersions(creatorName, imageName string) (versions []models.ImageVersion, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "imageName", Value: imageName}}
+	opts := options.Find().SetSort(bson.D{{Key: "imageTag", Value: 1}})
+	cursor, err := imageCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	versions = []models.ImageVersion{}
+	err = cursor.All(ctx, &versions)
+	return versions, err
+}
+
+func ListImages(creatorName string) (images []models.ImageCatalogEntry, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "creatorName", Value: creatorName}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "imageName", Value: 1}, {Key: "imageTag", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$imageName"},
+			{Key: "versions", Value: bson.D{{Key: "$push", Value: bson.D{
+				{Key: "corId", Value: "$corId"},
+				{Key: "imageTag", Value: "$imageTag"},
+				{Key: "imageRegistryLink", Value: "$imageRegistryLink"},
+			}}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "creatorName", Value: bson.D{{Key: "$literal", Value: creatorName}}},
+			{Key: "imageName", Value: "$_id"},
+			{Key: "versions", Value: 1},
+		}}},
+	}
 
-	return image, err
+	cursor, err := imageCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
 
-}
\ No newline at end of file
+	images = []models.ImageCatalogEntry{}
+	err = cursor.All(ctx, &images)
+	return images, err
+}
@@ -0,0 +1,91 @@
+package collections
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/models"
+)
+
+func poolCollection() *mongo.Collection {
+	return config.OpenCollection(config.Client, "pool")
+}
+
+func CreatePoolInstance(instance *models.PoolInstance) (result *mongo.InsertOneResult, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+	return poolCollection().InsertOne(ctx, instance)
+}
+
+func MarkPoolInstanceReady(release string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "release", Value: release}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: models.PoolReady}}}}
+	_, err := poolCollection().UpdateOne(ctx, filter, update)
+	return err
+}
+
+// TakePoolInstance removes the oldest ready instance of a challenge revision
+// from the pool and returns it. Concurrent callers never get the same
+// instance.
+func TakePoolInstance(creatorName, challengeName string, revision int) (instance models.PoolInstance, err error) {
+	return takePoolInstance(bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}, {Key: "status", Value: models.PoolReady}, {Key: "revision", Value: revision}})
+}
+
+// TakeStalePoolInstance is TakePoolInstance for instances of any other
+// revision.
+func TakeStalePoolInstance(creatorName, challengeName string, revision int) (instance models.PoolInstance, err error) {
+	return takePoolInstance(bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}, {Key: "status", Value: models.PoolReady}, {Key: "revision", Value: bson.D{{Key: "$ne", Value: revision}}}})
+}
+
+func takePoolInstance(filter bson.D) (instance models.PoolInstance, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	opts := options.FindOneAndDelete().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	err = poolCollection().FindOneAndDelete(ctx, filter, opts).Decode(&instance)
+	return instance, err
+}
+
+func ListPoolInstances(creatorName, challengeName string) (instances []models.PoolInstance, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "creatorName", Value: creatorName}, {Key: "challengeName", Value: challengeName}}
+	cursor, err := poolCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	instances = []models.PoolInstance{}
+	err = cursor.All(ctx, &instances)
+	return instances, err
+}
+
+func ListAllPoolInstances() (instances []models.PoolInstance, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	cursor, err := poolCollection().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	instances = []models.PoolInstance{}
+	err = cursor.All(ctx, &instances)
+	return instances, err
+}
+
+func DeletePoolInstance(release string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	_, err := poolCollection().DeleteOne(ctx, bson.D{{Key: "release", Value: release}})
+	return err
+}
@@ -0,0 +1,51 @@
+package collections
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/models"
+)
+
+func recordingCollection() *mongo.Collection {
+	return config.OpenCollection(config.Client, "recording")
+}
+
+func CreateRecording(recording *models.Recording) (result *mongo.InsertOneResult, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+	return recordingCollection().InsertOne(ctx, recording)
+}
+
+func ListRecordings(token string) (recordings []models.Recording, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "token", Value: token}}
+	opts := options.Find().SetSort(bson.D{{Key: "startedAt", Value: 1}})
+	cursor, err := recordingCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	recordings = []models.Recording{}
+	err = cursor.All(ctx, &recordings)
+	return recordings, err
+}
+
+// DeleteRecordings deletes the recordings of the given attempts.
+func DeleteRecordings(tokens []string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+	defer cancel()
+
+	filter := bson.D{{Key: "token", Value: bson.D{{Key: "$in", Value: tokens}}}}
+	result, err := recordingCollection().DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
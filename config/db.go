@@ -10,7 +10,14 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var Client *mongo.Client = DBinstance()
+// Client is the database connection, set by ConnectDB.
+var Client *mongo.Client
+
+// ConnectDB connects to the database and initialises its indexes, the
+// collections can be used once it returned.
+func ConnectDB() {
+	Client = DBinstance()
+}
 
 func DBinstance() (client *mongo.Client) {
 	MONGODB_URL = GetMongoURI()
@@ -53,6 +60,9 @@ func InitIndexes(client *mongo.Client) {
 			},
 			Options: options.Index().SetUnique(true),
 		},
+		// archived challenges count too, their name is only free again
+		// once they are deleted for good; partial indexes cannot select
+		// documents lacking archivedAt
 		{
 			Keys: bson.D{
 				{Key: "challengeName", Value: 1},
@@ -96,6 +106,68 @@ func InitIndexes(client *mongo.Client) {
 
 }
 
+// InitAuditIndexes indexes the audit log for its queries and expires
+// entries older than retention, a retention of 0 keeps them forever. It runs
+// once the env is loaded since the retention is configurable.
+func InitAuditIndexes(client *mongo.Client, retention time.Duration) {
+	auditCollection := OpenCollection(client, "audit")
+
+	auditIndexModel := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "token", Value: 1},
+				{Key: "at", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "creatorName", Value: 1},
+				{Key: "challengeName", Value: 1},
+				{Key: "at", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "corId", Value: 1},
+			},
+		},
+	}
+	auditIndexCreated, err := auditCollection.Indexes().CreateMany(context.Background(), auditIndexModel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Created Audit Index %s\n", auditIndexCreated)
+
+	const ttlIndex = "at_ttl"
+	if retention == 0 {
+		// nothing to do if there never was a retention
+		if _, err := auditCollection.Indexes().DropOne(context.Background(), ttlIndex); err != nil {
+			log.Printf("Audit retention disabled: %s", err)
+		}
+		return
+	}
+
+	seconds := int32(retention / time.Second)
+	_, err = auditCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetName(ttlIndex).SetExpireAfterSeconds(seconds),
+	})
+	if err != nil {
+		// the index exists with an older retention, change it in place
+		err = client.Database("cob").RunCommand(context.Background(), bson.D{
+			{Key: "collMod", Value: "audit"},
+			{Key: "index", Value: bson.D{
+				{Key: "name", Value: ttlIndex},
+				{Key: "expireAfterSeconds", Value: seconds},
+			}},
+		}).Err()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("Audit entries expire after %s", retention)
+}
+
 func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
 
 	var collection *mongo.Collection = client.Database("cob").Collection(collectionName)
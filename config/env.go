@@ -3,7 +3,11 @@ package config
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -22,6 +26,36 @@ var (
 	PLATFORM_PASSWORD string
 	PLATFORM_USERNAME string
 	AMQP_URL string
+	DEFAULT_DEPLOYER string
+	REGISTRY_INSECURE_HOSTS []string
+	CHALLENGE_MAX_CPU string
+	CHALLENGE_MAX_MEMORY string
+	CHALLENGE_MAX_EPHEMERAL_STORAGE string
+	ISOLATION_MODE string
+	ISOLATION_POD_CIDR string
+	ISOLATION_SERVICE_NAMESPACE string
+	ISOLATION_SERVICE_LABELS map[string]string
+	ENDPOINT_STRATEGY string
+	ENDPOINT_PUBLIC_HOSTNAME string
+	SSH_GATEWAY_ADDR string
+	SSH_GATEWAY_PUBLIC_PORT int32
+	SSH_GATEWAY_HOST_KEY string
+	SSH_GATEWAY_USER string
+	TERMINAL_ADDR string
+	TERMINAL_IDLE_TIMEOUT time.Duration
+	TERMINAL_ALLOWED_ORIGINS []string
+	SESSION_RECORDING bool
+	WARM_POOL_MAX int
+	WARM_POOL_INTERVAL time.Duration
+	RECONCILE_INTERVAL time.Duration
+	RECONCILE_GRACE time.Duration
+	RECONCILE_DRY_RUN bool
+	AUDIT_RETENTION time.Duration
+	SCHEDULE_INTERVAL time.Duration
+	BULK_CONCURRENCY int
+	SUBMISSION_HOLD time.Duration
+	GRADER_TIMEOUT time.Duration
+	GRADER_CONCURRENCY int
 )
 
 func InitEnv() {
@@ -48,6 +82,182 @@ func InitEnv() {
 	HELM_REPO_USERNAME = os.Getenv("HELM_REPO_USERNAME")
 	HELM_REPO_PASSWORD = os.Getenv("HELM_REPO_PASSWORD")
 
+	// deployer env
+	DEFAULT_DEPLOYER = os.Getenv("DEFAULT_DEPLOYER")
+	if DEFAULT_DEPLOYER == "" {
+		DEFAULT_DEPLOYER = "helm"
+	}
+
+	// registry env, hosts reached over plain http
+	REGISTRY_INSECURE_HOSTS = nil
+	for _, host := range strings.Split(os.Getenv("REGISTRY_INSECURE_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			REGISTRY_INSECURE_HOSTS = append(REGISTRY_INSECURE_HOSTS, host)
+		}
+	}
+
+	// resource maxima per attempt pod, empty means unlimited
+	CHALLENGE_MAX_CPU = os.Getenv("CHALLENGE_MAX_CPU")
+	CHALLENGE_MAX_MEMORY = os.Getenv("CHALLENGE_MAX_MEMORY")
+	CHALLENGE_MAX_EPHEMERAL_STORAGE = os.Getenv("CHALLENGE_MAX_EPHEMERAL_STORAGE")
+
+	// namespace isolation env: shared, attempt or challenge
+	ISOLATION_MODE = os.Getenv("ISOLATION_MODE")
+	if ISOLATION_MODE == "" {
+		ISOLATION_MODE = "shared"
+	}
+	ISOLATION_POD_CIDR = os.Getenv("ISOLATION_POD_CIDR")
+	// without it the ingress allowed from outside would admit every pod
+	if ISOLATION_MODE != "shared" {
+		if _, _, err := net.ParseCIDR(ISOLATION_POD_CIDR); err != nil {
+			log.Fatalf("Invalid ISOLATION_POD_CIDR %q for ISOLATION_MODE %s: %s", ISOLATION_POD_CIDR, ISOLATION_MODE, err)
+		}
+	}
+	// the gateway and the web terminal reach attempts from the service's
+	// pods, which isolation admits by namespace and labels
+	ISOLATION_SERVICE_NAMESPACE = os.Getenv("ISOLATION_SERVICE_NAMESPACE")
+	if ISOLATION_MODE != "shared" && ISOLATION_SERVICE_NAMESPACE == "" {
+		log.Fatalf("ISOLATION_SERVICE_NAMESPACE is required for ISOLATION_MODE %s", ISOLATION_MODE)
+	}
+	ISOLATION_SERVICE_LABELS = map[string]string{}
+	for _, label := range strings.Split(os.Getenv("ISOLATION_SERVICE_LABELS"), ",") {
+		if label = strings.TrimSpace(label); label == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			log.Fatalf("Invalid ISOLATION_SERVICE_LABELS entry %q, want key=value", label)
+		}
+		ISOLATION_SERVICE_LABELS[key] = value
+	}
+
+	// endpoint env: nodeport, loadbalancer, hostname or gateway
+	ENDPOINT_STRATEGY = os.Getenv("ENDPOINT_STRATEGY")
+	ENDPOINT_PUBLIC_HOSTNAME = os.Getenv("ENDPOINT_PUBLIC_HOSTNAME")
+
+	// ssh gateway env, an empty address disables the gateway
+	SSH_GATEWAY_ADDR = os.Getenv("SSH_GATEWAY_ADDR")
+	SSH_GATEWAY_PUBLIC_PORT = 0
+	if port := os.Getenv("SSH_GATEWAY_PUBLIC_PORT"); port != "" {
+		parsed, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			log.Fatalf("Invalid SSH_GATEWAY_PUBLIC_PORT %q: %s", port, err)
+		}
+		SSH_GATEWAY_PUBLIC_PORT = int32(parsed)
+	}
+	SSH_GATEWAY_HOST_KEY = os.Getenv("SSH_GATEWAY_HOST_KEY")
+	SSH_GATEWAY_USER = os.Getenv("SSH_GATEWAY_USER")
+	if SSH_GATEWAY_USER == "" {
+		SSH_GATEWAY_USER = "root"
+	}
+
+	// web terminal env, an empty address disables the terminal
+	TERMINAL_ADDR = os.Getenv("TERMINAL_ADDR")
+	TERMINAL_IDLE_TIMEOUT = 15 * time.Minute
+	if timeout := os.Getenv("TERMINAL_IDLE_TIMEOUT"); timeout != "" {
+		TERMINAL_IDLE_TIMEOUT, err = time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Invalid TERMINAL_IDLE_TIMEOUT %q: %s", timeout, err)
+		}
+	}
+	TERMINAL_ALLOWED_ORIGINS = nil
+	for _, origin := range strings.Split(os.Getenv("TERMINAL_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			TERMINAL_ALLOWED_ORIGINS = append(TERMINAL_ALLOWED_ORIGINS, origin)
+		}
+	}
+
+	// record gateway and web terminal sessions unless disabled
+	SESSION_RECORDING = os.Getenv("SESSION_RECORDING") != "false"
+
+	// warm pool env, the largest pool a challenge may ask for and how often
+	// pools are resized
+	WARM_POOL_MAX = 10
+	if max := os.Getenv("WARM_POOL_MAX"); max != "" {
+		WARM_POOL_MAX, err = strconv.Atoi(max)
+		if err != nil {
+			log.Fatalf("Invalid WARM_POOL_MAX %q: %s", max, err)
+		}
+	}
+	WARM_POOL_INTERVAL = time.Minute
+	if interval := os.Getenv("WARM_POOL_INTERVAL"); interval != "" {
+		WARM_POOL_INTERVAL, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid WARM_POOL_INTERVAL %q: %s", interval, err)
+		}
+	}
+
+	// reconciler env, an interval of 0 disables it and dry runs only log
+	RECONCILE_INTERVAL = 5 * time.Minute
+	if interval := os.Getenv("RECONCILE_INTERVAL"); interval != "" {
+		RECONCILE_INTERVAL, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid RECONCILE_INTERVAL %q: %s", interval, err)
+		}
+	}
+	RECONCILE_GRACE = 15 * time.Minute
+	if grace := os.Getenv("RECONCILE_GRACE"); grace != "" {
+		RECONCILE_GRACE, err = time.ParseDuration(grace)
+		if err != nil {
+			log.Fatalf("Invalid RECONCILE_GRACE %q: %s", grace, err)
+		}
+	}
+	RECONCILE_DRY_RUN = os.Getenv("RECONCILE_DRY_RUN") == "true"
+
+	// how often challenge windows are checked for having closed
+	SCHEDULE_INTERVAL = 30 * time.Second
+	if interval := os.Getenv("SCHEDULE_INTERVAL"); interval != "" {
+		SCHEDULE_INTERVAL, err = time.ParseDuration(interval)
+		if err != nil || SCHEDULE_INTERVAL <= 0 {
+			log.Fatalf("Invalid SCHEDULE_INTERVAL %q: %v", interval, err)
+		}
+	}
+
+	// how many attempts bulk commands start or stop at once
+	BULK_CONCURRENCY = 5
+	if concurrency := os.Getenv("BULK_CONCURRENCY"); concurrency != "" {
+		BULK_CONCURRENCY, err = strconv.Atoi(concurrency)
+		if err != nil || BULK_CONCURRENCY < 1 {
+			log.Fatalf("Invalid BULK_CONCURRENCY %q: %v", concurrency, err)
+		}
+	}
+
+	// how long submitted environments are kept for grading
+	SUBMISSION_HOLD = time.Hour
+	if hold := os.Getenv("SUBMISSION_HOLD"); hold != "" {
+		SUBMISSION_HOLD, err = time.ParseDuration(hold)
+		if err != nil || SUBMISSION_HOLD < 0 {
+			log.Fatalf("Invalid SUBMISSION_HOLD %q: %v", hold, err)
+		}
+	}
+
+	// how long a grader may run when the challenge sets no timeout
+	GRADER_TIMEOUT = 5 * time.Minute
+	if timeout := os.Getenv("GRADER_TIMEOUT"); timeout != "" {
+		GRADER_TIMEOUT, err = time.ParseDuration(timeout)
+		if err != nil || GRADER_TIMEOUT <= 0 {
+			log.Fatalf("Invalid GRADER_TIMEOUT %q: %v", timeout, err)
+		}
+	}
+
+	// how many submissions are graded at once
+	GRADER_CONCURRENCY = 5
+	if concurrency := os.Getenv("GRADER_CONCURRENCY"); concurrency != "" {
+		GRADER_CONCURRENCY, err = strconv.Atoi(concurrency)
+		if err != nil || GRADER_CONCURRENCY < 1 {
+			log.Fatalf("Invalid GRADER_CONCURRENCY %q: %v", concurrency, err)
+		}
+	}
+
+	// audit log entries expire after the retention, 0 keeps them forever
+	AUDIT_RETENTION = 30 * 24 * time.Hour
+	if retention := os.Getenv("AUDIT_RETENTION"); retention != "" {
+		AUDIT_RETENTION, err = time.ParseDuration(retention)
+		if err != nil || AUDIT_RETENTION < 0 {
+			log.Fatalf("Invalid AUDIT_RETENTION %q: %v", retention, err)
+		}
+	}
+
 	// rmq env
 	// RABBITMQ_USERNAME = os.Getenv("RABBITMQ_USERNAME")
 	// RABBITMQ_PASSWORD = os.Getenv("RABBITMQ_PASSWORD")
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"log"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sys.io/challenge-service/utils"
+)
+
+type Kube struct {
+	Rest   *rest.Config
+	Client kubernetes.Interface
+	// KubeConfig holds the raw kubeconfig file in DEV, empty in cluster.
+	KubeConfig []byte
+}
+
+func SetupKube() *Kube {
+	var kube Kube
+	var err error
+
+	// Create a Kubernetes client.
+	if ENVIRONMENT == "DEV" {
+		kube.Rest, err = clientcmd.BuildConfigFromFlags("", KUBECONFIG)
+		utils.FailOnError(err, "Failed to build Kubernetes config")
+
+		kube.KubeConfig, err = os.ReadFile(KUBECONFIG)
+		utils.FailOnError(err, "Failed to read kube config")
+	} else {
+		kube.Rest, err = rest.InClusterConfig()
+		utils.FailOnError(err, "Failed to load in-cluster config")
+	}
+
+	kube.Client, err = kubernetes.NewForConfig(kube.Rest)
+	utils.FailOnError(err, "Failed to create Kubernetes client")
+	log.Println("Kubernetes configured!")
+
+	return &kube
+}
@@ -0,0 +1,103 @@
+package deploy
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// capacityResources are the resources free capacity is tracked for.
+var capacityResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+// FreeCapacity returns the CPU and memory left on each of the cluster's
+// ready, schedulable nodes after the requests of the pods running on them,
+// by node name.
+func FreeCapacity(ctx context.Context, kube kubernetes.Interface) (map[string]corev1.ResourceList, error) {
+	nodes, err := kube.CoreV1().Nodes().List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	free := map[string]corev1.ResourceList{}
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable || !nodeReady(&node) {
+			continue
+		}
+		free[node.Name] = corev1.ResourceList{}
+		for _, name := range capacityResources {
+			free[node.Name][name] = node.Status.Allocatable[name].DeepCopy()
+		}
+	}
+
+	pods, err := kube.CoreV1().Pods("").List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		nodeFree, ok := free[pod.Spec.NodeName]
+		if !ok || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			for _, name := range capacityResources {
+				q := nodeFree[name]
+				q.Sub(container.Resources.Requests[name])
+				nodeFree[name] = q
+			}
+		}
+	}
+
+	for _, nodeFree := range free {
+		for name, q := range nodeFree {
+			if q.Sign() < 0 {
+				nodeFree[name] = resource.Quantity{}
+			}
+		}
+	}
+	return free, nil
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Fits returns how many pods with the given requests fit onto the nodes'
+// free capacity, each pod on a single node, -1 if the requests ask for none
+// of the tracked resources.
+func Fits(free map[string]corev1.ResourceList, requests corev1.ResourceList) int {
+	if nodeFits(nil, requests) < 0 {
+		return -1
+	}
+	fits := 0
+	for _, nodeFree := range free {
+		fits += nodeFits(nodeFree, requests)
+	}
+	return fits
+}
+
+// nodeFits returns how many pods with the given requests fit into one
+// node's free capacity, -1 if the requests ask for none of the tracked
+// resources.
+func nodeFits(free, requests corev1.ResourceList) int {
+	fits := -1
+	for _, name := range capacityResources {
+		request, ok := requests[name]
+		if !ok || request.IsZero() {
+			continue
+		}
+		available := free[name]
+		n := int(available.MilliValue() / request.MilliValue())
+		if fits < 0 || n < fits {
+			fits = n
+		}
+	}
+	return fits
+}
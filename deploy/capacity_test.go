@@ -0,0 +1,104 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func capacityNode(name string, ready, unschedulable bool, cpu, memory string) *corev1.Node {
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	return &corev1.Node{
+		ObjectMeta: v1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+		},
+	}
+}
+
+func capacityPod(name, node string, phase corev1.PodPhase, cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "challenge"},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name: "challenge",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(cpu),
+					corev1.ResourceMemory: resource.MustParse(memory),
+				}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestFreeCapacity(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		capacityNode("a", true, false, "4", "8Gi"),
+		capacityNode("b", true, false, "2", "4Gi"),
+		capacityNode("cordoned", true, true, "8", "16Gi"),
+		capacityNode("down", false, false, "8", "16Gi"),
+		capacityPod("running", "a", corev1.PodRunning, "1", "2Gi"),
+		capacityPod("pending", "b", corev1.PodPending, "500m", "1Gi"),
+		capacityPod("done", "a", corev1.PodSucceeded, "2", "4Gi"),
+		capacityPod("cordoned", "cordoned", corev1.PodRunning, "1", "1Gi"),
+	)
+
+	free, err := FreeCapacity(context.Background(), kube)
+	if err != nil {
+		t.Fatalf("FreeCapacity() error = %v", err)
+	}
+	want := map[string][2]string{"a": {"3", "6Gi"}, "b": {"1500m", "3Gi"}}
+	if len(free) != len(want) {
+		t.Fatalf("free capacity of %d nodes, want %d", len(free), len(want))
+	}
+	for node, w := range want {
+		if cpu := free[node][corev1.ResourceCPU]; cpu.Cmp(resource.MustParse(w[0])) != 0 {
+			t.Errorf("free cpu on %s = %s, want %s", node, cpu.String(), w[0])
+		}
+		if memory := free[node][corev1.ResourceMemory]; memory.Cmp(resource.MustParse(w[1])) != 0 {
+			t.Errorf("free memory on %s = %s, want %s", node, memory.String(), w[1])
+		}
+	}
+}
+
+func TestFits(t *testing.T) {
+	free := map[string]corev1.ResourceList{
+		"a": {corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+		"b": {corev1.ResourceCPU: resource.MustParse("1500m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+	}
+
+	tests := []struct {
+		name     string
+		requests corev1.ResourceList
+		want     int
+	}{
+		{"cpu bound", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("128Mi")}, 7},
+		{"memory bound", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("256Mi")}, 6},
+		// 3.5 CPUs are free in total, but on no single node
+		{"fragmented", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")}, 0},
+		{"one per node", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m")}, 2},
+		{"no requests", nil, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fits(free, tt.requests); got != tt.want {
+				t.Errorf("Fits() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package deploy
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"sys.io/challenge-service/utils"
+)
+
+// names challenges use to pick a deployment backend
+const (
+	BackendHelm     = "helm"
+	BackendManifest = "manifest"
+)
+
+// Deployer runs challenge instances on the cluster.
+type Deployer interface {
+	// Deploy installs or upgrades the release described by spec.
+	Deploy(ctx context.Context, spec *Spec) error
+	// Status reports whether the release's pod has come up.
+	Status(ctx context.Context, release Release) (Status, error)
+	// Endpoint returns the address participants connect to.
+	Endpoint(ctx context.Context, release Release) (*Endpoint, error)
+	// Teardown removes the release and everything it created.
+	Teardown(ctx context.Context, release Release) error
+	// List returns the releases the deployer runs on the cluster.
+	List(ctx context.Context) ([]ListedRelease, error)
+}
+
+// Release identifies a single deployed challenge instance.
+type Release struct {
+	Name      string
+	Namespace string
+}
+
+// ListedRelease is a release found on the cluster.
+type ListedRelease struct {
+	Release
+	Created time.Time
+}
+
+// Spec describes what to deploy for an attempt.
+type Spec struct {
+	Release
+	Image          utils.ImageReference
+	AuthorizedKeys string
+	Env            []EnvVar
+	// PullCredentials, when set, are stored in a per-release image pull
+	// secret instead of relying on the shared one.
+	PullCredentials *RegistryCredentials
+	// Resources size the challenge container, nil keeps the chart defaults.
+	Resources *Resources
+	// Isolation, when set, gives the release a namespace of its own or shared
+	// with its challenge only.
+	Isolation *Isolation
+	// Egress restricts outbound traffic, nil leaves it open.
+	Egress *Egress
+	// Values are per-challenge overrides merged into the chart values.
+	Values map[string]interface{}
+}
+
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Status string
+
+const (
+	StatusPending Status = "Pending"
+	StatusRunning Status = "Running"
+	StatusFailed  Status = "Failed"
+)
+
+type Endpoint struct {
+	Host string
+	Port int32
+}
+
+// deleteReleaseObjects removes what the service creates next to a release
+// regardless of backend.
+func deleteReleaseObjects(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	if err := deletePullSecret(ctx, kube, release); err != nil {
+		return err
+	}
+	if err := deleteFrozenPolicy(ctx, kube, release); err != nil {
+		return err
+	}
+	if err := deleteSameReleasePolicy(ctx, kube, release); err != nil {
+		return err
+	}
+	if err := deleteAttemptSecret(ctx, kube, release); err != nil {
+		return err
+	}
+	return deleteEgressPolicy(ctx, kube, release)
+}
@@ -0,0 +1,173 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// egress modes, the empty mode leaves egress unrestricted
+const (
+	EgressDeny      = "deny"
+	EgressDNSOnly   = "dns"
+	EgressAllowlist = "allowlist"
+)
+
+// Egress restricts what an attempt's pods can reach.
+type Egress struct {
+	Mode  string
+	CIDRs []string
+	// Hostnames are resolved when the release is deployed, later changes to
+	// their addresses are not followed.
+	Hostnames []string
+}
+
+// lookupIP resolves allowlisted hostnames, replaced in tests.
+var lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// ValidateEgress checks the mode and that every CIDR parses.
+func ValidateEgress(e *Egress) error {
+	if e == nil {
+		return nil
+	}
+
+	switch e.Mode {
+	case "", EgressDeny, EgressDNSOnly:
+		if len(e.CIDRs) > 0 || len(e.Hostnames) > 0 {
+			return fmt.Errorf("egress mode %q takes no allowlist", e.Mode)
+		}
+	case EgressAllowlist:
+		for _, cidr := range e.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid egress CIDR %q", cidr)
+			}
+		}
+		for _, host := range e.Hostnames {
+			if host == "" || strings.ContainsAny(host, "/: ") {
+				return fmt.Errorf("invalid egress hostname %q", host)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown egress mode %q", e.Mode)
+	}
+	return nil
+}
+
+func egressPolicyName(release Release) string {
+	return fmt.Sprintf("%s-egress", release.Name)
+}
+
+// egressPolicy builds the NetworkPolicy for the spec's egress settings, nil
+// when egress is unrestricted. Pods of the same release can always talk to
+// each other.
+func egressPolicy(ctx context.Context, spec *Spec) (*networkingv1.NetworkPolicy, error) {
+	e := spec.Egress
+	if e == nil || e.Mode == "" {
+		return nil, nil
+	}
+
+	release := &v1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/instance": spec.Name}}
+	rules := []networkingv1.NetworkPolicyEgressRule{{
+		To: []networkingv1.NetworkPolicyPeer{{PodSelector: release}},
+	}}
+
+	if e.Mode == EgressDNSOnly || e.Mode == EgressAllowlist {
+		udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+		dns := intstr.FromInt(53)
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &v1.LabelSelector{
+					MatchLabels: map[string]string{"kubernetes.io/metadata.name": "kube-system"},
+				},
+			}},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dns},
+				{Protocol: &tcp, Port: &dns},
+			},
+		})
+	}
+
+	if e.Mode == EgressAllowlist {
+		var peers []networkingv1.NetworkPolicyPeer
+		for _, cidr := range e.CIDRs {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+		}
+		for _, host := range e.Hostnames {
+			ips, err := lookupIP(ctx, host)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve egress hostname %s: %w", host, err)
+			}
+			for _, ip := range ips {
+				cidr := ip.String() + "/32"
+				if ip.To4() == nil {
+					cidr = ip.String() + "/128"
+				}
+				peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+			}
+		}
+		if len(peers) > 0 {
+			rules = append(rules, networkingv1.NetworkPolicyEgressRule{To: peers})
+		}
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      egressPolicyName(spec.Release),
+			Namespace: spec.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/instance": spec.Name,
+				managedByLabel:               managedBy,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: *release,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      rules,
+		},
+	}, nil
+}
+
+// ensureEgressPolicy applies the spec's egress policy before its pods start,
+// removing a previous one if egress is now unrestricted.
+func ensureEgressPolicy(ctx context.Context, kube kubernetes.Interface, spec *Spec) error {
+	policy, err := egressPolicy(ctx, spec)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return deleteEgressPolicy(ctx, kube, spec.Release)
+	}
+
+	if err := ensureNamespace(ctx, kube, spec.Namespace); err != nil {
+		return err
+	}
+
+	policies := kube.NetworkingV1().NetworkPolicies(spec.Namespace)
+	if _, err := policies.Create(ctx, policy, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create egress policy: %w", err)
+		}
+		if _, err := policies.Update(ctx, policy, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update egress policy: %w", err)
+		}
+	}
+	return nil
+}
+
+func deleteEgressPolicy(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	err := kube.NetworkingV1().NetworkPolicies(release.Namespace).Delete(ctx, egressPolicyName(release), v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete egress policy: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package deploy
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateEgress(t *testing.T) {
+	tests := []struct {
+		name    string
+		egress  *Egress
+		wantErr bool
+	}{
+		{"Nil", nil, false},
+		{"Deny", &Egress{Mode: EgressDeny}, false},
+		{"Allowlist", &Egress{Mode: EgressAllowlist, CIDRs: []string{"10.0.0.0/8"}, Hostnames: []string{"deb.debian.org"}}, false},
+		{"Unknown mode", &Egress{Mode: "some"}, true},
+		{"Bad CIDR", &Egress{Mode: EgressAllowlist, CIDRs: []string{"10.0.0.0"}}, true},
+		{"Bad hostname", &Egress{Mode: EgressAllowlist, Hostnames: []string{"http://deb.debian.org"}}, true},
+		{"Allowlist without allowlist mode", &Egress{Mode: EgressDeny, CIDRs: []string{"10.0.0.0/8"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateEgress(tt.egress); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateEgress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEgressPolicy(t *testing.T) {
+	defaultLookupIP := lookupIP
+	defer func() { lookupIP = defaultLookupIP }()
+	lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("151.101.2.132"), net.ParseIP("2a04:4e42::644")}, nil
+	}
+
+	release := Release{Name: "atoken", Namespace: "challenge"}
+
+	tests := []struct {
+		name      string
+		egress    *Egress
+		wantNil   bool
+		wantRules int
+		wantPeers []string
+	}{
+		{"Unrestricted", nil, true, 0, nil},
+		{"Deny all", &Egress{Mode: EgressDeny}, false, 1, nil},
+		{"DNS only", &Egress{Mode: EgressDNSOnly}, false, 2, nil},
+		{"Allowlist", &Egress{Mode: EgressAllowlist, CIDRs: []string{"10.1.0.0/16"}, Hostnames: []string{"deb.debian.org"}}, false, 3,
+			[]string{"10.1.0.0/16", "151.101.2.132/32", "2a04:4e42::644/128"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := egressPolicy(context.Background(), &Spec{Release: release, Egress: tt.egress})
+			if err != nil {
+				t.Fatalf("egressPolicy() error = %v", err)
+			}
+			if tt.wantNil {
+				if policy != nil {
+					t.Errorf("egressPolicy() = %v, want nil", policy)
+				}
+				return
+			}
+
+			if len(policy.Spec.Egress) != tt.wantRules {
+				t.Fatalf("got %d egress rules, want %d", len(policy.Spec.Egress), tt.wantRules)
+			}
+			if policy.Spec.PodSelector.MatchLabels["app.kubernetes.io/instance"] != "atoken" {
+				t.Errorf("policy selects %v, want the release's pods", policy.Spec.PodSelector)
+			}
+			if tt.wantPeers != nil {
+				peers := policy.Spec.Egress[2].To
+				for i, cidr := range tt.wantPeers {
+					if peers[i].IPBlock.CIDR != cidr {
+						t.Errorf("peer %d = %s, want %s", i, peers[i].IPBlock.CIDR, cidr)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEgressPolicyLifecycle(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+	spec := &Spec{Release: Release{Name: "atoken", Namespace: "challenge"}, Egress: &Egress{Mode: EgressDeny}}
+
+	if err := m.Deploy(ctx, spec); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	if _, err := kube.NetworkingV1().NetworkPolicies("challenge").Get(ctx, "atoken-egress", v1.GetOptions{}); err != nil {
+		t.Fatalf("egress policy not created: %v", err)
+	}
+
+	if err := m.Teardown(ctx, spec.Release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := kube.NetworkingV1().NetworkPolicies("challenge").Get(ctx, "atoken-egress", v1.GetOptions{}); err == nil {
+		t.Errorf("egress policy still exists after Teardown")
+	}
+}
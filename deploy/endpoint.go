@@ -0,0 +1,281 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// how participants reach their release
+const (
+	EndpointNodePort     = "nodeport"
+	EndpointLoadBalancer = "loadbalancer"
+	EndpointHostname     = "hostname"
+	EndpointGateway      = "gateway"
+)
+
+// ErrEndpointNotReady means the endpoint exists but has no address yet, e.g.
+// a load balancer that is still being provisioned.
+var ErrEndpointNotReady = errors.New("endpoint not ready")
+
+// EndpointResolver finds the address participants connect to.
+type EndpointResolver interface {
+	Resolve(ctx context.Context, kube kubernetes.Interface, release Release) (*Endpoint, error)
+	// ServiceType is the type the release's service needs for this strategy.
+	ServiceType() corev1.ServiceType
+}
+
+// NewEndpointResolver returns the resolver for a strategy name. The
+// hostname and gateway strategies require a public hostname, the gateway
+// strategy also the port of the SSH gateway.
+func NewEndpointResolver(strategy, hostname string, gatewayPort int32) (EndpointResolver, error) {
+	switch strategy {
+	case "", EndpointNodePort:
+		return NodePortResolver{}, nil
+	case EndpointLoadBalancer:
+		return LoadBalancerResolver{}, nil
+	case EndpointHostname:
+		if hostname == "" {
+			return nil, fmt.Errorf("endpoint strategy %q needs a public hostname", strategy)
+		}
+		return HostnameResolver{Hostname: hostname}, nil
+	case EndpointGateway:
+		if hostname == "" || gatewayPort == 0 {
+			return nil, fmt.Errorf("endpoint strategy %q needs a public hostname and gateway port", strategy)
+		}
+		return GatewayResolver{Hostname: hostname, Port: gatewayPort}, nil
+	default:
+		return nil, fmt.Errorf("unknown endpoint strategy %q", strategy)
+	}
+}
+
+// NodePortResolver returns the address of the node running the release's
+// pod and the service's NodePort.
+type NodePortResolver struct{}
+
+func (NodePortResolver) ServiceType() corev1.ServiceType {
+	return corev1.ServiceTypeNodePort
+}
+
+func (NodePortResolver) Resolve(ctx context.Context, kube kubernetes.Interface, release Release) (*Endpoint, error) {
+	pod, err := releasePod(ctx, kube, release)
+	if err != nil {
+		return nil, err
+	}
+	if pod.Spec.NodeName == "" {
+		return nil, ErrEndpointNotReady
+	}
+
+	node, err := kube.CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	host := nodeAddress(node)
+	if host == "" {
+		return nil, fmt.Errorf("node %s has no IP address", node.Name)
+	}
+
+	port, err := nodePort(ctx, kube, release)
+	if err != nil {
+		return nil, err
+	}
+	return &Endpoint{Host: host, Port: port}, nil
+}
+
+// LoadBalancerResolver returns the ingress IP or hostname of the release's
+// LoadBalancer service.
+type LoadBalancerResolver struct{}
+
+func (LoadBalancerResolver) ServiceType() corev1.ServiceType {
+	return corev1.ServiceTypeLoadBalancer
+}
+
+func (LoadBalancerResolver) Resolve(ctx context.Context, kube kubernetes.Interface, release Release) (*Endpoint, error) {
+	service, err := kube.CoreV1().Services(release.Namespace).Get(ctx, serviceName(release), v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(service.Spec.Ports) == 0 {
+		return nil, fmt.Errorf("service %s exposes no ports", service.Name)
+	}
+
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		host := ingress.IP
+		if host == "" {
+			host = ingress.Hostname
+		}
+		if host != "" {
+			return &Endpoint{Host: host, Port: service.Spec.Ports[0].Port}, nil
+		}
+	}
+	return nil, ErrEndpointNotReady
+}
+
+// HostnameResolver returns a fixed public hostname, e.g. a DNS name in front
+// of all nodes, with the service's NodePort.
+type HostnameResolver struct {
+	Hostname string
+}
+
+func (HostnameResolver) ServiceType() corev1.ServiceType {
+	return corev1.ServiceTypeNodePort
+}
+
+func (r HostnameResolver) Resolve(ctx context.Context, kube kubernetes.Interface, release Release) (*Endpoint, error) {
+	port, err := nodePort(ctx, kube, release)
+	if err != nil {
+		return nil, err
+	}
+	return &Endpoint{Host: r.Hostname, Port: port}, nil
+}
+
+// GatewayResolver sends every participant to the shared SSH gateway, which
+// proxies into the release's ClusterIP service.
+type GatewayResolver struct {
+	Hostname string
+	Port     int32
+}
+
+func (GatewayResolver) ServiceType() corev1.ServiceType {
+	return corev1.ServiceTypeClusterIP
+}
+
+func (r GatewayResolver) Resolve(ctx context.Context, kube kubernetes.Interface, release Release) (*Endpoint, error) {
+	// the gateway can only proxy once the service has a cluster IP
+	if _, err := ServiceAddress(ctx, kube, release); err != nil {
+		return nil, err
+	}
+	return &Endpoint{Host: r.Hostname, Port: r.Port}, nil
+}
+
+// ServiceAddress returns the in-cluster host:port of the release's SSH
+// service.
+func ServiceAddress(ctx context.Context, kube kubernetes.Interface, release Release) (string, error) {
+	service, err := kube.CoreV1().Services(release.Namespace).Get(ctx, serviceName(release), v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", ErrEndpointNotReady
+	}
+	port, err := servicePort(service)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(port.Port))), nil
+}
+
+// WaitEndpoint polls the release's endpoint until it has an address.
+func WaitEndpoint(ctx context.Context, d Deployer, release Release, interval time.Duration) (*Endpoint, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		endpoint, err := d.Endpoint(ctx, release)
+		if !errors.Is(err, ErrEndpointNotReady) {
+			return endpoint, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// instanceSelector matches the pods and services belonging to a release.
+func instanceSelector(release Release) string {
+	return fmt.Sprintf("app.kubernetes.io/instance=%s", release.Name)
+}
+
+// serviceName is the name the challenge chart gives a release's service.
+func serviceName(release Release) string {
+	return fmt.Sprintf("%s-challenge", release.Name)
+}
+
+// releasePod returns the release's first pod.
+func releasePod(ctx context.Context, kube kubernetes.Interface, release Release) (*corev1.Pod, error) {
+	podList, err := kube.CoreV1().Pods(release.Namespace).List(ctx, v1.ListOptions{
+		LabelSelector: instanceSelector(release),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(podList.Items) == 0 {
+		return nil, ErrEndpointNotReady
+	}
+	return &podList.Items[0], nil
+}
+
+// podStatus maps the phase of the release's first pod onto a Status.
+func podStatus(ctx context.Context, kube kubernetes.Interface, release Release) (Status, error) {
+	pod, err := releasePod(ctx, kube, release)
+	// the pod may not have been scheduled yet
+	if errors.Is(err, ErrEndpointNotReady) {
+		return StatusPending, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodPending:
+		return StatusPending, nil
+	case corev1.PodRunning:
+		return StatusRunning, nil
+	default:
+		return StatusFailed, nil
+	}
+}
+
+// nodeAddress prefers a node's external IP over its internal one.
+func nodeAddress(node *corev1.Node) string {
+	for _, addressType := range []corev1.NodeAddressType{corev1.NodeExternalIP, corev1.NodeInternalIP} {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType {
+				return address.Address
+			}
+		}
+	}
+	return ""
+}
+
+// nodePort returns the NodePort of the release service's ssh port.
+func nodePort(ctx context.Context, kube kubernetes.Interface, release Release) (int32, error) {
+	service, err := kube.CoreV1().Services(release.Namespace).Get(ctx, serviceName(release), v1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	port, err := servicePort(service)
+	if err != nil {
+		return 0, err
+	}
+	if port.NodePort == 0 {
+		return 0, ErrEndpointNotReady
+	}
+	return port.NodePort, nil
+}
+
+// servicePort returns the service port named ssh, or its first port if none is.
+func servicePort(service *corev1.Service) (corev1.ServicePort, error) {
+	if len(service.Spec.Ports) == 0 {
+		return corev1.ServicePort{}, fmt.Errorf("service %s exposes no ports", service.Name)
+	}
+
+	port := service.Spec.Ports[0]
+	for _, p := range service.Spec.Ports {
+		if p.Name == "ssh" {
+			port = p
+		}
+	}
+	return port, nil
+}
@@ -0,0 +1,113 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func endpointCluster(serviceType corev1.ServiceType, ingress []corev1.LoadBalancerIngress) *fake.Clientset {
+	return fake.NewSimpleClientset(
+		&corev1.Node{
+			ObjectMeta: v1.ObjectMeta{Name: "node-a"},
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "node-a"},
+				{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+			}},
+		},
+		&corev1.Node{
+			ObjectMeta: v1.ObjectMeta{Name: "node-b"},
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "node-b"},
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.2"},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "atoken-challenge-abc",
+				Namespace: "challenge",
+				Labels:    map[string]string{"app.kubernetes.io/instance": "atoken"},
+			},
+			Spec: corev1.PodSpec{NodeName: "node-b"},
+		},
+		&corev1.Service{
+			ObjectMeta: v1.ObjectMeta{Name: "atoken-challenge", Namespace: "challenge"},
+			Spec: corev1.ServiceSpec{
+				Type:      serviceType,
+				ClusterIP: "10.96.0.10",
+				Ports: []corev1.ServicePort{
+					{Name: "http", Port: 80, NodePort: 30080},
+					{Name: "ssh", Port: 22, NodePort: 30022},
+				},
+			},
+			Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: ingress}},
+		},
+	)
+}
+
+func TestEndpointResolvers(t *testing.T) {
+	release := Release{Name: "atoken", Namespace: "challenge"}
+
+	tests := []struct {
+		name     string
+		strategy string
+		hostname string
+		port     int32
+		ingress  []corev1.LoadBalancerIngress
+		want     *Endpoint
+		wantErr  error
+	}{
+		{"NodePort on the pod's node", EndpointNodePort, "", 0, nil, &Endpoint{Host: "10.0.0.2", Port: 30022}, nil},
+		{"Load balancer IP", EndpointLoadBalancer, "", 0, []corev1.LoadBalancerIngress{{IP: "198.51.100.7"}}, &Endpoint{Host: "198.51.100.7", Port: 80}, nil},
+		{"Load balancer hostname", EndpointLoadBalancer, "", 0, []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}}, &Endpoint{Host: "lb.example.com", Port: 80}, nil},
+		{"Load balancer pending", EndpointLoadBalancer, "", 0, nil, nil, ErrEndpointNotReady},
+		{"Public hostname", EndpointHostname, "ctf.example.com", 0, nil, &Endpoint{Host: "ctf.example.com", Port: 30022}, nil},
+		{"SSH gateway", EndpointGateway, "ctf.example.com", 2222, nil, &Endpoint{Host: "ctf.example.com", Port: 2222}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewEndpointResolver(tt.strategy, tt.hostname, tt.port)
+			if err != nil {
+				t.Fatalf("NewEndpointResolver() error = %v", err)
+			}
+
+			kube := endpointCluster(resolver.ServiceType(), tt.ingress)
+			got, err := resolver.Resolve(context.Background(), kube, release)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Resolve() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want != nil && *got != *tt.want {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEndpointResolverErrors(t *testing.T) {
+	if _, err := NewEndpointResolver(EndpointHostname, "", 0); err == nil {
+		t.Errorf("hostname strategy without a hostname should fail")
+	}
+	if _, err := NewEndpointResolver(EndpointGateway, "ctf.example.com", 0); err == nil {
+		t.Errorf("gateway strategy without a port should fail")
+	}
+	if _, err := NewEndpointResolver("ingress", "", 0); err == nil {
+		t.Errorf("unknown strategy should fail")
+	}
+}
+
+func TestServiceAddress(t *testing.T) {
+	kube := endpointCluster(corev1.ServiceTypeClusterIP, nil)
+
+	got, err := ServiceAddress(context.Background(), kube, Release{Name: "atoken", Namespace: "challenge"})
+	if err != nil {
+		t.Fatalf("ServiceAddress() error = %v", err)
+	}
+	if got != "10.96.0.10:22" {
+		t.Errorf("ServiceAddress() = %v, want 10.96.0.10:22", got)
+	}
+}
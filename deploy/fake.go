@@ -0,0 +1,166 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory Deployer for tests.
+type Fake struct {
+	// PendingPolls is how many Status calls a new release answers with
+	// StatusPending before it reports StatusRunning.
+	PendingPolls int
+	// DeployErr, when set, is returned by every Deploy call.
+	DeployErr error
+	// TeardownErr, when set, is returned by every Teardown call.
+	TeardownErr error
+	Host        string
+
+	mu       sync.Mutex
+	nextPort int32
+	releases map[Release]*fakeRelease
+}
+
+type fakeRelease struct {
+	spec      Spec
+	injection *Injection
+	created   time.Time
+	polls     int
+	status    Status
+	endpoint  Endpoint
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		Host:     "127.0.0.1",
+		nextPort: 30000,
+		releases: map[Release]*fakeRelease{},
+	}
+}
+
+func (f *Fake) Deploy(ctx context.Context, spec *Spec) error {
+	if f.DeployErr != nil {
+		return f.DeployErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r, ok := f.releases[spec.Release]; ok {
+		r.spec = *spec
+		return nil
+	}
+	f.releases[spec.Release] = &fakeRelease{
+		spec:     *spec,
+		created:  time.Now(),
+		status:   StatusPending,
+		endpoint: Endpoint{Host: f.Host, Port: f.nextPort},
+	}
+	f.nextPort++
+	return nil
+}
+
+func (f *Fake) Status(ctx context.Context, release Release) (Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.releases[release]
+	if !ok {
+		return "", fmt.Errorf("release %s not found", release.Name)
+	}
+	if r.status == StatusPending {
+		if r.polls >= f.PendingPolls {
+			r.status = StatusRunning
+		}
+		r.polls++
+	}
+	return r.status, nil
+}
+
+func (f *Fake) Endpoint(ctx context.Context, release Release) (*Endpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.releases[release]
+	if !ok {
+		return nil, fmt.Errorf("release %s not found", release.Name)
+	}
+	endpoint := r.endpoint
+	return &endpoint, nil
+}
+
+func (f *Fake) Inject(ctx context.Context, release Release, injection *Injection) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.releases[release]
+	if !ok {
+		return fmt.Errorf("release %s not found", release.Name)
+	}
+	// the release keeps the env it was deployed with, as a running pod would
+	r.spec.AuthorizedKeys = injection.AuthorizedKeys
+	injected := *injection
+	r.injection = &injected
+	return nil
+}
+
+func (f *Fake) List(ctx context.Context) ([]ListedRelease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	created := map[Release]time.Time{}
+	for release, r := range f.releases {
+		created[release] = r.created
+	}
+	return listed(created), nil
+}
+
+// Teardown removes the release, releases that are gone already are no
+// error as with the other deployers.
+func (f *Fake) Teardown(ctx context.Context, release Release) error {
+	if f.TeardownErr != nil {
+		return f.TeardownErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.releases, release)
+	return nil
+}
+
+// SetStatus forces the status reported for a deployed release.
+func (f *Fake) SetStatus(release Release, status Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r, ok := f.releases[release]; ok {
+		r.status = status
+	}
+}
+
+// Spec returns the spec a release was last deployed with.
+func (f *Fake) Spec(release Release) (Spec, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.releases[release]
+	if !ok {
+		return Spec{}, false
+	}
+	return r.spec, true
+}
+
+// Injection returns what a release was last handed to an attempt with.
+func (f *Fake) Injection(release Release) (Injection, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.releases[release]
+	if !ok || r.injection == nil {
+		return Injection{}, false
+	}
+	return *r.injection, true
+}
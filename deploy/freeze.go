@@ -0,0 +1,143 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// frozenLabel marks the pods of a release kept for grading only, and the
+// policy that keeps them so. The namespace isolation policies leave them out.
+const frozenLabel = "cob.sys.io/frozen"
+
+// instanceLabel names the release a pod belongs to. Pods recreated from the
+// release's template carry it too.
+const instanceLabel = "app.kubernetes.io/instance"
+
+func frozenPolicyName(release Release) string {
+	return fmt.Sprintf("%s-frozen", release.Name)
+}
+
+// Freeze cuts a release off from its participant while keeping it for
+// grading: its pods only accept traffic from each other and from grader
+// jobs. Exec, which in-pod graders use, goes through the API server and is
+// unaffected.
+func Freeze(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	instance := map[string]string{instanceLabel: release.Name}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      frozenPolicyName(release),
+			Namespace: release.Namespace,
+			Labels: map[string]string{
+				instanceLabel:  release.Name,
+				managedByLabel: managedBy,
+				frozenLabel:    "true",
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: v1.LabelSelector{MatchLabels: instance},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &v1.LabelSelector{MatchLabels: instance}},
+					{PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{graderLabel: release.Name}}},
+				},
+			}},
+		},
+	}
+	_, err := kube.NetworkingV1().NetworkPolicies(release.Namespace).Create(ctx, policy, v1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create frozen policy: %w", err)
+	}
+
+	// policies admit traffic together, the ones that let everything in
+	// leave out the release's instance, which restarted pods keep as well.
+	// Relabeling the template instead would roll out fresh pods and lose
+	// what is to be graded.
+	if err := excludeFrozen(ctx, kube, release.Namespace); err != nil {
+		return err
+	}
+	return relabel(ctx, kube, release, map[string]string{frozenLabel: "true"})
+}
+
+func deleteFrozenPolicy(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	err := kube.NetworkingV1().NetworkPolicies(release.Namespace).Delete(ctx, frozenPolicyName(release), v1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete frozen policy: %w", err)
+	}
+	return excludeFrozen(ctx, kube, release.Namespace)
+}
+
+// frozenReleases lists the releases of a namespace that have been frozen.
+func frozenReleases(ctx context.Context, kube kubernetes.Interface, namespace string) ([]string, error) {
+	policies, err := kube.NetworkingV1().NetworkPolicies(namespace).List(ctx, v1.ListOptions{LabelSelector: frozenLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list frozen policies: %w", err)
+	}
+	releases := []string{}
+	for _, policy := range policies.Items {
+		releases = append(releases, policy.Labels[instanceLabel])
+	}
+	sort.Strings(releases)
+	return releases, nil
+}
+
+// unfrozenSelector selects the pods that are neither labeled frozen nor
+// belong to one of the frozen releases.
+func unfrozenSelector(frozen []string) v1.LabelSelector {
+	selector := v1.LabelSelector{MatchExpressions: []v1.LabelSelectorRequirement{{
+		Key:      frozenLabel,
+		Operator: v1.LabelSelectorOpDoesNotExist,
+	}}}
+	if len(frozen) > 0 {
+		selector.MatchExpressions = append(selector.MatchExpressions, v1.LabelSelectorRequirement{
+			Key:      instanceLabel,
+			Operator: v1.LabelSelectorOpNotIn,
+			Values:   frozen,
+		})
+	}
+	return selector
+}
+
+// excludeFrozen points the isolation policies of a namespace that leave
+// frozen pods out at its current frozen releases.
+func excludeFrozen(ctx context.Context, kube kubernetes.Interface, namespace string) error {
+	frozen, err := frozenReleases(ctx, kube, namespace)
+	if err != nil {
+		return err
+	}
+
+	policies := kube.NetworkingV1().NetworkPolicies(namespace)
+	list, err := policies.List(ctx, v1.ListOptions{LabelSelector: managedByLabel + "=" + managedBy})
+	if err != nil {
+		return fmt.Errorf("failed to list network policies: %w", err)
+	}
+	for i := range list.Items {
+		policy := &list.Items[i]
+		if !leavesFrozenOut(policy.Spec.PodSelector) {
+			continue
+		}
+		policy.Spec.PodSelector = unfrozenSelector(frozen)
+		if _, err := policies.Update(ctx, policy, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update network policy %s: %w", policy.Name, err)
+		}
+	}
+	return nil
+}
+
+func leavesFrozenOut(selector v1.LabelSelector) bool {
+	for _, expr := range selector.MatchExpressions {
+		if expr.Key == frozenLabel && expr.Operator == v1.LabelSelectorOpDoesNotExist {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFreeze(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	release := Release{Name: "atoken", Namespace: "challenge"}
+	if err := m.Deploy(ctx, &Spec{Release: release}); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	// the fake clientset runs no controllers, add the pod by hand
+	kube.CoreV1().Pods("challenge").Create(ctx, &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: "atoken-challenge-abc", Labels: map[string]string{"app.kubernetes.io/instance": "atoken"}},
+	}, v1.CreateOptions{})
+
+	if err := Freeze(ctx, kube, release); err != nil {
+		t.Fatalf("Freeze() error = %v", err)
+	}
+	// submissions may be retried
+	if err := Freeze(ctx, kube, release); err != nil {
+		t.Fatalf("second Freeze() error = %v", err)
+	}
+
+	pod, _ := kube.CoreV1().Pods("challenge").Get(ctx, "atoken-challenge-abc", v1.GetOptions{})
+	if pod.Labels[frozenLabel] != "true" {
+		t.Errorf("pod labels = %v, want it marked frozen", pod.Labels)
+	}
+
+	policy, err := kube.NetworkingV1().NetworkPolicies("challenge").Get(ctx, "atoken-frozen", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("frozen policy not created: %v", err)
+	}
+	peers := policy.Spec.Ingress[0].From
+	if len(peers) != 2 || peers[1].PodSelector.MatchLabels[graderLabel] != "atoken" {
+		t.Errorf("frozen policy should only admit the release and its grader, got %+v", peers)
+	}
+
+	if err := m.Teardown(ctx, release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := kube.NetworkingV1().NetworkPolicies("challenge").Get(ctx, "atoken-frozen", v1.GetOptions{}); err == nil {
+		t.Error("frozen policy still exists after Teardown")
+	}
+}
+
+func TestFreezeOutlivesRestart(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	isolation := &Isolation{Mode: IsolationChallenge, Pods: 2, Defaults: Tiers["small"], PodCIDR: "10.244.0.0/16"}
+	frozen := Release{Name: "afrozen", Namespace: "cctf"}
+	other := Release{Name: "aother", Namespace: "cctf"}
+	for _, release := range []Release{frozen, other} {
+		if err := m.Deploy(ctx, &Spec{Release: release, Isolation: isolation}); err != nil {
+			t.Fatalf("Deploy() error = %v", err)
+		}
+	}
+	if err := Freeze(ctx, kube, frozen); err != nil {
+		t.Fatalf("Freeze() error = %v", err)
+	}
+
+	// the pod the deployment recreates has the labels of its template only
+	restarted := labels.Set{instanceLabel: frozen.Name}
+	running := labels.Set{instanceLabel: other.Name}
+	admitted := func() (frozenAdmitted, otherAdmitted bool) {
+		policies, _ := kube.NetworkingV1().NetworkPolicies("cctf").List(ctx, v1.ListOptions{})
+		for _, p := range policies.Items {
+			if !leavesFrozenOut(p.Spec.PodSelector) {
+				continue
+			}
+			selector, err := v1.LabelSelectorAsSelector(&p.Spec.PodSelector)
+			if err != nil {
+				t.Fatalf("policy %s selector: %v", p.Name, err)
+			}
+			frozenAdmitted = frozenAdmitted || selector.Matches(restarted)
+			otherAdmitted = otherAdmitted || selector.Matches(running)
+		}
+		return frozenAdmitted, otherAdmitted
+	}
+
+	if frozenAdmitted, otherAdmitted := admitted(); frozenAdmitted || !otherAdmitted {
+		t.Errorf("after freeze the restarted pod is admitted = %v, the other release = %v", frozenAdmitted, otherAdmitted)
+	}
+
+	// deploying another attempt rewrites the policies of the namespace
+	if err := m.Deploy(ctx, &Spec{Release: other, Isolation: isolation}); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	if frozenAdmitted, _ := admitted(); frozenAdmitted {
+		t.Error("redeploying the namespace admits the frozen release again")
+	}
+
+	if err := m.Teardown(ctx, frozen); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if frozenAdmitted, _ := admitted(); !frozenAdmitted {
+		t.Error("a release torn down is still left out of the namespace policies")
+	}
+}
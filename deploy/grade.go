@@ -0,0 +1,234 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// graderLabel marks the jobs that grade a release.
+const graderLabel = "cob.sys.io/grader"
+
+// GradePollInterval is how often a grader job is checked for completion.
+var GradePollInterval = 2 * time.Second
+
+// ErrGraderFailed is returned when a grader job fails without printing a
+// result.
+var ErrGraderFailed = errors.New("grader failed")
+
+// Grader grades a release, by running Command in its pod or by running
+// Image as a job that reaches it over the network.
+type Grader struct {
+	Command []string
+	Image   string
+	Timeout time.Duration
+	// Env is passed to grader jobs, next to ATTEMPT_HOST and ATTEMPT_PORT.
+	Env []EnvVar
+}
+
+// GradeCheck is the outcome of one check a grader ran.
+type GradeCheck struct {
+	Name    string  `json:"name"`
+	Passed  bool    `json:"passed"`
+	Score   float64 `json:"score,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// GradeResult is what a grader prints on stdout as JSON.
+type GradeResult struct {
+	Score    float64      `json:"score"`
+	Feedback string       `json:"feedback,omitempty"`
+	Checks   []GradeCheck `json:"checks,omitempty"`
+}
+
+// ParseGradeResult reads a grader's output, either a JSON document or log
+// lines ending with one. Without a score, the scores of the checks are
+// summed.
+func ParseGradeResult(output []byte) (*GradeResult, error) {
+	var parsed struct {
+		Score    *float64     `json:"score"`
+		Feedback string       `json:"feedback"`
+		Checks   []GradeCheck `json:"checks"`
+	}
+	if err := json.Unmarshal(output, &parsed); err != nil {
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		last := strings.TrimSpace(lines[len(lines)-1])
+		if err := json.Unmarshal([]byte(last), &parsed); err != nil {
+			return nil, fmt.Errorf("grader printed no JSON result: %w", err)
+		}
+	}
+
+	if parsed.Score == nil && len(parsed.Checks) == 0 {
+		return nil, errors.New("grader result has neither a score nor checks")
+	}
+	result := &GradeResult{Feedback: parsed.Feedback, Checks: parsed.Checks}
+	if parsed.Score != nil {
+		result.Score = *parsed.Score
+	} else {
+		for _, check := range parsed.Checks {
+			result.Score += check.Score
+		}
+	}
+	return result, nil
+}
+
+// Grade runs the grader against the release and returns its result.
+func Grade(ctx context.Context, restConfig *rest.Config, kube kubernetes.Interface, release Release, grader Grader) (*GradeResult, error) {
+	if grader.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, grader.Timeout)
+		defer cancel()
+	}
+
+	if len(grader.Command) > 0 {
+		return gradeExec(ctx, restConfig, kube, release, grader.Command)
+	}
+	return gradeJob(ctx, kube, release, grader)
+}
+
+// gradeExec runs the grading command in the release's pod.
+func gradeExec(ctx context.Context, restConfig *rest.Config, kube kubernetes.Interface, release Release, command []string) (*GradeResult, error) {
+	pod, err := releasePod(ctx, kube, release)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	execErr := podExec(ctx, restConfig, kube, pod, command, nil, &stdout)
+	// graders may exit non-zero when checks fail, the result still counts
+	result, err := ParseGradeResult(stdout.Bytes())
+	if err != nil && execErr != nil {
+		return nil, fmt.Errorf("grading %s: %w", release.Name, execErr)
+	}
+	return result, err
+}
+
+// gradeJob runs the grader image as a job in the release's namespace and
+// reads the result from its logs. The job is removed once done.
+func gradeJob(ctx context.Context, kube kubernetes.Interface, release Release, grader Grader) (*GradeResult, error) {
+	addr, err := ServiceAddress(ctx, kube, release)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	env := []corev1.EnvVar{{Name: "ATTEMPT_HOST", Value: host}, {Name: "ATTEMPT_PORT", Value: port}}
+	for _, e := range grader.Env {
+		env = append(env, corev1.EnvVar{Name: e.Name, Value: e.Value})
+	}
+
+	jobs := kube.BatchV1().Jobs(release.Namespace)
+	name := fmt.Sprintf("%s-grader", release.Name)
+	background := v1.DeletePropagationBackground
+	// a job left over from an earlier submission would never be replaced
+	if err := jobs.Delete(ctx, name, v1.DeleteOptions{PropagationPolicy: &background}); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	backoffLimit := int32(0)
+	var deadline *int64
+	if grader.Timeout > 0 {
+		seconds := int64(grader.Timeout.Seconds())
+		deadline = &seconds
+	}
+	labels := map[string]string{managedByLabel: managedBy, graderLabel: release.Name}
+	job := &batchv1.Job{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: release.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: defaultPullSecret}},
+					Containers: []corev1.Container{{
+						Name:  "grader",
+						Image: grader.Image,
+						Env:   env,
+					}},
+				},
+			},
+		},
+	}
+	if _, err := jobs.Create(ctx, job, v1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := jobs.Delete(context.Background(), name, v1.DeleteOptions{PropagationPolicy: &background}); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Failed to delete grader job %s: %s", name, err)
+		}
+	}()
+
+	succeeded, err := waitJob(ctx, kube, release.Namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := kube.CoreV1().Pods(release.Namespace).List(ctx, v1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", graderLabel, release.Name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("grader job %s has no pod", name)
+	}
+	output, err := podLogs(ctx, kube, &pods.Items[0])
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ParseGradeResult(output)
+	if err != nil && !succeeded {
+		return nil, fmt.Errorf("%w: job %s", ErrGraderFailed, name)
+	}
+	return result, err
+}
+
+// waitJob polls a job until it finished, reporting whether it succeeded.
+func waitJob(ctx context.Context, kube kubernetes.Interface, namespace, name string) (bool, error) {
+	ticker := time.NewTicker(GradePollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := kube.BatchV1().Jobs(namespace).Get(ctx, name, v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if job.Status.Succeeded > 0 {
+			return true, nil
+		}
+		if job.Status.Failed > 0 {
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// podLogs returns the output of a pod's first container, a var so tests
+// can stub it.
+var podLogs = func(ctx context.Context, kube kubernetes.Interface, pod *corev1.Pod) ([]byte, error) {
+	return kube.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+}
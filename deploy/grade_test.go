@@ -0,0 +1,204 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseGradeResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    GradeResult
+		wantErr bool
+	}{
+		{
+			name:   "document",
+			output: `{"score": 7.5, "feedback": "close"}`,
+			want:   GradeResult{Score: 7.5, Feedback: "close"},
+		},
+		{
+			name:   "last line after logs",
+			output: "checking port\nchecking flag\n{\"score\": 10}\n",
+			want:   GradeResult{Score: 10},
+		},
+		{
+			name:   "score from checks",
+			output: `{"checks": [{"name": "port", "passed": true, "score": 2}, {"name": "flag", "passed": false}]}`,
+			want: GradeResult{Score: 2, Checks: []GradeCheck{
+				{Name: "port", Passed: true, Score: 2},
+				{Name: "flag"},
+			}},
+		},
+		{
+			name:   "explicit zero score",
+			output: `{"score": 0, "checks": [{"name": "port", "passed": true, "score": 2}]}`,
+			want:   GradeResult{Checks: []GradeCheck{{Name: "port", Passed: true, Score: 2}}},
+		},
+		{
+			name:    "no JSON",
+			output:  "Traceback (most recent call last):",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			output:  "",
+			wantErr: true,
+		},
+		{
+			name:    "no score",
+			output:  `{"feedback": "nothing"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseGradeResult([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGradeResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Score != tt.want.Score || got.Feedback != tt.want.Feedback || len(got.Checks) != len(tt.want.Checks) {
+				t.Fatalf("ParseGradeResult() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got.Checks {
+				if got.Checks[i] != tt.want.Checks[i] {
+					t.Errorf("check %d = %+v, want %+v", i, got.Checks[i], tt.want.Checks[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGradeExec(t *testing.T) {
+	ctx := context.Background()
+	release := Release{Name: "atoken", Namespace: "challenge"}
+	kube := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "atoken-challenge-abc",
+			Namespace: "challenge",
+			Labels:    map[string]string{"app.kubernetes.io/instance": "atoken"},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		output  string
+		execErr error
+		want    float64
+		wantErr bool
+	}{
+		{name: "passed", output: `{"score": 10}`, want: 10},
+		{name: "failing checks exit non-zero", output: `{"score": 3}`, execErr: errors.New("exit code 1"), want: 3},
+		{name: "crashed", output: "panic", execErr: errors.New("exit code 2"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			defer func(orig func(context.Context, *rest.Config, kubernetes.Interface, *corev1.Pod, []string, io.Reader, io.Writer) error) {
+				podExec = orig
+			}(podExec)
+			podExec = func(ctx context.Context, restConfig *rest.Config, kube kubernetes.Interface, pod *corev1.Pod, command []string, stdin io.Reader, stdout io.Writer) error {
+				ran = command
+				io.WriteString(stdout, tt.output)
+				return tt.execErr
+			}
+
+			got, err := Grade(ctx, nil, kube, release, Grader{Command: []string{"/grade.sh"}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Grade() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(ran) != 1 || ran[0] != "/grade.sh" {
+				t.Errorf("ran %v, want the grading command", ran)
+			}
+			if err == nil && got.Score != tt.want {
+				t.Errorf("Grade() score = %v, want %v", got.Score, tt.want)
+			}
+		})
+	}
+}
+
+func TestGradeJob(t *testing.T) {
+	defer func(interval time.Duration) { GradePollInterval = interval }(GradePollInterval)
+	GradePollInterval = time.Millisecond
+
+	ctx := context.Background()
+	release := Release{Name: "atoken", Namespace: "challenge"}
+	kube := fake.NewSimpleClientset(&corev1.Service{
+		ObjectMeta: v1.ObjectMeta{Name: "atoken-challenge", Namespace: "challenge"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.96.0.7",
+			Ports:     []corev1.ServicePort{{Name: "ssh", Port: 22}},
+		},
+	})
+
+	// the fake clientset runs no controllers, finish the job and add its
+	// pod when it is created
+	var created *batchv1.Job
+	kube.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		created = job.DeepCopy()
+		job.Status.Succeeded = 1
+		kube.Tracker().Add(&corev1.Pod{ObjectMeta: v1.ObjectMeta{
+			Name:      job.Name + "-xyz",
+			Namespace: job.Namespace,
+			Labels:    job.Spec.Template.Labels,
+		}})
+		return false, nil, nil
+	})
+
+	var logsOf string
+	defer func(orig func(context.Context, kubernetes.Interface, *corev1.Pod) ([]byte, error)) {
+		podLogs = orig
+	}(podLogs)
+	podLogs = func(ctx context.Context, kube kubernetes.Interface, pod *corev1.Pod) ([]byte, error) {
+		logsOf = pod.Name
+		return []byte("connecting\n{\"score\": 4, \"feedback\": \"flag missing\"}\n"), nil
+	}
+
+	got, err := Grade(ctx, nil, kube, release, Grader{
+		Image:   "registry.example/grader:1",
+		Timeout: time.Minute,
+		Env:     []EnvVar{{Name: "ATTEMPT_TOKEN", Value: "atoken"}},
+	})
+	if err != nil {
+		t.Fatalf("Grade() error = %v", err)
+	}
+	if got.Score != 4 || got.Feedback != "flag missing" {
+		t.Errorf("Grade() = %+v", got)
+	}
+	if logsOf != "atoken-grader-xyz" {
+		t.Errorf("read logs of %q, want the grader pod", logsOf)
+	}
+
+	if created == nil {
+		t.Fatal("no grader job was created")
+	}
+	env := map[string]string{}
+	for _, e := range created.Spec.Template.Spec.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["ATTEMPT_HOST"] != "10.96.0.7" || env["ATTEMPT_PORT"] != "22" || env["ATTEMPT_TOKEN"] != "atoken" {
+		t.Errorf("grader env = %v", env)
+	}
+	if deadline := created.Spec.ActiveDeadlineSeconds; deadline == nil || *deadline != 60 {
+		t.Errorf("active deadline = %v, want 60", deadline)
+	}
+	if _, err := kube.BatchV1().Jobs("challenge").Get(ctx, "atoken-grader", v1.GetOptions{}); err == nil {
+		t.Error("grader job was not removed")
+	}
+}
@@ -0,0 +1,231 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/repo"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+type HelmOptions struct {
+	// RestConfig is used when KubeConfig is empty.
+	RestConfig *rest.Config
+	// KubeConfig is the raw kubeconfig file, used for local development.
+	KubeConfig []byte
+	Kube       kubernetes.Interface
+	Namespace  string
+	Repo       repo.Entry
+	ChartName  string
+	Debug      bool
+	// Endpoints resolves participant addresses, NodePortResolver if nil.
+	Endpoints EndpointResolver
+	// AuthorizedKeysPath is where Inject writes the attempt's public key,
+	// the chart must leave it writable when no key is set.
+	AuthorizedKeysPath string
+}
+
+// HelmDeployer installs the challenge chart from the configured Helm repo.
+type HelmDeployer struct {
+	opts HelmOptions
+	kube kubernetes.Interface
+
+	// helm clients are bound to the namespace their releases are stored in
+	mu      sync.Mutex
+	clients map[string]helmclient.Client
+}
+
+func NewHelmDeployer(opts HelmOptions) (*HelmDeployer, error) {
+	if opts.Endpoints == nil {
+		opts.Endpoints = NodePortResolver{}
+	}
+	if opts.AuthorizedKeysPath == "" {
+		opts.AuthorizedKeysPath = defaultAuthorizedKeysPath
+	}
+
+	h := &HelmDeployer{
+		opts:    opts,
+		kube:    opts.Kube,
+		clients: map[string]helmclient.Client{},
+	}
+
+	// fail early if helm cannot be configured at all
+	if _, err := h.client(opts.Namespace); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// client returns the helm client for namespace, creating it on first use.
+func (h *HelmDeployer) client(namespace string) (helmclient.Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if client, ok := h.clients[namespace]; ok {
+		return client, nil
+	}
+
+	options := &helmclient.Options{
+		Namespace:        namespace,
+		RepositoryCache:  "/tmp/.helmcache",
+		RepositoryConfig: "/tmp/.helmrepo",
+		Debug:            h.opts.Debug,
+		Linting:          true,
+	}
+	if !h.opts.Debug {
+		options.DebugLog = func(format string, v ...interface{}) {}
+	}
+
+	var client helmclient.Client
+	var err error
+	if len(h.opts.KubeConfig) > 0 {
+		client, err = helmclient.NewClientFromKubeConf(&helmclient.KubeConfClientOptions{
+			Options:    options,
+			KubeConfig: h.opts.KubeConfig,
+		})
+	} else {
+		client, err = helmclient.NewClientFromRestConf(&helmclient.RestConfClientOptions{
+			Options:    options,
+			RestConfig: h.opts.RestConfig,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create helm client: %w", err)
+	}
+
+	h.clients[namespace] = client
+	return client, nil
+}
+
+func (h *HelmDeployer) Deploy(ctx context.Context, spec *Spec) error {
+	client, err := h.client(spec.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// add the chart repo reference
+	if err := client.AddOrUpdateChartRepo(h.opts.Repo); err != nil {
+		return fmt.Errorf("failed to add or update helm chart repo: %w", err)
+	}
+
+	chartName := fmt.Sprintf("%s/%s", h.opts.Repo.Name, h.opts.ChartName)
+
+	values, err := Values(spec)
+	if err != nil {
+		return err
+	}
+	setValue(values, []string{"service", "type"}, string(h.opts.Endpoints.ServiceType()))
+
+	// check the values against the chart's values.schema.json
+	chrt, _, err := client.GetChart(chartName, &action.ChartPathOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to load chart %s: %w", chartName, err)
+	}
+	merged, err := chartutil.CoalesceValues(chrt, values)
+	if err != nil {
+		return fmt.Errorf("failed to merge chart values: %w", err)
+	}
+	if err := chartutil.ValidateAgainstSchema(chrt, merged); err != nil {
+		return fmt.Errorf("chart values failed validation: %w", err)
+	}
+
+	valuesYaml, err := yaml.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode chart values: %w", err)
+	}
+
+	chartSpec := helmclient.ChartSpec{
+		ReleaseName:     spec.Name,
+		ChartName:       chartName,
+		Namespace:       spec.Namespace,
+		CreateNamespace: true,
+		GenerateName:    true,
+		ValuesYaml:      string(valuesYaml),
+	}
+
+	if err := ensureIsolation(ctx, h.kube, spec); err != nil {
+		return err
+	}
+	if err := ensureEgressPolicy(ctx, h.kube, spec); err != nil {
+		return err
+	}
+	if err := ensurePullSecret(ctx, h.kube, spec); err != nil {
+		return err
+	}
+
+	if _, err := client.InstallOrUpgradeChart(ctx, &chartSpec, nil); err != nil {
+		// don't leave credentials and policies behind for a release that never came up
+		if err := deleteReleaseObjects(ctx, h.kube, spec.Release); err != nil {
+			log.Printf("Failed to clean up release %s: %s", spec.Name, err)
+		}
+		return fmt.Errorf("failed to install or upgrade chart: %w", err)
+	}
+	return nil
+}
+
+func (h *HelmDeployer) Status(ctx context.Context, release Release) (Status, error) {
+	return podStatus(ctx, h.kube, release)
+}
+
+func (h *HelmDeployer) Endpoint(ctx context.Context, release Release) (*Endpoint, error) {
+	return h.opts.Endpoints.Resolve(ctx, h.kube, release)
+}
+
+func (h *HelmDeployer) Inject(ctx context.Context, release Release, injection *Injection) error {
+	return inject(ctx, h.opts.RestConfig, h.kube, release, h.opts.AuthorizedKeysPath, injection)
+}
+
+// List finds releases through the secrets helm stores them in, in the
+// default namespace and those the service created.
+func (h *HelmDeployer) List(ctx context.Context) ([]ListedRelease, error) {
+	namespaces, err := managedNamespaces(ctx, h.kube, h.opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := h.kube.CoreV1().Secrets(v1.NamespaceAll).List(ctx, v1.ListOptions{LabelSelector: "owner=helm"})
+	if err != nil {
+		return nil, err
+	}
+
+	// every revision has its own secret, the first one dates the release
+	created := map[Release]time.Time{}
+	for _, secret := range secrets.Items {
+		if !namespaces[secret.Namespace] {
+			continue
+		}
+		release := Release{Name: secret.Labels["name"], Namespace: secret.Namespace}
+		timestamp := secret.CreationTimestamp.Time
+		if first, ok := created[release]; !ok || timestamp.Before(first) {
+			created[release] = timestamp
+		}
+	}
+	return listed(created), nil
+}
+
+func (h *HelmDeployer) Teardown(ctx context.Context, release Release) error {
+	client, err := h.client(release.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// a release that is gone or never got installed still has its side objects removed
+	if err := client.UninstallReleaseByName(release.Name); err != nil && !errors.Is(err, driver.ErrReleaseNotFound) {
+		return err
+	}
+	if err := deleteReleaseObjects(ctx, h.kube, release); err != nil {
+		return err
+	}
+	return deleteOwnedNamespace(ctx, h.kube, release)
+}
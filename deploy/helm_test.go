@@ -0,0 +1,78 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	helmclient "github.com/mittwald/go-helm-client"
+	"helm.sh/helm/v3/pkg/action"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sys.io/challenge-service/utils"
+)
+
+// fakeHelmDeployer returns a HelmDeployer whose helm client keeps its
+// releases in secrets of kube, as helm does on a real cluster.
+func fakeHelmDeployer(kube *fake.Clientset, namespace string) *HelmDeployer {
+	debug := func(format string, v ...interface{}) {}
+	client := &helmclient.HelmClient{
+		ActionConfig: &action.Configuration{
+			Releases:   storage.Init(driver.NewSecrets(kube.CoreV1().Secrets(namespace))),
+			KubeClient: &kubefake.PrintingKubeClient{},
+			Log:        debug,
+		},
+		DebugLog: debug,
+	}
+	return &HelmDeployer{
+		opts:    HelmOptions{Endpoints: NodePortResolver{}},
+		kube:    kube,
+		clients: map[string]helmclient.Client{namespace: client},
+	}
+}
+
+func TestHelmTeardownNeverInstalled(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	h := fakeHelmDeployer(kube, "atoken")
+
+	// the service's own objects exist, the helm install never happened
+	spec := &Spec{
+		Release: Release{Name: "atoken", Namespace: "atoken"},
+		Image:   utils.ImageReference{Registry: "host:5000", Repository: "img", Tag: "v1"},
+		PullCredentials: &RegistryCredentials{
+			Server:   "host:5000",
+			Username: "user",
+			Password: "secret",
+		},
+		Isolation: &Isolation{
+			Mode:     IsolationAttempt,
+			Pods:     1,
+			Defaults: Tiers["small"],
+			PodCIDR:  "10.244.0.0/16",
+		},
+	}
+	if err := ensureIsolation(ctx, kube, spec); err != nil {
+		t.Fatalf("ensureIsolation() error = %v", err)
+	}
+	if err := ensurePullSecret(ctx, kube, spec); err != nil {
+		t.Fatalf("ensurePullSecret() error = %v", err)
+	}
+
+	if err := h.Teardown(ctx, spec.Release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := kube.CoreV1().Secrets("atoken").Get(ctx, "atoken-pull", v1.GetOptions{}); err == nil {
+		t.Errorf("pull secret still exists after Teardown")
+	}
+	if _, err := kube.CoreV1().Namespaces().Get(ctx, "atoken", v1.GetOptions{}); err == nil {
+		t.Errorf("isolated namespace still exists after Teardown")
+	}
+
+	// tearing down again finds nothing left and succeeds
+	if err := h.Teardown(ctx, spec.Release); err != nil {
+		t.Fatalf("second Teardown() error = %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// AttemptEnvPath is where an attempt's env values are written, as shell
+// exports challenge scripts can source. Every attempt has the file, whether
+// its release was deployed for it or claimed from a warm pool. Only the
+// former also has ATTEMPT_TOKEN in its process env, a pool instance started
+// before it was claimed, so images read attempt values from the file.
+const AttemptEnvPath = "/etc/challenge/attempt.env"
+
+// AttemptDir is where releases deployed without a key mount their attempt
+// secret, from which the injection is restored when the container restarts.
+const AttemptDir = "/etc/challenge/attempt"
+
+// AttemptLabel marks the objects of a release handed to an attempt.
+const AttemptLabel = "cob.sys.io/attempt"
+
+// attemptSecretName names the secret keeping a release's injection.
+func attemptSecretName(release Release) string {
+	return fmt.Sprintf("%s-attempt", release.Name)
+}
+
+// Injection hands a running release to an attempt.
+type Injection struct {
+	AuthorizedKeys string
+	Env            []EnvVar
+	// Labels are added to the release's pods, deployment and service.
+	Labels map[string]string
+}
+
+// Injector is implemented by deployers that can hand a running release to
+// an attempt without redeploying it, which warm pools rely on. Releases are
+// deployed without authorized keys for this, a mounted key file would be
+// read-only. The injection is kept in the release's attempt secret so that
+// it outlives the container.
+type Injector interface {
+	Inject(ctx context.Context, release Release, injection *Injection) error
+}
+
+// ErrExecUnavailable means the deployer has no REST config to exec with.
+var ErrExecUnavailable = errors.New("exec into pods is not configured")
+
+// podExec runs a command in a pod's first container, copying its output to
+// stdout if set, a var so tests can stub it.
+var podExec = func(ctx context.Context, restConfig *rest.Config, kube kubernetes.Interface, pod *corev1.Pod, command []string, stdin io.Reader, stdout io.Writer) error {
+	if restConfig == nil {
+		return ErrExecUnavailable
+	}
+
+	req := kube.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: pod.Spec.Containers[0].Name,
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(restConfig, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	var stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("exec %s in %s: %w: %s", command[0], pod.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// inject stores the injection in the release's attempt secret, writes its
+// key and env into the release's pod and labels its objects.
+func inject(ctx context.Context, restConfig *rest.Config, kube kubernetes.Interface, release Release, authorizedKeysPath string, injection *Injection) error {
+	if err := ensureAttemptSecret(ctx, kube, release, injection); err != nil {
+		return err
+	}
+
+	pod, err := releasePod(ctx, kube, release)
+	if err != nil {
+		return err
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return fmt.Errorf("pod %s has no containers", pod.Name)
+	}
+
+	if err := podExec(ctx, restConfig, kube, pod, writeFileCommand(authorizedKeysPath), strings.NewReader(injection.AuthorizedKeys), nil); err != nil {
+		return err
+	}
+	if err := podExec(ctx, restConfig, kube, pod, writeFileCommand(AttemptEnvPath), strings.NewReader(envFile(injection.Env)), nil); err != nil {
+		return err
+	}
+
+	return relabel(ctx, kube, release, injection.Labels)
+}
+
+// ensureAttemptSecret creates or replaces the release's attempt secret with
+// the injection's key, env file and env values.
+func ensureAttemptSecret(ctx context.Context, kube kubernetes.Interface, release Release, injection *Injection) error {
+	data := map[string]string{
+		"authorized_keys": injection.AuthorizedKeys,
+		"attempt.env":     envFile(injection.Env),
+	}
+	for _, e := range injection.Env {
+		data[e.Name] = e.Value
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      attemptSecretName(release),
+			Namespace: release.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/instance": release.Name,
+				managedByLabel:               managedBy,
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: data,
+	}
+
+	secrets := kube.CoreV1().Secrets(release.Namespace)
+	if _, err := secrets.Create(ctx, secret, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create attempt secret: %w", err)
+		}
+		if _, err := secrets.Update(ctx, secret, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update attempt secret: %w", err)
+		}
+	}
+	return nil
+}
+
+func deleteAttemptSecret(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	err := kube.CoreV1().Secrets(release.Namespace).Delete(ctx, attemptSecretName(release), v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete attempt secret: %w", err)
+	}
+	return nil
+}
+
+// restoreCommand copies the injection from the mounted attempt secret back
+// into place, for containers restarting after they were claimed. Before the
+// claim the secret is missing and there is nothing to restore.
+func restoreCommand(authorizedKeysPath string) []string {
+	script := `d=` + AttemptDir + `; [ -s "$d/authorized_keys" ] || exit 0
+mkdir -p "$(dirname "$1")" "$(dirname "$2")"
+cp "$d/authorized_keys" "$1" && chmod 600 "$1"
+cp "$d/attempt.env" "$2" && chmod 600 "$2"
+exit 0`
+	return []string{"sh", "-c", script, "restore", authorizedKeysPath, AttemptEnvPath}
+}
+
+// writeFileCommand stores stdin in path, readable by its owner only.
+func writeFileCommand(path string) []string {
+	return []string{"sh", "-c", `set -e; mkdir -p "$(dirname "$1")"; cat > "$1"; chmod 600 "$1"`, "inject", path}
+}
+
+// envFile renders env values as single-quoted shell exports.
+func envFile(env []EnvVar) string {
+	var b strings.Builder
+	for _, e := range env {
+		fmt.Fprintf(&b, "export %s='%s'\n", e.Name, strings.ReplaceAll(e.Value, "'", `'\''`))
+	}
+	return b.String()
+}
+
+// relabel adds labels to the release's pods, deployment and service.
+func relabel(ctx context.Context, kube kubernetes.Interface, release Release, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if err != nil {
+		return err
+	}
+
+	pods, err := kube.CoreV1().Pods(release.Namespace).List(ctx, v1.ListOptions{LabelSelector: instanceSelector(release)})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		if _, err := kube.CoreV1().Pods(release.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, v1.PatchOptions{}); err != nil {
+			return err
+		}
+	}
+
+	deployments, err := kube.AppsV1().Deployments(release.Namespace).List(ctx, v1.ListOptions{LabelSelector: instanceSelector(release)})
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments.Items {
+		if _, err := kube.AppsV1().Deployments(release.Namespace).Patch(ctx, deployment.Name, types.MergePatchType, patch, v1.PatchOptions{}); err != nil {
+			return err
+		}
+	}
+
+	_, err = kube.CoreV1().Services(release.Namespace).Patch(ctx, serviceName(release), types.MergePatchType, patch, v1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,88 @@
+package deploy
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestManifestInject(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	// a pool instance, deployed without a key
+	release := Release{Name: "pwarm", Namespace: "challenge"}
+	if err := m.Deploy(ctx, &Spec{Release: release}); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	deployment, _ := kube.AppsV1().Deployments("challenge").Get(ctx, "pwarm-challenge", v1.GetOptions{})
+	container := deployment.Spec.Template.Spec.Containers[0]
+	for _, mount := range container.VolumeMounts {
+		if mount.MountPath != AttemptDir {
+			t.Errorf("keyless release mounts %v, the key path must stay writable", mount)
+		}
+	}
+	if container.Lifecycle == nil || container.Lifecycle.PostStart == nil {
+		t.Error("keyless release does not restore its injection on restart")
+	}
+	token := container.Env[len(container.Env)-1]
+	if token.Name != "ATTEMPT_TOKEN" || token.ValueFrom.SecretKeyRef.Name != "pwarm-attempt" {
+		t.Errorf("ATTEMPT_TOKEN env = %+v, want it from the attempt secret", token)
+	}
+
+	// the fake clientset runs no controllers, add the pod by hand
+	kube.CoreV1().Pods("challenge").Create(ctx, &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{Name: "pwarm-challenge-abc", Labels: map[string]string{"app.kubernetes.io/instance": "pwarm"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "challenge"}}},
+	}, v1.CreateOptions{})
+
+	written := map[string]string{}
+	defer func(orig func(context.Context, *rest.Config, kubernetes.Interface, *corev1.Pod, []string, io.Reader, io.Writer) error) {
+		podExec = orig
+	}(podExec)
+	podExec = func(ctx context.Context, restConfig *rest.Config, kube kubernetes.Interface, pod *corev1.Pod, command []string, stdin io.Reader, stdout io.Writer) error {
+		content, _ := io.ReadAll(stdin)
+		written[command[len(command)-1]] = string(content)
+		return nil
+	}
+
+	err := m.Inject(ctx, release, &Injection{
+		AuthorizedKeys: "ssh-rsa AAAA",
+		Env:            []EnvVar{{Name: "ATTEMPT_TOKEN", Value: "it's"}},
+		Labels:         map[string]string{AttemptLabel: "token"},
+	})
+	if err != nil {
+		t.Fatalf("Inject() error = %v", err)
+	}
+
+	if written[defaultAuthorizedKeysPath] != "ssh-rsa AAAA" {
+		t.Errorf("authorized keys = %q", written[defaultAuthorizedKeysPath])
+	}
+	if want := "export ATTEMPT_TOKEN='it'\\''s'\n"; written[AttemptEnvPath] != want {
+		t.Errorf("env file = %q, want %q", written[AttemptEnvPath], want)
+	}
+
+	// kept for the container to restore after a restart
+	secret, err := kube.CoreV1().Secrets("challenge").Get(ctx, "pwarm-attempt", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("attempt secret not created: %v", err)
+	}
+	if secret.StringData["authorized_keys"] != "ssh-rsa AAAA" || secret.StringData["ATTEMPT_TOKEN"] != "it's" {
+		t.Errorf("attempt secret = %v", secret.StringData)
+	}
+
+	pod, _ := kube.CoreV1().Pods("challenge").Get(ctx, "pwarm-challenge-abc", v1.GetOptions{})
+	service, _ := kube.CoreV1().Services("challenge").Get(ctx, "pwarm-challenge", v1.GetOptions{})
+	for name, labels := range map[string]map[string]string{"pod": pod.Labels, "service": service.Labels} {
+		if labels[AttemptLabel] != "token" {
+			t.Errorf("%s labels = %v, want the attempt label", name, labels)
+		}
+	}
+}
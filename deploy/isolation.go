@@ -0,0 +1,316 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// how attempts are spread over namespaces
+const (
+	IsolationShared    = "shared"
+	IsolationAttempt   = "attempt"
+	IsolationChallenge = "challenge"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "challenge-service"
+	isolationLabel = "cob.sys.io/isolation"
+	// ownerLabel names the release whose teardown deletes the namespace
+	ownerLabel = "cob.sys.io/owner"
+)
+
+// Isolation asks for the release's namespace to be set up for it alone
+// (IsolationAttempt) or for all attempts of one challenge (IsolationChallenge).
+type Isolation struct {
+	Mode string
+	// Pods caps the number of pods in the namespace.
+	Pods int
+	// Defaults are given to containers without their own resources and,
+	// multiplied by Pods, form the namespace's quota.
+	Defaults Resources
+	// PodCIDR is the cluster's pod network, excluded from the ingress that is
+	// allowed from outside the namespace. Without it that ingress admits
+	// other pods as well, so the service requires it with isolation on.
+	PodCIDR string
+	// ServiceNamespace and ServiceLabels select the service's own pods,
+	// which reach attempts through the SSH gateway and the web terminal.
+	// Without labels every pod of the namespace is admitted.
+	ServiceNamespace string
+	ServiceLabels    map[string]string
+	// SharedNamespace holds the shared image pull secret, which is copied
+	// into the isolated namespace.
+	SharedNamespace string
+}
+
+// ensureIsolation creates the spec's namespace with its default-deny
+// NetworkPolicy, ResourceQuota, LimitRange and a copy of the shared pull
+// secret. It does nothing without isolation.
+func ensureIsolation(ctx context.Context, kube kubernetes.Interface, spec *Spec) error {
+	iso := spec.Isolation
+	if iso == nil || iso.Mode == IsolationShared {
+		return nil
+	}
+
+	labels := map[string]string{
+		managedByLabel: managedBy,
+		isolationLabel: iso.Mode,
+	}
+	if iso.Mode == IsolationAttempt {
+		labels[ownerLabel] = spec.Name
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: spec.Namespace, Labels: labels}}
+	if _, err := kube.CoreV1().Namespaces().Create(ctx, ns, v1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %w", spec.Namespace, err)
+	}
+
+	if err := copySharedPullSecret(ctx, kube, iso.SharedNamespace, spec.Namespace); err != nil {
+		return err
+	}
+
+	// releases frozen before stay left out
+	frozen, err := frozenReleases(ctx, kube, spec.Namespace)
+	if err != nil {
+		return err
+	}
+	policies := kube.NetworkingV1().NetworkPolicies(spec.Namespace)
+	// namespaces set up before policies were per release admit every pod of
+	// the namespace, other attempts included
+	err = policies.Delete(ctx, legacySameNamespacePolicy, v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete network policy %s: %w", legacySameNamespacePolicy, err)
+	}
+	for _, policy := range append(isolationPolicies(spec.Namespace, iso, frozen), sameReleasePolicy(spec.Release)) {
+		if _, err := policies.Create(ctx, policy, v1.CreateOptions{}); err != nil {
+			if !apierrors.IsAlreadyExists(err) {
+				return fmt.Errorf("failed to create network policy %s: %w", policy.Name, err)
+			}
+			// shared namespaces outlive service upgrades
+			if _, err := policies.Update(ctx, policy, v1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("failed to update network policy %s: %w", policy.Name, err)
+			}
+		}
+	}
+
+	quota, limitRange, err := isolationLimits(spec.Namespace, iso)
+	if err != nil {
+		return err
+	}
+	// participants and resources change with the challenge, the limits follow
+	quotas := kube.CoreV1().ResourceQuotas(spec.Namespace)
+	if _, err := quotas.Create(ctx, quota, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create resource quota: %w", err)
+		}
+		if _, err := quotas.Update(ctx, quota, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update resource quota: %w", err)
+		}
+	}
+	limitRanges := kube.CoreV1().LimitRanges(spec.Namespace)
+	if _, err := limitRanges.Create(ctx, limitRange, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create limit range: %w", err)
+		}
+		if _, err := limitRanges.Update(ctx, limitRange, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update limit range: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// isolationPolicies denies all ingress except from the service's pods and
+// from outside the cluster's pod network, so participants still reach their
+// NodePort, gateway and terminal but other attempts cannot. Frozen pods are
+// left to their own policy, pods of a release reach each other through
+// sameReleasePolicy.
+func isolationPolicies(namespace string, iso *Isolation, frozen []string) []*networkingv1.NetworkPolicy {
+	meta := func(name string) v1.ObjectMeta {
+		return v1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedBy},
+		}
+	}
+
+	unfrozen := unfrozenSelector(frozen)
+
+	external := &networkingv1.IPBlock{CIDR: "0.0.0.0/0"}
+	if iso.PodCIDR != "" {
+		external.Except = []string{iso.PodCIDR}
+	}
+
+	policies := []*networkingv1.NetworkPolicy{
+		{
+			ObjectMeta: meta("default-deny"),
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: v1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+		},
+		{
+			ObjectMeta: meta("allow-external"),
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: unfrozen,
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{IPBlock: external}},
+				}},
+			},
+		},
+	}
+
+	if iso.ServiceNamespace != "" {
+		policies = append(policies, &networkingv1.NetworkPolicy{
+			ObjectMeta: meta("allow-service"),
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: unfrozen,
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &v1.LabelSelector{
+							MatchLabels: map[string]string{corev1.LabelMetadataName: iso.ServiceNamespace},
+						},
+						PodSelector: &v1.LabelSelector{MatchLabels: iso.ServiceLabels},
+					}},
+				}},
+			},
+		})
+	}
+	return policies
+}
+
+// the namespace-wide policy sameReleasePolicy replaces
+const legacySameNamespacePolicy = "allow-same-namespace"
+
+func sameReleasePolicyName(release Release) string {
+	return fmt.Sprintf("%s-same-release", release.Name)
+}
+
+// sameReleasePolicy lets the pods of a release, and the jobs grading it,
+// reach each other. Attempts sharing a challenge namespace stay apart.
+func sameReleasePolicy(release Release) *networkingv1.NetworkPolicy {
+	instance := map[string]string{instanceLabel: release.Name}
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      sameReleasePolicyName(release),
+			Namespace: release.Namespace,
+			Labels: map[string]string{
+				instanceLabel:  release.Name,
+				managedByLabel: managedBy,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: v1.LabelSelector{MatchLabels: instance},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{
+					{PodSelector: &v1.LabelSelector{MatchLabels: instance}},
+					{PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{graderLabel: release.Name}}},
+				},
+			}},
+		},
+	}
+}
+
+func deleteSameReleasePolicy(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	err := kube.NetworkingV1().NetworkPolicies(release.Namespace).Delete(ctx, sameReleasePolicyName(release), v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete network policy: %w", err)
+	}
+	return nil
+}
+
+func isolationLimits(namespace string, iso *Isolation) (*corev1.ResourceQuota, *corev1.LimitRange, error) {
+	defaults, err := iso.Defaults.Requirements()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pods := iso.Pods
+	if pods < 1 {
+		pods = 1
+	}
+
+	hard := corev1.ResourceList{
+		corev1.ResourcePods: *resource.NewQuantity(int64(pods), resource.DecimalSI),
+	}
+	for name, q := range defaults.Requests {
+		hard[corev1.ResourceName("requests."+string(name))] = scale(q, pods)
+	}
+	for name, q := range defaults.Limits {
+		hard[corev1.ResourceName("limits."+string(name))] = scale(q, pods)
+	}
+
+	meta := v1.ObjectMeta{
+		Name:      "challenge-limits",
+		Namespace: namespace,
+		Labels:    map[string]string{managedByLabel: managedBy},
+	}
+
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: meta,
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+	}
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: meta,
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{{
+				Type:           corev1.LimitTypeContainer,
+				Default:        defaults.Limits,
+				DefaultRequest: defaults.Requests,
+			}},
+		},
+	}
+	return quota, limitRange, nil
+}
+
+func scale(q resource.Quantity, n int) resource.Quantity {
+	scaled := resource.NewMilliQuantity(q.MilliValue()*int64(n), q.Format)
+	return *scaled
+}
+
+// deleteOwnedNamespace deletes the release's namespace if it was created for
+// that release alone.
+func deleteOwnedNamespace(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	ns, err := kube.CoreV1().Namespaces().Get(ctx, release.Namespace, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ns.Labels[ownerLabel] != release.Name {
+		return nil
+	}
+	return DeleteNamespace(ctx, kube, release.Namespace)
+}
+
+// DeleteNamespace removes an isolated namespace and everything in it. It
+// refuses namespaces the service did not create.
+func DeleteNamespace(ctx context.Context, kube kubernetes.Interface, namespace string) error {
+	ns, err := kube.CoreV1().Namespaces().Get(ctx, namespace, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ns.Labels[managedByLabel] != managedBy || ns.Labels[isolationLabel] == "" {
+		return fmt.Errorf("namespace %s is not an isolated challenge namespace", namespace)
+	}
+
+	err = kube.CoreV1().Namespaces().Delete(ctx, namespace, v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete namespace %s: %w", namespace, err)
+	}
+	return nil
+}
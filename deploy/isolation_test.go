@@ -0,0 +1,236 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sys.io/challenge-service/utils"
+)
+
+func TestIsolationPerAttempt(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: defaultPullSecret, Namespace: "challenge"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	spec := &Spec{
+		Release: Release{Name: "atoken", Namespace: "atoken"},
+		Image:   utils.ImageReference{Registry: "docker.io", Repository: "img", Tag: "v1"},
+		Isolation: &Isolation{
+			Mode:             IsolationAttempt,
+			Pods:             2,
+			Defaults:         Tiers["small"],
+			PodCIDR:          "10.244.0.0/16",
+			SharedNamespace:  "challenge",
+			ServiceNamespace: "platform",
+			ServiceLabels:    map[string]string{"app.kubernetes.io/name": "challenge-service"},
+		},
+	}
+
+	if err := m.Deploy(ctx, spec); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	secret, err := kube.CoreV1().Secrets("atoken").Get(ctx, defaultPullSecret, v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("shared pull secret not copied: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeDockerConfigJson || len(secret.Data[corev1.DockerConfigJsonKey]) == 0 {
+		t.Errorf("copied pull secret = %+v", secret)
+	}
+
+	ns, err := kube.CoreV1().Namespaces().Get(ctx, "atoken", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("namespace not created: %v", err)
+	}
+	if ns.Labels[ownerLabel] != "atoken" || ns.Labels[isolationLabel] != IsolationAttempt {
+		t.Errorf("namespace labels = %v", ns.Labels)
+	}
+
+	policies, _ := kube.NetworkingV1().NetworkPolicies("atoken").List(ctx, v1.ListOptions{})
+	if len(policies.Items) != 4 {
+		t.Fatalf("got %d network policies, want 4", len(policies.Items))
+	}
+	for _, p := range policies.Items {
+		switch p.Name {
+		case "allow-external":
+			if p.Spec.Ingress[0].From[0].IPBlock.Except[0] != "10.244.0.0/16" {
+				t.Errorf("allow-external should exclude the pod network, got %+v", p.Spec.Ingress[0].From[0].IPBlock)
+			}
+		case "default-deny":
+			if len(p.Spec.PodSelector.MatchExpressions) != 0 {
+				t.Errorf("default-deny should select frozen pods too, got %+v", p.Spec.PodSelector)
+			}
+			continue
+		case "atoken-same-release":
+			// frozen pods admit their own release as well
+			continue
+		case "allow-service":
+			peer := p.Spec.Ingress[0].From[0]
+			if peer.NamespaceSelector.MatchLabels[corev1.LabelMetadataName] != "platform" ||
+				peer.PodSelector.MatchLabels["app.kubernetes.io/name"] != "challenge-service" {
+				t.Errorf("allow-service should admit the service's pods, got %+v", peer)
+			}
+		}
+		if expr := p.Spec.PodSelector.MatchExpressions; len(expr) != 1 || expr[0].Key != frozenLabel {
+			t.Errorf("%s should leave frozen pods out, got %+v", p.Name, p.Spec.PodSelector)
+		}
+	}
+
+	quota, err := kube.CoreV1().ResourceQuotas("atoken").Get(ctx, "challenge-limits", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("resource quota not created: %v", err)
+	}
+	if cpu := quota.Spec.Hard["limits.cpu"]; cpu.String() != "1" {
+		t.Errorf("limits.cpu quota = %s, want 1", cpu.String())
+	}
+	if memory := quota.Spec.Hard["requests.memory"]; memory.String() != "256Mi" {
+		t.Errorf("requests.memory quota = %s, want 256Mi", memory.String())
+	}
+	if pods := quota.Spec.Hard[corev1.ResourcePods]; pods.String() != "2" {
+		t.Errorf("pods quota = %s, want 2", pods.String())
+	}
+
+	limitRange, err := kube.CoreV1().LimitRanges("atoken").Get(ctx, "challenge-limits", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("limit range not created: %v", err)
+	}
+	if cpu := limitRange.Spec.Limits[0].Default[corev1.ResourceCPU]; cpu.String() != "500m" {
+		t.Errorf("default cpu limit = %s, want 500m", cpu.String())
+	}
+
+	if err := m.Teardown(ctx, spec.Release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := kube.CoreV1().Namespaces().Get(ctx, "atoken", v1.GetOptions{}); err == nil {
+		t.Errorf("attempt namespace still exists after Teardown")
+	}
+}
+
+func TestIsolationPerChallenge(t *testing.T) {
+	ctx := context.Background()
+	// set up before policies were per release
+	kube := fake.NewSimpleClientset(&networkingv1.NetworkPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: legacySameNamespacePolicy, Namespace: "c-challenge"},
+		Spec: networkingv1.NetworkPolicySpec{
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{PodSelector: &v1.LabelSelector{}}},
+			}},
+		},
+	})
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	iso := &Isolation{Mode: IsolationChallenge, Pods: 4}
+	first := &Spec{Release: Release{Name: "afirst", Namespace: "c-challenge"}, Isolation: iso}
+	second := &Spec{Release: Release{Name: "asecond", Namespace: "c-challenge"}, Isolation: iso}
+
+	for _, spec := range []*Spec{first, second} {
+		if err := m.Deploy(ctx, spec); err != nil {
+			t.Fatalf("Deploy(%s) error = %v", spec.Name, err)
+		}
+	}
+
+	// no policy admits every pod of the namespace, a release's pods only
+	// admit their own
+	policies, _ := kube.NetworkingV1().NetworkPolicies("c-challenge").List(ctx, v1.ListOptions{})
+	for _, p := range policies.Items {
+		for _, rule := range p.Spec.Ingress {
+			for _, peer := range rule.From {
+				if peer.PodSelector != nil && peer.NamespaceSelector == nil && len(peer.PodSelector.MatchLabels) == 0 {
+					t.Errorf("%s admits every pod of the namespace", p.Name)
+				}
+			}
+		}
+	}
+	for _, spec := range []*Spec{first, second} {
+		policy, err := kube.NetworkingV1().NetworkPolicies("c-challenge").Get(ctx, spec.Name+"-same-release", v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("same release policy of %s not created: %v", spec.Name, err)
+		}
+		if got := policy.Spec.PodSelector.MatchLabels[instanceLabel]; got != spec.Name {
+			t.Errorf("%s selects instance %q, want %s", policy.Name, got, spec.Name)
+		}
+		if got := policy.Spec.Ingress[0].From[0].PodSelector.MatchLabels[instanceLabel]; got != spec.Name {
+			t.Errorf("%s admits instance %q, want %s", policy.Name, got, spec.Name)
+		}
+	}
+
+	// attempts share the namespace, tearing one down keeps it
+	if err := m.Teardown(ctx, first.Release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := kube.CoreV1().Namespaces().Get(ctx, "c-challenge", v1.GetOptions{}); err != nil {
+		t.Errorf("challenge namespace deleted with a single attempt: %v", err)
+	}
+	if _, err := kube.NetworkingV1().NetworkPolicies("c-challenge").Get(ctx, "afirst-same-release", v1.GetOptions{}); err == nil {
+		t.Errorf("same release policy of afirst still exists after Teardown")
+	}
+
+	if err := DeleteNamespace(ctx, kube, "c-challenge"); err != nil {
+		t.Fatalf("DeleteNamespace() error = %v", err)
+	}
+	if _, err := kube.CoreV1().Namespaces().Get(ctx, "c-challenge", v1.GetOptions{}); err == nil {
+		t.Errorf("challenge namespace still exists after DeleteNamespace")
+	}
+}
+
+func TestIsolationLimitsFollowChallenge(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	first := &Spec{
+		Release:   Release{Name: "afirst", Namespace: "c-challenge"},
+		Isolation: &Isolation{Mode: IsolationChallenge, Pods: 2, Defaults: Tiers["small"]},
+	}
+	if err := m.Deploy(ctx, first); err != nil {
+		t.Fatalf("Deploy(%s) error = %v", first.Name, err)
+	}
+
+	// participants were added and the profile grew since the namespace was set up
+	second := &Spec{
+		Release:   Release{Name: "asecond", Namespace: "c-challenge"},
+		Isolation: &Isolation{Mode: IsolationChallenge, Pods: 5, Defaults: Tiers["large"]},
+	}
+	if err := m.Deploy(ctx, second); err != nil {
+		t.Fatalf("Deploy(%s) error = %v", second.Name, err)
+	}
+
+	quota, err := kube.CoreV1().ResourceQuotas("c-challenge").Get(ctx, "challenge-limits", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("resource quota not created: %v", err)
+	}
+	if pods := quota.Spec.Hard[corev1.ResourcePods]; pods.Value() != 5 {
+		t.Errorf("quota pods = %s, want 5", pods.String())
+	}
+
+	large := Tiers["large"]
+	want, err := large.Requirements()
+	if err != nil {
+		t.Fatalf("Requirements() error = %v", err)
+	}
+	limitRange, err := kube.CoreV1().LimitRanges("c-challenge").Get(ctx, "challenge-limits", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("limit range not created: %v", err)
+	}
+	got := limitRange.Spec.Limits[0].Default[corev1.ResourceMemory]
+	if wantMemory := want.Limits[corev1.ResourceMemory]; got.Cmp(wantMemory) != 0 {
+		t.Errorf("default memory limit = %s, want %s", got.String(), wantMemory.String())
+	}
+}
+
+func TestDeleteNamespaceRefusesUnmanaged(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "kube-system"}})
+
+	if err := DeleteNamespace(ctx, kube, "kube-system"); err == nil {
+		t.Errorf("DeleteNamespace() should refuse namespaces it did not create")
+	}
+}
@@ -0,0 +1,321 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	defaultAuthorizedKeysPath = "/root/.ssh/authorized_keys"
+	sshPort                   = 22
+)
+
+type ManifestOptions struct {
+	Kube kubernetes.Interface
+	// RestConfig lets Inject exec into pods, injection fails without it.
+	RestConfig *rest.Config
+	// AuthorizedKeysPath is where the attempt's public key is mounted in the
+	// challenge container.
+	AuthorizedKeysPath string
+	// Endpoints resolves participant addresses, NodePortResolver if nil.
+	Endpoints EndpointResolver
+}
+
+// ManifestDeployer creates a Deployment, Service and Secret per attempt
+// directly through the Kubernetes API, without Helm. Chart value overrides
+// do not apply to it.
+type ManifestDeployer struct {
+	kube               kubernetes.Interface
+	restConfig         *rest.Config
+	authorizedKeysPath string
+	endpoints          EndpointResolver
+}
+
+func NewManifestDeployer(opts ManifestOptions) *ManifestDeployer {
+	if opts.AuthorizedKeysPath == "" {
+		opts.AuthorizedKeysPath = defaultAuthorizedKeysPath
+	}
+	if opts.Endpoints == nil {
+		opts.Endpoints = NodePortResolver{}
+	}
+	return &ManifestDeployer{
+		kube:               opts.Kube,
+		restConfig:         opts.RestConfig,
+		authorizedKeysPath: opts.AuthorizedKeysPath,
+		endpoints:          opts.Endpoints,
+	}
+}
+
+func (m *ManifestDeployer) Deploy(ctx context.Context, spec *Spec) error {
+	if err := ensureIsolation(ctx, m.kube, spec); err != nil {
+		return err
+	}
+	if err := ensureNamespace(ctx, m.kube, spec.Namespace); err != nil {
+		return err
+	}
+	if err := ensureEgressPolicy(ctx, m.kube, spec); err != nil {
+		return err
+	}
+	if err := ensurePullSecret(ctx, m.kube, spec); err != nil {
+		return err
+	}
+
+	if err := m.apply(ctx, spec); err != nil {
+		// don't leave credentials and policies behind for a release that never came up
+		if err := deleteReleaseObjects(ctx, m.kube, spec.Release); err != nil {
+			log.Printf("Failed to clean up release %s: %s", spec.Name, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// apply creates or updates the release's objects.
+func (m *ManifestDeployer) apply(ctx context.Context, spec *Spec) error {
+	secret, deployment, service, err := m.render(spec)
+	if err != nil {
+		return err
+	}
+
+	secrets := m.kube.CoreV1().Secrets(spec.Namespace)
+	if _, err := secrets.Create(ctx, secret, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create secret: %w", err)
+		}
+		if _, err := secrets.Update(ctx, secret, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update secret: %w", err)
+		}
+	}
+
+	deployments := m.kube.AppsV1().Deployments(spec.Namespace)
+	if _, err := deployments.Create(ctx, deployment, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create deployment: %w", err)
+		}
+		if _, err := deployments.Update(ctx, deployment, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update deployment: %w", err)
+		}
+	}
+
+	// the service is left alone if it exists so its NodePort stays stable
+	services := m.kube.CoreV1().Services(spec.Namespace)
+	if _, err := services.Create(ctx, service, v1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+
+	return nil
+}
+
+func (m *ManifestDeployer) Status(ctx context.Context, release Release) (Status, error) {
+	return podStatus(ctx, m.kube, release)
+}
+
+func (m *ManifestDeployer) Endpoint(ctx context.Context, release Release) (*Endpoint, error) {
+	return m.endpoints.Resolve(ctx, m.kube, release)
+}
+
+func (m *ManifestDeployer) Inject(ctx context.Context, release Release, injection *Injection) error {
+	return inject(ctx, m.restConfig, m.kube, release, m.authorizedKeysPath, injection)
+}
+
+// List finds releases by the deployments the service created.
+func (m *ManifestDeployer) List(ctx context.Context) ([]ListedRelease, error) {
+	deployments, err := m.kube.AppsV1().Deployments(v1.NamespaceAll).List(ctx, v1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", managedByLabel, managedBy),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	created := map[Release]time.Time{}
+	for _, deployment := range deployments.Items {
+		release := Release{Name: deployment.Labels["app.kubernetes.io/instance"], Namespace: deployment.Namespace}
+		created[release] = deployment.CreationTimestamp.Time
+	}
+	return listed(created), nil
+}
+
+func (m *ManifestDeployer) Teardown(ctx context.Context, release Release) error {
+	name := serviceName(release)
+
+	err := m.kube.CoreV1().Services(release.Namespace).Delete(ctx, name, v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+
+	propagation := v1.DeletePropagationForeground
+	err = m.kube.AppsV1().Deployments(release.Namespace).Delete(ctx, name, v1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete deployment: %w", err)
+	}
+
+	err = m.kube.CoreV1().Secrets(release.Namespace).Delete(ctx, name, v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+
+	if err := deleteReleaseObjects(ctx, m.kube, release); err != nil {
+		return err
+	}
+	return deleteOwnedNamespace(ctx, m.kube, release)
+}
+
+// render builds the objects for a release, mirroring what the challenge
+// chart installs.
+func (m *ManifestDeployer) render(spec *Spec) (*corev1.Secret, *appsv1.Deployment, *corev1.Service, error) {
+	name := serviceName(spec.Release)
+	labels := map[string]string{
+		"app.kubernetes.io/name":       "challenge",
+		"app.kubernetes.io/instance":   spec.Name,
+		"app.kubernetes.io/managed-by": "challenge-service",
+	}
+	meta := v1.ObjectMeta{
+		Name:      name,
+		Namespace: spec.Namespace,
+		Labels:    labels,
+	}
+
+	// env values and the public key are kept out of the pod spec
+	secretData := map[string]string{
+		"authorized_keys": spec.AuthorizedKeys,
+		"attempt.env":     envFile(spec.Env),
+	}
+	env := make([]corev1.EnvVar, 0, len(spec.Env))
+	for _, e := range spec.Env {
+		secretData[e.Name] = e.Value
+		env = append(env, corev1.EnvVar{
+			Name: e.Name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					Key:                  e.Name,
+				},
+			},
+		})
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: meta,
+		Type:       corev1.SecretTypeOpaque,
+		StringData: secretData,
+	}
+
+	var resources corev1.ResourceRequirements
+	if spec.Resources != nil {
+		var err error
+		if resources, err = spec.Resources.Requirements(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: meta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &v1.LabelSelector{
+				MatchLabels: map[string]string{"app.kubernetes.io/instance": spec.Name},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: pullSecretName(spec)}},
+					Containers: []corev1.Container{{
+						Name:            "challenge",
+						Image:           spec.Image.String(),
+						ImagePullPolicy: pullPolicy(spec),
+						Env:             env,
+						Resources:       resources,
+						Ports: []corev1.ContainerPort{{
+							Name:          "ssh",
+							ContainerPort: sshPort,
+							Protocol:      corev1.ProtocolTCP,
+						}},
+					}},
+				},
+			},
+		},
+	}
+
+	// releases without a key get one injected later and need the path
+	// writable, the attempt secret restores the injection after restarts
+	podSpec := &deployment.Spec.Template.Spec
+	if spec.AuthorizedKeys == "" {
+		optional := true
+		container := &podSpec.Containers[0]
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name: "ATTEMPT_TOKEN",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: attemptSecretName(spec.Release)},
+					Key:                  "ATTEMPT_TOKEN",
+					Optional:             &optional,
+				},
+			},
+		})
+		container.VolumeMounts = []corev1.VolumeMount{{Name: "attempt", MountPath: AttemptDir, ReadOnly: true}}
+		container.Lifecycle = &corev1.Lifecycle{
+			PostStart: &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: restoreCommand(m.authorizedKeysPath)}},
+		}
+		podSpec.Volumes = []corev1.Volume{{
+			Name: "attempt",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: attemptSecretName(spec.Release), Optional: &optional},
+			},
+		}}
+	} else {
+		// the env file is where pool-backed releases get theirs as well
+		podSpec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+			{
+				Name:      "authorized-keys",
+				MountPath: m.authorizedKeysPath,
+				SubPath:   "authorized_keys",
+				ReadOnly:  true,
+			},
+			{
+				Name:      "authorized-keys",
+				MountPath: AttemptEnvPath,
+				SubPath:   "attempt.env",
+				ReadOnly:  true,
+			},
+		}
+		podSpec.Volumes = []corev1.Volume{{
+			Name: "authorized-keys",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: name,
+					Items: []corev1.KeyToPath{
+						{Key: "authorized_keys", Path: "authorized_keys"},
+						{Key: "attempt.env", Path: "attempt.env"},
+					},
+				},
+			},
+		}}
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: meta,
+		Spec: corev1.ServiceSpec{
+			Type:     m.endpoints.ServiceType(),
+			Selector: map[string]string{"app.kubernetes.io/instance": spec.Name},
+			Ports: []corev1.ServicePort{{
+				Name:       "ssh",
+				Port:       sshPort,
+				TargetPort: intstr.FromString("ssh"),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+
+	return secret, deployment, service, nil
+}
@@ -0,0 +1,151 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sys.io/challenge-service/utils"
+)
+
+func TestManifestDeployer(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	spec := &Spec{
+		Release:        Release{Name: "atoken", Namespace: "challenge"},
+		Image:          utils.ImageReference{Registry: "registry.gitlab.com", Repository: "group/project/main", Tag: "latest"},
+		AuthorizedKeys: "ssh-rsa AAAA",
+		Env:            []EnvVar{{Name: "ATTEMPT_TOKEN", Value: "token"}},
+	}
+
+	if err := m.Deploy(ctx, spec); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	// deploying twice upgrades in place
+	if err := m.Deploy(ctx, spec); err != nil {
+		t.Fatalf("second Deploy() error = %v", err)
+	}
+
+	secret, err := kube.CoreV1().Secrets("challenge").Get(ctx, "atoken-challenge", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("secret not created: %v", err)
+	}
+	if secret.StringData["authorized_keys"] != "ssh-rsa AAAA" || secret.StringData["ATTEMPT_TOKEN"] != "token" {
+		t.Errorf("secret data = %v", secret.StringData)
+	}
+
+	deployment, err := kube.AppsV1().Deployments("challenge").Get(ctx, "atoken-challenge", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("deployment not created: %v", err)
+	}
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if want := "registry.gitlab.com/group/project/main:latest"; container.Image != want {
+		t.Errorf("image = %s, want %s", container.Image, want)
+	}
+	if container.Env[0].ValueFrom == nil || container.Env[0].ValueFrom.SecretKeyRef.Key != "ATTEMPT_TOKEN" {
+		t.Errorf("env should be read from the secret, got %+v", container.Env[0])
+	}
+	// the env file is there as on releases claimed from a warm pool
+	if secret.StringData["attempt.env"] != "export ATTEMPT_TOKEN='token'\n" {
+		t.Errorf("attempt.env = %q", secret.StringData["attempt.env"])
+	}
+	mounted := false
+	for _, mount := range container.VolumeMounts {
+		mounted = mounted || (mount.MountPath == AttemptEnvPath && mount.SubPath == "attempt.env")
+	}
+	if !mounted {
+		t.Errorf("attempt.env not mounted at %s, mounts %+v", AttemptEnvPath, container.VolumeMounts)
+	}
+
+	service, err := kube.CoreV1().Services("challenge").Get(ctx, "atoken-challenge", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("service not created: %v", err)
+	}
+	if service.Spec.Type != corev1.ServiceTypeNodePort {
+		t.Errorf("service type = %s, want NodePort", service.Spec.Type)
+	}
+
+	if err := m.Teardown(ctx, spec.Release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := kube.AppsV1().Deployments("challenge").Get(ctx, "atoken-challenge", v1.GetOptions{}); err == nil {
+		t.Errorf("deployment still exists after Teardown")
+	}
+	// tearing down a missing release is not an error
+	if err := m.Teardown(ctx, spec.Release); err != nil {
+		t.Errorf("second Teardown() error = %v", err)
+	}
+}
+
+func TestManifestDeployerStatus(t *testing.T) {
+	ctx := context.Background()
+	release := Release{Name: "atoken", Namespace: "challenge"}
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	status, err := m.Status(ctx, release)
+	if err != nil || status != StatusPending {
+		t.Fatalf("Status() without pods = %v, %v, want Pending", status, err)
+	}
+
+	_, _ = kube.CoreV1().Pods("challenge").Create(ctx, &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   "atoken-challenge-abc",
+			Labels: map[string]string{"app.kubernetes.io/instance": "atoken"},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}, v1.CreateOptions{})
+
+	status, err = m.Status(ctx, release)
+	if err != nil || status != StatusRunning {
+		t.Errorf("Status() = %v, %v, want Running", status, err)
+	}
+}
+
+func TestManifestDeployerPullSecret(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	spec := &Spec{
+		Release: Release{Name: "atoken", Namespace: "challenge"},
+		Image:   utils.ImageReference{Registry: "host:5000", Repository: "img", Tag: "v1"},
+		PullCredentials: &RegistryCredentials{
+			Server:   "host:5000",
+			Username: "user",
+			Password: "secret",
+		},
+	}
+
+	if err := m.Deploy(ctx, spec); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	secret, err := kube.CoreV1().Secrets("challenge").Get(ctx, "atoken-pull", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pull secret not created: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeDockerConfigJson {
+		t.Errorf("secret type = %s", secret.Type)
+	}
+	want := `{"auths":{"host:5000":{"username":"user","password":"secret","auth":"dXNlcjpzZWNyZXQ="}}}`
+	if got := string(secret.Data[corev1.DockerConfigJsonKey]); got != want {
+		t.Errorf("dockerconfigjson = %s, want %s", got, want)
+	}
+
+	deployment, _ := kube.AppsV1().Deployments("challenge").Get(ctx, "atoken-challenge", v1.GetOptions{})
+	if pullSecrets := deployment.Spec.Template.Spec.ImagePullSecrets; pullSecrets[0].Name != "atoken-pull" {
+		t.Errorf("imagePullSecrets = %v, want atoken-pull", pullSecrets)
+	}
+
+	if err := m.Teardown(ctx, spec.Release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := kube.CoreV1().Secrets("challenge").Get(ctx, "atoken-pull", v1.GetOptions{}); err == nil {
+		t.Errorf("pull secret still exists after Teardown")
+	}
+}
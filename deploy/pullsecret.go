@@ -0,0 +1,262 @@
+package deploy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pre-existing secret used when a challenge brings no credentials
+const defaultPullSecret = "docker-registry-credentials"
+
+// RegistryCredentials authenticate image pulls from a private registry.
+type RegistryCredentials struct {
+	Server   string
+	Username string
+	Password string
+}
+
+// pullSecretName returns the image pull secret the release's pod uses.
+func pullSecretName(spec *Spec) string {
+	if spec.PullCredentials == nil {
+		return defaultPullSecret
+	}
+	return fmt.Sprintf("%s-pull", spec.Name)
+}
+
+// dockerConfigJSON renders credentials in the .dockerconfigjson format.
+func dockerConfigJSON(creds *RegistryCredentials) ([]byte, error) {
+	server := creds.Server
+	if server == "docker.io" {
+		server = "https://index.docker.io/v1/"
+	}
+
+	type authEntry struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	return json.Marshal(map[string]map[string]authEntry{
+		"auths": {
+			server: {
+				Username: creds.Username,
+				Password: creds.Password,
+				Auth:     base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password)),
+			},
+		},
+	})
+}
+
+// SharedPullCredentials returns the credentials the shared pull secret in
+// namespace holds for server, nil if it has none.
+func SharedPullCredentials(ctx context.Context, kube kubernetes.Interface, namespace, server string) (*RegistryCredentials, error) {
+	secret, err := kube.CoreV1().Secrets(namespace).Get(ctx, defaultPullSecret, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image pull secret: %w", err)
+	}
+	return credentialsFor(secret.Data[corev1.DockerConfigJsonKey], server)
+}
+
+// credentialsFor finds the entry for server in a .dockerconfigjson, keyed
+// by host or by registry URL.
+func credentialsFor(config []byte, server string) (*RegistryCredentials, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+
+	var parsed struct {
+		Auths map[string]struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Auth     string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid image pull secret: %w", err)
+	}
+
+	for key, entry := range parsed.Auths {
+		host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		host, _, _ = strings.Cut(host, "/")
+		if host == "index.docker.io" {
+			host = "docker.io"
+		}
+		if host != server {
+			continue
+		}
+
+		creds := &RegistryCredentials{Server: server, Username: entry.Username, Password: entry.Password}
+		if creds.Username == "" && entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth for %s in image pull secret: %w", key, err)
+			}
+			creds.Username, creds.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return creds, nil
+	}
+	return nil, nil
+}
+
+// copySharedPullSecret copies the shared pull secret into namespace, pods
+// can only reference secrets of their own namespace. It is refreshed on
+// every call so a rotated secret reaches namespaces created before.
+func copySharedPullSecret(ctx context.Context, kube kubernetes.Interface, from, namespace string) error {
+	if from == "" || from == namespace {
+		return nil
+	}
+
+	shared, err := kube.CoreV1().Secrets(from).Get(ctx, defaultPullSecret, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read image pull secret: %w", err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      defaultPullSecret,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedBy},
+		},
+		Type: shared.Type,
+		Data: shared.Data,
+	}
+	secrets := kube.CoreV1().Secrets(namespace)
+	if _, err := secrets.Create(ctx, secret, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to copy image pull secret: %w", err)
+		}
+		if _, err := secrets.Update(ctx, secret, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update image pull secret: %w", err)
+		}
+	}
+	return nil
+}
+
+// ensurePullSecret creates or updates the release's image pull secret when
+// the spec carries credentials.
+func ensurePullSecret(ctx context.Context, kube kubernetes.Interface, spec *Spec) error {
+	if spec.PullCredentials == nil {
+		return nil
+	}
+
+	if err := ensureNamespace(ctx, kube, spec.Namespace); err != nil {
+		return err
+	}
+
+	config, err := dockerConfigJSON(spec.PullCredentials)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      pullSecretName(spec),
+			Namespace: spec.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/instance":   spec.Name,
+				"app.kubernetes.io/managed-by": "challenge-service",
+			},
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: config},
+	}
+
+	secrets := kube.CoreV1().Secrets(spec.Namespace)
+	if _, err := secrets.Create(ctx, secret, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create image pull secret: %w", err)
+		}
+		if _, err := secrets.Update(ctx, secret, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update image pull secret: %w", err)
+		}
+	}
+	return nil
+}
+
+// deletePullSecret removes the release's image pull secret if it has one.
+func deletePullSecret(ctx context.Context, kube kubernetes.Interface, release Release) error {
+	name := fmt.Sprintf("%s-pull", release.Name)
+	err := kube.CoreV1().Secrets(release.Namespace).Delete(ctx, name, v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete image pull secret: %w", err)
+	}
+	return nil
+}
+
+// ensureNamespace creates the namespace if it does not exist yet.
+func ensureNamespace(ctx context.Context, kube kubernetes.Interface, namespace string) error {
+	ns := &corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: namespace}}
+	_, err := kube.CoreV1().Namespaces().Create(ctx, ns, v1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// SaveRegistryCredentials keeps a challenge's own registry credentials in a
+// basic-auth secret, so they are not stored with the challenge.
+func SaveRegistryCredentials(ctx context.Context, kube kubernetes.Interface, namespace, name string, creds *RegistryCredentials) error {
+	if err := ensureNamespace(ctx, kube, namespace); err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedBy},
+		},
+		Type: corev1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			corev1.BasicAuthUsernameKey: []byte(creds.Username),
+			corev1.BasicAuthPasswordKey: []byte(creds.Password),
+		},
+	}
+	secrets := kube.CoreV1().Secrets(namespace)
+	if _, err := secrets.Create(ctx, secret, v1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create registry credentials: %w", err)
+		}
+		if _, err := secrets.Update(ctx, secret, v1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update registry credentials: %w", err)
+		}
+	}
+	return nil
+}
+
+// LoadRegistryCredentials reads the credentials SaveRegistryCredentials kept
+// for server.
+func LoadRegistryCredentials(ctx context.Context, kube kubernetes.Interface, namespace, name, server string) (*RegistryCredentials, error) {
+	secret, err := kube.CoreV1().Secrets(namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry credentials: %w", err)
+	}
+	return &RegistryCredentials{
+		Server:   server,
+		Username: string(secret.Data[corev1.BasicAuthUsernameKey]),
+		Password: string(secret.Data[corev1.BasicAuthPasswordKey]),
+	}, nil
+}
+
+// DeleteRegistryCredentials removes the credentials SaveRegistryCredentials
+// kept, if there are any.
+func DeleteRegistryCredentials(ctx context.Context, kube kubernetes.Interface, namespace, name string) error {
+	err := kube.CoreV1().Secrets(namespace).Delete(ctx, name, v1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete registry credentials: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSharedPullCredentials(t *testing.T) {
+	config := []byte(`{"auths": {
+		"registry.gitlab.com": {"username": "deploy", "password": "gitlab-token"},
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1Yi10b2tlbg=="}
+	}}`)
+	kube := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: defaultPullSecret, Namespace: "challenge"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: config},
+	})
+
+	tests := []struct {
+		name      string
+		namespace string
+		server    string
+		want      *RegistryCredentials
+	}{
+		{
+			name:      "host key",
+			namespace: "challenge",
+			server:    "registry.gitlab.com",
+			want:      &RegistryCredentials{Server: "registry.gitlab.com", Username: "deploy", Password: "gitlab-token"},
+		},
+		{
+			name:      "URL key with encoded auth",
+			namespace: "challenge",
+			server:    "docker.io",
+			want:      &RegistryCredentials{Server: "docker.io", Username: "hub", Password: "hub-token"},
+		},
+		{
+			name:      "other registry",
+			namespace: "challenge",
+			server:    "ghcr.io",
+		},
+		{
+			name:      "no shared secret",
+			namespace: "elsewhere",
+			server:    "registry.gitlab.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SharedPullCredentials(context.Background(), kube, tt.namespace, tt.server)
+			if err != nil {
+				t.Fatalf("SharedPullCredentials() error = %v", err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("SharedPullCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryCredentialsSecret(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	ctx := context.Background()
+
+	creds := &RegistryCredentials{Username: "creator", Password: "first"}
+	if err := SaveRegistryCredentials(ctx, kube, "challenge", "registry-ctf", creds); err != nil {
+		t.Fatalf("SaveRegistryCredentials() error = %v", err)
+	}
+	// saving again replaces them
+	creds.Password = "rotated"
+	if err := SaveRegistryCredentials(ctx, kube, "challenge", "registry-ctf", creds); err != nil {
+		t.Fatalf("SaveRegistryCredentials() error = %v", err)
+	}
+
+	got, err := LoadRegistryCredentials(ctx, kube, "challenge", "registry-ctf", "ghcr.io")
+	if err != nil {
+		t.Fatalf("LoadRegistryCredentials() error = %v", err)
+	}
+	want := RegistryCredentials{Server: "ghcr.io", Username: "creator", Password: "rotated"}
+	if *got != want {
+		t.Errorf("LoadRegistryCredentials() = %+v, want %+v", got, want)
+	}
+
+	if err := DeleteRegistryCredentials(ctx, kube, "challenge", "registry-ctf"); err != nil {
+		t.Fatalf("DeleteRegistryCredentials() error = %v", err)
+	}
+	if _, err := LoadRegistryCredentials(ctx, kube, "challenge", "registry-ctf", "ghcr.io"); err == nil {
+		t.Error("credentials still there after DeleteRegistryCredentials")
+	}
+	// deleting what is gone is not an error
+	if err := DeleteRegistryCredentials(ctx, kube, "challenge", "registry-ctf"); err != nil {
+		t.Errorf("DeleteRegistryCredentials() error = %v", err)
+	}
+}
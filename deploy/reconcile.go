@@ -0,0 +1,95 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RecordedRelease is a release the service's records expect to run.
+type RecordedRelease struct {
+	Release
+	// Since is when the record was made, vanished releases are only
+	// reported once it is older than the grace period.
+	Since time.Time
+	// Expired records belong to attempts that are over.
+	Expired bool
+}
+
+// ReconcilePlan is what it takes to bring the cluster and the records back
+// in line.
+type ReconcilePlan struct {
+	// Orphaned releases run without any record.
+	Orphaned []Release
+	// Expired releases belong to attempts that are over.
+	Expired []Release
+	// Vanished releases are recorded but no longer run.
+	Vanished []Release
+}
+
+// PlanReconcile compares running releases with recorded ones. Anything
+// younger than grace is left alone, it may still be starting.
+func PlanReconcile(running []ListedRelease, recorded []RecordedRelease, grace time.Duration, now time.Time) ReconcilePlan {
+	var plan ReconcilePlan
+
+	records := map[Release]RecordedRelease{}
+	for _, r := range recorded {
+		records[r.Release] = r
+	}
+	live := map[Release]bool{}
+	for _, r := range running {
+		live[r.Release] = true
+	}
+
+	for _, r := range running {
+		record, ok := records[r.Release]
+		switch {
+		case ok && record.Expired:
+			plan.Expired = append(plan.Expired, r.Release)
+		case !ok && now.Sub(r.Created) > grace:
+			plan.Orphaned = append(plan.Orphaned, r.Release)
+		}
+	}
+	for _, r := range recorded {
+		if !live[r.Release] && now.Sub(r.Since) > grace {
+			plan.Vanished = append(plan.Vanished, r.Release)
+		}
+	}
+	return plan
+}
+
+// listed turns release creation times into a list sorted by name.
+func listed(created map[Release]time.Time) []ListedRelease {
+	releases := make([]ListedRelease, 0, len(created))
+	for release, timestamp := range created {
+		releases = append(releases, ListedRelease{Release: release, Created: timestamp})
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Namespace != releases[j].Namespace {
+			return releases[i].Namespace < releases[j].Namespace
+		}
+		return releases[i].Name < releases[j].Name
+	})
+	return releases
+}
+
+// managedNamespaces returns the default namespace and the namespaces the
+// service created for isolation.
+func managedNamespaces(ctx context.Context, kube kubernetes.Interface, defaultNamespace string) (map[string]bool, error) {
+	namespaces, err := kube.CoreV1().Namespaces().List(ctx, v1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", managedByLabel, managedBy),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	managed := map[string]bool{defaultNamespace: true}
+	for _, ns := range namespaces.Items {
+		managed[ns.Name] = true
+	}
+	return managed, nil
+}
@@ -0,0 +1,97 @@
+package deploy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPlanReconcile(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	grace := 15 * time.Minute
+
+	release := func(name string) Release { return Release{Name: name, Namespace: "challenge"} }
+
+	running := []ListedRelease{
+		{release("aactive"), old},
+		{release("aexpired"), old},
+		{release("aorphan"), old},
+		{release("astarting"), now.Add(-time.Minute)},
+	}
+	recorded := []RecordedRelease{
+		{release("aactive"), old, false},
+		{release("aexpired"), old, true},
+		{release("agone"), old, false},
+		{release("ajustrecorded"), now, false},
+	}
+
+	got := PlanReconcile(running, recorded, grace, now)
+	want := ReconcilePlan{
+		Orphaned: []Release{release("aorphan")},
+		Expired:  []Release{release("aexpired")},
+		Vanished: []Release{release("agone")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanReconcile() = %+v, want %+v", got, want)
+	}
+}
+
+func TestHelmList(t *testing.T) {
+	created := v1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	helmSecret := func(name, release, namespace string, timestamp v1.Time) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: v1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			CreationTimestamp: timestamp,
+			Labels:            map[string]string{"owner": "helm", "name": release},
+		}}
+	}
+
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: v1.ObjectMeta{Name: "atoken", Labels: map[string]string{managedByLabel: managedBy}}},
+		helmSecret("sh.helm.release.v1.ashared.v1", "ashared", "challenge", created),
+		helmSecret("sh.helm.release.v1.ashared.v2", "ashared", "challenge", v1.Now()),
+		helmSecret("sh.helm.release.v1.atoken.v1", "atoken", "atoken", created),
+		// releases of other teams are none of our business
+		helmSecret("sh.helm.release.v1.ingress.v1", "ingress", "kube-system", created),
+	)
+	h := &HelmDeployer{opts: HelmOptions{Namespace: "challenge"}, kube: kube}
+
+	got, err := h.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []ListedRelease{
+		{Release{Name: "atoken", Namespace: "atoken"}, created.Time},
+		{Release{Name: "ashared", Namespace: "challenge"}, created.Time},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %+v, want %+v", got, want)
+	}
+}
+
+func TestManifestList(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset()
+	m := NewManifestDeployer(ManifestOptions{Kube: kube})
+
+	for _, release := range []Release{{"afirst", "challenge"}, {"asecond", "asecond"}} {
+		if err := m.Deploy(ctx, &Spec{Release: release}); err != nil {
+			t.Fatalf("Deploy() error = %v", err)
+		}
+	}
+
+	got, err := m.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Release != (Release{"asecond", "asecond"}) || got[1].Release != (Release{"afirst", "challenge"}) {
+		t.Errorf("List() = %+v", got)
+	}
+}
@@ -0,0 +1,141 @@
+package deploy
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+type ResourceList struct {
+	CPU              string
+	Memory           string
+	EphemeralStorage string
+}
+
+// Resources are the requests and limits of an attempt's container.
+type Resources struct {
+	Requests ResourceList
+	Limits   ResourceList
+}
+
+// Tiers are the named resource profiles a challenge can pick.
+var Tiers = map[string]Resources{
+	"small": {
+		Requests: ResourceList{CPU: "100m", Memory: "128Mi", EphemeralStorage: "256Mi"},
+		Limits:   ResourceList{CPU: "500m", Memory: "256Mi", EphemeralStorage: "512Mi"},
+	},
+	"medium": {
+		Requests: ResourceList{CPU: "250m", Memory: "256Mi", EphemeralStorage: "512Mi"},
+		Limits:   ResourceList{CPU: "1", Memory: "1Gi", EphemeralStorage: "2Gi"},
+	},
+	"large": {
+		Requests: ResourceList{CPU: "500m", Memory: "1Gi", EphemeralStorage: "1Gi"},
+		Limits:   ResourceList{CPU: "2", Memory: "4Gi", EphemeralStorage: "8Gi"},
+	},
+}
+
+// ResolveResources starts from the named tier, if any, applies the explicit
+// requests and limits on top and checks the result against max. Empty
+// fields in max are not limited.
+func ResolveResources(tier string, requests, limits, max ResourceList) (*Resources, error) {
+	var r Resources
+	if tier != "" {
+		t, ok := Tiers[tier]
+		if !ok {
+			return nil, fmt.Errorf("unknown resource tier %q", tier)
+		}
+		r = t
+	}
+	r.Requests = r.Requests.with(requests)
+	r.Limits = r.Limits.with(limits)
+
+	req, err := r.Requirements()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage} {
+		request, hasRequest := req.Requests[name]
+		limit, hasLimit := req.Limits[name]
+
+		if hasRequest && hasLimit && request.Cmp(limit) > 0 {
+			return nil, fmt.Errorf("%s request %s exceeds limit %s", name, request.String(), limit.String())
+		}
+
+		ceiling := max.get(name)
+		if ceiling == "" {
+			continue
+		}
+		maxQuantity, err := resource.ParseQuantity(ceiling)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s maximum %q: %w", name, ceiling, err)
+		}
+		if hasRequest && request.Cmp(maxQuantity) > 0 {
+			return nil, fmt.Errorf("%s request %s exceeds the maximum of %s", name, request.String(), ceiling)
+		}
+		if hasLimit && limit.Cmp(maxQuantity) > 0 {
+			return nil, fmt.Errorf("%s limit %s exceeds the maximum of %s", name, limit.String(), ceiling)
+		}
+	}
+
+	return &r, nil
+}
+
+// Requirements converts r into a container's resource requirements.
+func (r *Resources) Requirements() (corev1.ResourceRequirements, error) {
+	var req corev1.ResourceRequirements
+	var err error
+	if req.Requests, err = r.Requests.toList(); err != nil {
+		return req, err
+	}
+	if req.Limits, err = r.Limits.toList(); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// with returns l with the non-empty fields of o applied.
+func (l ResourceList) with(o ResourceList) ResourceList {
+	if o.CPU != "" {
+		l.CPU = o.CPU
+	}
+	if o.Memory != "" {
+		l.Memory = o.Memory
+	}
+	if o.EphemeralStorage != "" {
+		l.EphemeralStorage = o.EphemeralStorage
+	}
+	return l
+}
+
+func (l ResourceList) get(name corev1.ResourceName) string {
+	switch name {
+	case corev1.ResourceCPU:
+		return l.CPU
+	case corev1.ResourceMemory:
+		return l.Memory
+	case corev1.ResourceEphemeralStorage:
+		return l.EphemeralStorage
+	}
+	return ""
+}
+
+func (l ResourceList) toList() (corev1.ResourceList, error) {
+	list := corev1.ResourceList{}
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory, corev1.ResourceEphemeralStorage} {
+		v := l.get(name)
+		if v == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s quantity %q: %w", name, v, err)
+		}
+		list[name] = q
+	}
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return list, nil
+}
@@ -0,0 +1,62 @@
+package deploy
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestResolveResources(t *testing.T) {
+	max := ResourceList{CPU: "2", Memory: "2Gi"}
+
+	tests := []struct {
+		name     string
+		tier     string
+		requests ResourceList
+		limits   ResourceList
+		want     *Resources
+		wantErr  bool
+	}{
+		{"Nothing set", "", ResourceList{}, ResourceList{}, &Resources{}, false},
+		{"Tier", "small", ResourceList{}, ResourceList{}, ptr(Tiers["small"]), false},
+		{"Tier with override", "small", ResourceList{}, ResourceList{Memory: "512Mi"},
+			&Resources{Requests: Tiers["small"].Requests, Limits: ResourceList{CPU: "500m", Memory: "512Mi", EphemeralStorage: "512Mi"}}, false},
+		{"Explicit", "", ResourceList{CPU: "250m"}, ResourceList{CPU: "1"},
+			&Resources{Requests: ResourceList{CPU: "250m"}, Limits: ResourceList{CPU: "1"}}, false},
+		{"Unknown tier", "huge", ResourceList{}, ResourceList{}, nil, true},
+		{"Tier above max", "large", ResourceList{}, ResourceList{}, nil, true},
+		{"Limit above max", "", ResourceList{}, ResourceList{CPU: "4"}, nil, true},
+		{"Request above limit", "", ResourceList{Memory: "1Gi"}, ResourceList{Memory: "512Mi"}, nil, true},
+		{"Invalid quantity", "", ResourceList{CPU: "lots"}, ResourceList{}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveResources(tt.tier, tt.requests, tt.limits, max)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *got != *tt.want {
+				t.Errorf("ResolveResources() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourcesRequirements(t *testing.T) {
+	r := Tiers["medium"]
+	req, err := r.Requirements()
+	if err != nil {
+		t.Fatalf("Requirements() error = %v", err)
+	}
+	if cpu := req.Limits[corev1.ResourceCPU]; cpu.String() != "1" {
+		t.Errorf("cpu limit = %s, want 1", cpu.String())
+	}
+	if storage := req.Requests[corev1.ResourceEphemeralStorage]; storage.String() != "512Mi" {
+		t.Errorf("ephemeral-storage request = %s, want 512Mi", storage.String())
+	}
+}
+
+func ptr(r Resources) *Resources {
+	return &r
+}
@@ -0,0 +1,185 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ChartValues are the values the service sets on the challenge chart.
+type ChartValues struct {
+	Image            ImageValues                  `json:"image"`
+	ImagePullSecrets []LocalObjectRef             `json:"imagePullSecrets,omitempty"`
+	AuthorizedKeys   string                       `json:"authorized_keys"`
+	Env              []EnvVar                     `json:"env,omitempty"`
+	Resources        *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// AttemptSecret is set on releases deployed without a key. Charts
+	// mount it at AttemptDir and restore the injection from it on start,
+	// and take ATTEMPT_TOKEN from it.
+	AttemptSecret string `json:"attemptSecret,omitempty"`
+	// AttemptEnv is set on releases deployed with a key, charts write it
+	// to AttemptEnvPath where keyless releases have it restored from their
+	// AttemptSecret.
+	AttemptEnv string `json:"attemptEnv,omitempty"`
+}
+
+type ImageValues struct {
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	PullPolicy string `json:"pullPolicy"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+}
+
+type LocalObjectRef struct {
+	Name string `json:"name"`
+}
+
+// protectedValues are owned by the attempt, challenge overrides cannot set
+// them even where the attempt leaves them unset.
+var protectedValues = [][]string{
+	{"image", "registry"},
+	{"image", "repository"},
+	{"image", "tag"},
+	{"image", "digest"},
+	{"imagePullSecrets"},
+	{"authorized_keys"},
+	{"env"},
+	{"resources"},
+	{"attemptSecret"},
+	{"attemptEnv"},
+}
+
+// pullPolicy only re-pulls images referenced by a mutable tag.
+func pullPolicy(spec *Spec) corev1.PullPolicy {
+	if spec.Image.Digest != "" {
+		return corev1.PullIfNotPresent
+	}
+	return corev1.PullAlways
+}
+
+func chartValues(spec *Spec) (ChartValues, error) {
+	values := ChartValues{
+		Image: ImageValues{
+			Registry:   spec.Image.Registry,
+			Repository: spec.Image.Repository,
+			PullPolicy: string(pullPolicy(spec)),
+			Tag:        spec.Image.Tag,
+			Digest:     spec.Image.Digest,
+		},
+		ImagePullSecrets: []LocalObjectRef{{Name: pullSecretName(spec)}},
+		AuthorizedKeys:   spec.AuthorizedKeys,
+		Env:              spec.Env,
+	}
+	if spec.AuthorizedKeys == "" {
+		values.AttemptSecret = attemptSecretName(spec.Release)
+	} else {
+		values.AttemptEnv = envFile(spec.Env)
+	}
+
+	if spec.Resources != nil {
+		resources, err := spec.Resources.Requirements()
+		if err != nil {
+			return values, err
+		}
+		values.Resources = &resources
+	}
+	return values, nil
+}
+
+// Values returns the chart values for spec with the challenge's overrides
+// merged on top. Overrides cannot replace the attempt's image, key, env or
+// resources.
+func Values(spec *Spec) (map[string]interface{}, error) {
+	chart, err := chartValues(spec)
+	if err != nil {
+		return nil, err
+	}
+	base, err := toMap(chart)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := toMap(spec.Values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value overrides: %w", err)
+	}
+
+	// protected values the attempt leaves unset, like resources without a
+	// profile, must not be filled in by the challenge either
+	for _, path := range protectedValues {
+		deleteValue(overrides, path)
+	}
+	return mergeValues(copyValues(base), overrides), nil
+}
+
+// toMap converts v into plain JSON types through a JSON round trip.
+func toMap(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+	return out, nil
+}
+
+// mergeValues deep merges src into dst, src winning on conflicts. Nested
+// maps are merged, everything else including lists is replaced.
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+func copyValues(src map[string]interface{}) map[string]interface{} {
+	dst := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		if m, ok := v.(map[string]interface{}); ok {
+			v = copyValues(m)
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// deleteValue removes path from values, along with any key on the way that
+// is not a map and would replace the maps below it.
+func deleteValue(values map[string]interface{}, path []string) {
+	cur := values
+	for _, key := range path[:len(path)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			delete(cur, key)
+			return
+		}
+		cur = next
+	}
+	delete(cur, path[len(path)-1])
+}
+
+func setValue(values map[string]interface{}, path []string, v interface{}) {
+	cur := values
+	for _, key := range path[:len(path)-1] {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			cur[key] = next
+		}
+		cur = next
+	}
+	cur[path[len(path)-1]] = v
+}
@@ -0,0 +1,219 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+	"sys.io/challenge-service/utils"
+)
+
+func TestValues(t *testing.T) {
+	spec := &Spec{
+		Release:        Release{Name: "atoken", Namespace: "challenge"},
+		Image:          utils.ImageReference{Registry: "registry.gitlab.com", Repository: "group/project/main", Tag: "latest"},
+		AuthorizedKeys: "ssh-rsa AAAA: #not a comment\n",
+		Env:            []EnvVar{{Name: "PLATFORM_PASSWORD", Value: "p@ss: {word}"}},
+		Values: map[string]interface{}{
+			"image":           map[string]interface{}{"pullPolicy": "IfNotPresent", "tag": "hijacked"},
+			"authorized_keys": "ssh-rsa attacker",
+			"service":         map[string]interface{}{"type": "ClusterIP"},
+		},
+	}
+
+	values, err := Values(spec)
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+
+	image := values["image"].(map[string]interface{})
+	if image["pullPolicy"] != "IfNotPresent" {
+		t.Errorf("override not applied, pullPolicy = %v", image["pullPolicy"])
+	}
+	if image["tag"] != "latest" || image["registry"] != "registry.gitlab.com" {
+		t.Errorf("image = %v, want the attempt's registry and tag", image)
+	}
+	if values["authorized_keys"] != spec.AuthorizedKeys {
+		t.Errorf("authorized_keys = %q, overrides must not replace it", values["authorized_keys"])
+	}
+	if values["service"].(map[string]interface{})["type"] != "ClusterIP" {
+		t.Errorf("service override missing: %v", values["service"])
+	}
+
+	// special characters survive the YAML encoding
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() error = %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := yaml.Unmarshal(out, &decoded); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded["authorized_keys"], spec.AuthorizedKeys) {
+		t.Errorf("authorized_keys round trip = %q", decoded["authorized_keys"])
+	}
+	env := decoded["env"].([]interface{})[0].(map[string]interface{})
+	if env["value"] != "p@ss: {word}" {
+		t.Errorf("env round trip = %v", env)
+	}
+}
+
+func TestValuesDoesNotMutateOverrides(t *testing.T) {
+	overrides := map[string]interface{}{"image": map[string]interface{}{"pullPolicy": "Never"}}
+	spec := &Spec{Image: utils.ImageReference{Registry: "docker.io", Repository: "repo", Tag: "v1"}, Values: overrides}
+
+	if _, err := Values(spec); err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	if len(overrides["image"].(map[string]interface{})) != 1 {
+		t.Errorf("overrides were modified: %v", overrides)
+	}
+}
+
+func TestValuesSchema(t *testing.T) {
+	chrt := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "challenge"},
+		Schema: []byte(`{
+			"type": "object",
+			"properties": {
+				"image": {
+					"type": "object",
+					"properties": {"pullPolicy": {"enum": ["Always", "IfNotPresent", "Never"]}}
+				}
+			}
+		}`),
+	}
+
+	tests := []struct {
+		name      string
+		overrides map[string]interface{}
+		wantErr   bool
+	}{
+		{"Defaults", nil, false},
+		{"Valid override", map[string]interface{}{"image": map[string]interface{}{"pullPolicy": "Never"}}, false},
+		{"Invalid override", map[string]interface{}{"image": map[string]interface{}{"pullPolicy": "Sometimes"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := Values(&Spec{Image: utils.ImageReference{Registry: "docker.io", Repository: "repo", Tag: "v1"}, Values: tt.overrides})
+			if err != nil {
+				t.Fatalf("Values() error = %v", err)
+			}
+			err = chartutil.ValidateAgainstSchema(chrt, values)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAgainstSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValuesPinnedDigest(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	spec := &Spec{Image: utils.ImageReference{Registry: "docker.io", Repository: "repo", Tag: "latest", Digest: digest}}
+
+	values, err := Values(spec)
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	image := values["image"].(map[string]interface{})
+	if image["digest"] != digest || image["pullPolicy"] != "IfNotPresent" {
+		t.Errorf("image = %v, want digest with IfNotPresent", image)
+	}
+}
+
+func TestValuesResources(t *testing.T) {
+	resources := Tiers["small"]
+	spec := &Spec{
+		Image:     utils.ImageReference{Registry: "docker.io", Repository: "repo", Tag: "v1"},
+		Resources: &resources,
+		Values: map[string]interface{}{
+			"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "64"}},
+		},
+	}
+
+	values, err := Values(spec)
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	limits := values["resources"].(map[string]interface{})["limits"].(map[string]interface{})
+	if limits["cpu"] != "500m" || limits["ephemeral-storage"] != "512Mi" {
+		t.Errorf("resources.limits = %v, want the small tier", limits)
+	}
+}
+
+func TestValuesProtectedUnset(t *testing.T) {
+	// no profile and no digest, the attempt leaves both unset
+	spec := &Spec{
+		Image: utils.ImageReference{Registry: "docker.io", Repository: "repo", Tag: "v1"},
+		Values: map[string]interface{}{
+			"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "64"}},
+			"image":     map[string]interface{}{"digest": "sha256:other", "pullPolicy": "Never"},
+		},
+	}
+
+	values, err := Values(spec)
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	if _, ok := values["resources"]; ok {
+		t.Errorf("resources = %v, overrides must not set them", values["resources"])
+	}
+	image := values["image"].(map[string]interface{})
+	if _, ok := image["digest"]; ok || image["pullPolicy"] != "Never" {
+		t.Errorf("image = %v, want the override without the digest", image)
+	}
+
+	// an override replacing the whole map is dropped with it
+	spec.Values = map[string]interface{}{"image": "attacker/image"}
+	values, err = Values(spec)
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	if image, ok := values["image"].(map[string]interface{}); !ok || image["repository"] != "repo" {
+		t.Errorf("image = %v, want the attempt's", values["image"])
+	}
+}
+
+func TestValuesAttemptSecret(t *testing.T) {
+	image := utils.ImageReference{Registry: "docker.io", Repository: "repo", Tag: "v1"}
+
+	keyless, err := Values(&Spec{Release: Release{Name: "pwarm"}, Image: image})
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	if keyless["attemptSecret"] != "pwarm-attempt" {
+		t.Errorf("attemptSecret = %v, want pwarm-attempt", keyless["attemptSecret"])
+	}
+
+	keyed, err := Values(&Spec{Release: Release{Name: "atoken"}, Image: image, AuthorizedKeys: "ssh-rsa AAAA"})
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	if _, ok := keyed["attemptSecret"]; ok {
+		t.Errorf("attemptSecret = %v, releases deployed with a key have none", keyed["attemptSecret"])
+	}
+	if _, ok := keyless["attemptEnv"]; ok {
+		t.Errorf("attemptEnv = %v, keyless releases restore it from their secret", keyless["attemptEnv"])
+	}
+}
+
+func TestValuesAttemptEnv(t *testing.T) {
+	spec := &Spec{
+		Release:        Release{Name: "atoken"},
+		Image:          utils.ImageReference{Registry: "docker.io", Repository: "repo", Tag: "v1"},
+		AuthorizedKeys: "ssh-rsa AAAA",
+		Env:            []EnvVar{{Name: "ATTEMPT_TOKEN", Value: "token"}},
+		Values:         map[string]interface{}{"attemptEnv": "export ATTEMPT_TOKEN='other'\n"},
+	}
+
+	values, err := Values(spec)
+	if err != nil {
+		t.Fatalf("Values() error = %v", err)
+	}
+	if want := "export ATTEMPT_TOKEN='token'\n"; values["attemptEnv"] != want {
+		t.Errorf("attemptEnv = %q, want %q", values["attemptEnv"], want)
+	}
+}
@@ -0,0 +1,32 @@
+package deploy
+
+import (
+	"context"
+	"time"
+)
+
+// WaitReady polls the release until it leaves StatusPending, calling
+// onPending every time it is found still starting.
+func WaitReady(ctx context.Context, d Deployer, release Release, interval time.Duration, onPending func()) (Status, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		status, err := d.Status(ctx, release)
+		if err != nil {
+			return "", err
+		}
+		if status != StatusPending {
+			return status, nil
+		}
+		if onPending != nil {
+			onPending()
+		}
+
+		select {
+		case <-ctx.Done():
+			return StatusPending, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
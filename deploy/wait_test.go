@@ -0,0 +1,89 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitReady(t *testing.T) {
+	release := Release{Name: "atoken", Namespace: "challenge"}
+
+	tests := []struct {
+		name         string
+		pendingPolls int
+		forced       Status
+		want         Status
+		wantPending  int
+	}{
+		{"Running immediately", 0, "", StatusRunning, 0},
+		{"Pending then running", 3, "", StatusRunning, 3},
+		{"Failed", 0, StatusFailed, StatusFailed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFake()
+			fake.PendingPolls = tt.pendingPolls
+			if err := fake.Deploy(context.Background(), &Spec{Release: release}); err != nil {
+				t.Fatalf("Deploy() error = %v", err)
+			}
+			if tt.forced != "" {
+				fake.SetStatus(release, tt.forced)
+			}
+
+			pending := 0
+			status, err := WaitReady(context.Background(), fake, release, time.Millisecond, func() { pending++ })
+			if err != nil {
+				t.Fatalf("WaitReady() error = %v", err)
+			}
+			if status != tt.want {
+				t.Errorf("WaitReady() = %v, want %v", status, tt.want)
+			}
+			if pending != tt.wantPending {
+				t.Errorf("onPending called %d times, want %d", pending, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	release := Release{Name: "atoken", Namespace: "challenge"}
+	fake := NewFake()
+	fake.PendingPolls = 1 << 30
+	_ = fake.Deploy(context.Background(), &Spec{Release: release})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	status, err := WaitReady(ctx, fake, release, time.Millisecond, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitReady() error = %v, want deadline exceeded", err)
+	}
+	if status != StatusPending {
+		t.Errorf("WaitReady() = %v, want %v", status, StatusPending)
+	}
+}
+
+func TestFakeTeardown(t *testing.T) {
+	release := Release{Name: "atoken", Namespace: "challenge"}
+	fake := NewFake()
+
+	if err := fake.Deploy(context.Background(), &Spec{Release: release, AuthorizedKeys: "v1"}); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	if spec, ok := fake.Spec(release); !ok || spec.AuthorizedKeys != "v1" {
+		t.Errorf("Spec() = %+v, %v", spec, ok)
+	}
+	if err := fake.Teardown(context.Background(), release); err != nil {
+		t.Fatalf("Teardown() error = %v", err)
+	}
+	if _, err := fake.Status(context.Background(), release); err == nil {
+		t.Errorf("Status() after Teardown should fail")
+	}
+	// a release that is gone already is no error
+	if err := fake.Teardown(context.Background(), release); err != nil {
+		t.Errorf("second Teardown() error = %v", err)
+	}
+}
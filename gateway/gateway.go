@@ -0,0 +1,229 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnknownAttempt is returned by a LookupFunc for tokens with no running
+// attempt.
+var ErrUnknownAttempt = errors.New("unknown attempt")
+
+// Target is where a participant's session is proxied to.
+type Target struct {
+	// Addr is the host:port of the attempt's in-cluster SSH service.
+	Addr string
+	// User is the account logged into inside the challenge container.
+	User string
+	// Signer is the attempt's key. Participants must present its public
+	// half and the gateway uses it to log in upstream.
+	Signer ssh.Signer
+}
+
+// LookupFunc finds the target of an attempt token.
+type LookupFunc func(ctx context.Context, token string) (*Target, error)
+
+// Server is an SSH jump gateway. Participants log in with their attempt
+// token as user name and are proxied into their challenge pod.
+type Server struct {
+	config *ssh.ServerConfig
+	lookup LookupFunc
+	// DialTimeout bounds the upstream connection.
+	DialTimeout time.Duration
+	// Record, if set, stores every session as an asciicast recording.
+	Record SaveFunc
+	// Sessions, if set, tracks live sessions so they can be closed.
+	Sessions *Sessions
+}
+
+func NewServer(hostKey ssh.Signer, lookup LookupFunc) *Server {
+	s := &Server{lookup: lookup, DialTimeout: 10 * time.Second}
+
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), s.DialTimeout)
+			defer cancel()
+
+			target, err := s.lookup(ctx, conn.User())
+			if err != nil {
+				return nil, fmt.Errorf("attempt %s: %w", conn.User(), err)
+			}
+			if !bytes.Equal(key.Marshal(), target.Signer.PublicKey().Marshal()) {
+				return nil, fmt.Errorf("attempt %s: key mismatch", conn.User())
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"token": conn.User()}}, nil
+		},
+	}
+	s.config.AddHostKey(hostKey)
+
+	return s
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("SSH gateway listening on %s", listener.Addr())
+	return s.Serve(listener)
+}
+
+// Serve accepts connections until the listener is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(netConn net.Conn) {
+	defer netConn.Close()
+
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, s.config)
+	if err != nil {
+		log.Printf("SSH gateway handshake from %s failed: %s", netConn.RemoteAddr(), err)
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	token := conn.Permissions.Extensions["token"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.DialTimeout)
+	target, err := s.lookup(ctx, token)
+	cancel()
+	if err != nil {
+		log.Printf("SSH gateway lookup of attempt %s failed: %s", token, err)
+		return
+	}
+
+	upstream, err := dial(target, s.DialTimeout)
+	if err != nil {
+		log.Printf("SSH gateway could not reach attempt %s at %s: %s", token, target.Addr, err)
+		return
+	}
+	defer upstream.Close()
+	defer s.Sessions.add(token, upstream)()
+
+	log.Printf("SSH gateway proxying attempt %s to %s", token, target.Addr)
+
+	// tear the participant connection down when the pod goes away
+	go func() {
+		upstream.Wait()
+		conn.Close()
+	}()
+
+	for newChannel := range chans {
+		go s.proxyChannel(token, upstream, newChannel)
+	}
+}
+
+// dial logs into the target's challenge pod with the attempt key.
+func dial(target *Target, timeout time.Duration) (*ssh.Client, error) {
+	return ssh.Dial("tcp", target.Addr, &ssh.ClientConfig{
+		User: target.User,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(target.Signer)},
+		// challenge pods generate their host keys at boot, there is nothing
+		// to pin; the connection never leaves the cluster network
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         timeout,
+	})
+}
+
+// proxyChannel opens the same channel upstream and pipes data and requests
+// between the two until either side closes.
+func (s *Server) proxyChannel(token string, upstream *ssh.Client, newChannel ssh.NewChannel) {
+	upChannel, upReqs, err := upstream.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	defer upChannel.Close()
+
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	// only sessions are recorded, forwarded ports carry no terminal
+	var input, output, stderr io.Writer = io.Discard, io.Discard, io.Discard
+	observe := func(*ssh.Request) {}
+	if s.Record != nil && newChannel.ChannelType() == "session" {
+		rec := newRecorder(80, 24, "")
+		input, output, stderr = rec.writer("i"), rec.writer("o"), rec.writer("o")
+		observe = rec.observe
+		defer rec.save(s.Record, token, "ssh")
+	}
+
+	// participant to pod, a participant hanging up closes the pod side
+	go func() {
+		forwardRequests(upChannel, reqs, observe)
+		upChannel.Close()
+	}()
+	go func() {
+		io.Copy(upChannel, io.TeeReader(channel, input))
+		upChannel.CloseWrite()
+	}()
+	go io.Copy(upChannel.Stderr(), channel.Stderr())
+
+	// pod to participant, done once the pod closes the channel
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go func() {
+		defer copies.Done()
+		io.Copy(io.MultiWriter(channel, output), upChannel)
+	}()
+	go func() {
+		defer copies.Done()
+		io.Copy(io.MultiWriter(channel.Stderr(), stderr), upChannel.Stderr())
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copies.Wait()
+		channel.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		// exit-status and friends arrive before the close
+		forwardRequests(channel, upReqs, func(*ssh.Request) {})
+	}()
+	wg.Wait()
+}
+
+// forwardRequests relays channel requests to dst, passing replies back.
+func forwardRequests(dst ssh.Channel, reqs <-chan *ssh.Request, observe func(*ssh.Request)) {
+	for req := range reqs {
+		observe(req)
+		ok, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+}
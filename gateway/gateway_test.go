@@ -0,0 +1,235 @@
+package gateway
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// newChallengePod starts an SSH server standing in for a challenge pod. It
+// accepts the attempt key, answers exec requests with the user and command
+// and runs an echoing shell.
+func newChallengePod(t *testing.T, attemptKey ssh.PublicKey) string {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(attemptKey.Marshal()) {
+				return nil, fmt.Errorf("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(newSigner(t))
+
+	listener := listen(t)
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, chans, reqs, err := ssh.NewServerConn(netConn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					channel, reqs, _ := newChannel.Accept()
+					go serveChannel(conn.User(), channel, reqs)
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func serveChannel(user string, channel ssh.Channel, reqs <-chan *ssh.Request) {
+	defer channel.Close()
+	exit := func(status uint32) {
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	}
+
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			req.Reply(true, nil)
+			fmt.Fprintf(channel, "%s ran %s", user, req.Payload[4:])
+			exit(3)
+			return
+		case "pty-req":
+			req.Reply(true, nil)
+		case "window-change":
+			var size struct{ Cols, Rows, Width, Height uint32 }
+			ssh.Unmarshal(req.Payload, &size)
+			fmt.Fprintf(channel, "resized %dx%d", size.Cols, size.Rows)
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				buf := make([]byte, 256)
+				for {
+					n, err := channel.Read(buf)
+					if err != nil {
+						return
+					}
+					if string(buf[:n]) == "exit" {
+						exit(0)
+						channel.Close()
+						return
+					}
+					channel.Write(buf[:n])
+				}
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func TestGatewayProxiesSession(t *testing.T) {
+	attemptKey := newSigner(t)
+	pod := newChallengePod(t, attemptKey.PublicKey())
+
+	server := NewServer(newSigner(t), func(ctx context.Context, token string) (*Target, error) {
+		if token != "token" {
+			return nil, ErrUnknownAttempt
+		}
+		return &Target{Addr: pod, User: "root", Signer: attemptKey}, nil
+	})
+	listener := listen(t)
+	go server.Serve(listener)
+
+	tests := []struct {
+		name    string
+		user    string
+		key     ssh.Signer
+		wantErr bool
+	}{
+		{"Attempt key", "token", attemptKey, false},
+		{"Wrong key", "token", newSigner(t), true},
+		{"Unknown token", "other", attemptKey, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+				User:            tt.user,
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(tt.key)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatalf("NewSession() error = %v", err)
+			}
+			defer session.Close()
+
+			out, err := session.Output("whoami")
+			if string(out) != "root ran whoami" {
+				t.Errorf("Output() = %q, want %q", out, "root ran whoami")
+			}
+			// the exit status travels back through the gateway
+			if exitErr, ok := err.(*ssh.ExitError); !ok || exitErr.ExitStatus() != 3 {
+				t.Errorf("Output() error = %v, want exit status 3", err)
+			}
+		})
+	}
+}
+
+func TestLoadHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "host_key")
+
+	first, err := LoadHostKey(path)
+	if err != nil {
+		t.Fatalf("LoadHostKey() error = %v", err)
+	}
+	second, err := LoadHostKey(path)
+	if err != nil {
+		t.Fatalf("LoadHostKey() error = %v", err)
+	}
+	if ssh.FingerprintSHA256(first.PublicKey()) != ssh.FingerprintSHA256(second.PublicKey()) {
+		t.Errorf("host key changed between starts")
+	}
+}
+
+func TestGatewayClosesSessions(t *testing.T) {
+	attemptKey := newSigner(t)
+	pod := newChallengePod(t, attemptKey.PublicKey())
+
+	server := NewServer(newSigner(t), func(ctx context.Context, token string) (*Target, error) {
+		return &Target{Addr: pod, User: "root", Signer: attemptKey}, nil
+	})
+	server.Sessions = NewSessions()
+	listener := listen(t)
+	go server.Serve(listener)
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "token",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(attemptKey)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+	// the session is tracked once a channel could be opened upstream
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession() error = %v", err)
+	}
+	defer session.Close()
+
+	if closed := server.Sessions.Close("other"); closed != 0 {
+		t.Errorf("Close(other) = %d, want 0", closed)
+	}
+	if closed := server.Sessions.Close("token"); closed != 1 {
+		t.Fatalf("Close(token) = %d, want 1", closed)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("participant connection still open after Close")
+	}
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadHostKey reads the gateway's host key from path, generating and saving
+// an ed25519 key on first start so participants see a stable fingerprint.
+// An empty path yields a throwaway key.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	if path != "" {
+		pemBytes, err := os.ReadFile(path)
+		if err == nil {
+			return ssh.ParsePrivateKey(pemBytes)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		log.Printf("SSH gateway using an ephemeral host key")
+		return signer, nil
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "challenge-service gateway")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+	log.Printf("SSH gateway generated host key %s", path)
+	return signer, nil
+}
@@ -0,0 +1,208 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// MaxRecordingSize caps the events kept per session, later events are
+// dropped and the recording marked truncated.
+const MaxRecordingSize = 8 << 20
+
+// Recording is one terminal session of an attempt in asciicast v2 format.
+type Recording struct {
+	Token   string
+	Session string
+	// Source is "ssh" for gateway sessions and "terminal" for web terminals.
+	Source    string
+	Command   string
+	StartedAt time.Time
+	EndedAt   time.Time
+	Truncated bool
+	Cast      []byte
+}
+
+// SaveFunc stores a finished recording.
+type SaveFunc func(ctx context.Context, recording *Recording) error
+
+// recorder captures a session as asciicast v2 events.
+type recorder struct {
+	mu        sync.Mutex
+	start     time.Time
+	width     int
+	height    int
+	term      string
+	command   string
+	events    bytes.Buffer
+	truncated bool
+	// incomplete UTF-8 sequences held back per event kind
+	pending map[string][]byte
+}
+
+func newRecorder(width, height int, term string) *recorder {
+	return &recorder{
+		start:   time.Now(),
+		width:   width,
+		height:  height,
+		term:    term,
+		pending: map[string][]byte{},
+	}
+}
+
+// writer records everything written to it as events of kind, "o" for
+// output and "i" for input.
+func (r *recorder) writer(kind string) io.Writer {
+	return recorderWriter{r, kind}
+}
+
+type recorderWriter struct {
+	r    *recorder
+	kind string
+}
+
+func (w recorderWriter) Write(p []byte) (int, error) {
+	w.r.mu.Lock()
+	defer w.r.mu.Unlock()
+
+	data := append(w.r.pending[w.kind], p...)
+	complete, rest := splitUTF8(data)
+	w.r.pending[w.kind] = append([]byte(nil), rest...)
+	if len(complete) > 0 {
+		w.r.event(w.kind, string(complete))
+	}
+	return len(p), nil
+}
+
+// size sets the terminal size, recording a resize once the session has
+// produced events.
+func (r *recorder) size(cols, rows int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.events.Len() == 0 {
+		r.width, r.height = cols, rows
+		return
+	}
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (r *recorder) setTerm(term string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.term = term
+}
+
+func (r *recorder) setCommand(command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.command = command
+}
+
+// observe picks the terminal type, size and command out of session
+// requests.
+func (r *recorder) observe(req *ssh.Request) {
+	switch req.Type {
+	case "pty-req":
+		var pty struct {
+			Term               string
+			Cols, Rows, Wd, Ht uint32
+			Modes              string
+		}
+		if ssh.Unmarshal(req.Payload, &pty) == nil {
+			r.setTerm(pty.Term)
+			r.size(int(pty.Cols), int(pty.Rows))
+		}
+	case "window-change":
+		var size struct{ Cols, Rows, Wd, Ht uint32 }
+		if ssh.Unmarshal(req.Payload, &size) == nil {
+			r.size(int(size.Cols), int(size.Rows))
+		}
+	case "exec":
+		var exec struct{ Command string }
+		if ssh.Unmarshal(req.Payload, &exec) == nil {
+			r.setCommand(exec.Command)
+		}
+	}
+}
+
+// event appends an event line, the caller holds mu.
+func (r *recorder) event(kind, data string) {
+	if r.truncated {
+		return
+	}
+	line, _ := json.Marshal([]interface{}{time.Since(r.start).Seconds(), kind, data})
+	if r.events.Len()+len(line)+1 > MaxRecordingSize {
+		r.truncated = true
+		return
+	}
+	r.events.Write(line)
+	r.events.WriteByte('\n')
+}
+
+// finish returns the recording with the asciicast header prepended.
+func (r *recorder) finish(token, source string) *Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	header := map[string]interface{}{
+		"version":   2,
+		"width":     r.width,
+		"height":    r.height,
+		"timestamp": r.start.Unix(),
+	}
+	if r.term != "" {
+		header["env"] = map[string]string{"TERM": r.term}
+	}
+	if r.command != "" {
+		header["command"] = r.command
+	}
+	cast, _ := json.Marshal(header)
+	cast = append(cast, '\n')
+	cast = append(cast, r.events.Bytes()...)
+
+	return &Recording{
+		Token:     token,
+		Session:   uuid.NewString(),
+		Source:    source,
+		Command:   r.command,
+		StartedAt: r.start,
+		EndedAt:   time.Now(),
+		Truncated: r.truncated,
+		Cast:      cast,
+	}
+}
+
+// save stores the recording, logging failures since the session is over.
+func (r *recorder) save(save SaveFunc, token, source string) {
+	recording := r.finish(token, source)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := save(ctx, recording); err != nil {
+		log.Printf("Failed to save recording of attempt %s: %s", token, err)
+	}
+}
+
+// splitUTF8 holds back a trailing incomplete rune so multi-byte characters
+// split across reads are not mangled.
+func splitUTF8(p []byte) ([]byte, []byte) {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return p[:i], p[i:]
+			}
+			break
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// parseCast splits a recording into its header and events.
+func parseCast(t *testing.T, cast []byte) (map[string]interface{}, [][]interface{}) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(bytes.NewReader(cast))
+	var header map[string]interface{}
+	var events [][]interface{}
+	for scanner.Scan() {
+		if header == nil {
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				t.Fatalf("invalid header: %v", err)
+			}
+			continue
+		}
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+// eventData returns the data of all events of a kind.
+func eventData(events [][]interface{}, kind string) []string {
+	var data []string
+	for _, event := range events {
+		if event[1] == kind {
+			data = append(data, event[2].(string))
+		}
+	}
+	return data
+}
+
+func TestRecorder(t *testing.T) {
+	rec := newRecorder(80, 24, "xterm")
+	rec.size(100, 30)
+
+	output := rec.writer("o")
+	// a euro sign split across two reads
+	output.Write([]byte("price: \xe2\x82"))
+	output.Write([]byte("\xac\n"))
+	rec.writer("i").Write([]byte("q"))
+	rec.size(120, 40)
+
+	recording := rec.finish("token", "ssh")
+	header, events := parseCast(t, recording.Cast)
+
+	if header["version"] != 2.0 || header["width"] != 100.0 || header["height"] != 30.0 {
+		t.Errorf("header = %v, want version 2 at 100x30", header)
+	}
+	if got := eventData(events, "o"); len(got) != 2 || got[0]+got[1] != "price: €\n" {
+		t.Errorf("output events = %q", got)
+	}
+	if got := eventData(events, "i"); len(got) != 1 || got[0] != "q" {
+		t.Errorf("input events = %q", got)
+	}
+	if got := eventData(events, "r"); len(got) != 1 || got[0] != "120x40" {
+		t.Errorf("resize events = %q", got)
+	}
+	if recording.Token != "token" || recording.Source != "ssh" || recording.Truncated {
+		t.Errorf("recording = %+v", recording)
+	}
+}
+
+func TestRecorderTruncates(t *testing.T) {
+	rec := newRecorder(80, 24, "")
+	chunk := bytes.Repeat([]byte("x"), 1<<20)
+	for i := 0; i < 10; i++ {
+		rec.writer("o").Write(chunk)
+	}
+
+	recording := rec.finish("token", "ssh")
+	if !recording.Truncated {
+		t.Errorf("recording over %d bytes should be truncated", MaxRecordingSize)
+	}
+	if len(recording.Cast) > MaxRecordingSize+1024 {
+		t.Errorf("recording is %d bytes", len(recording.Cast))
+	}
+}
+
+func TestGatewayRecordsSession(t *testing.T) {
+	attemptKey := newSigner(t)
+	pod := newChallengePod(t, attemptKey.PublicKey())
+
+	recordings := make(chan *Recording, 1)
+	server := NewServer(newSigner(t), func(ctx context.Context, token string) (*Target, error) {
+		return &Target{Addr: pod, User: "root", Signer: attemptKey}, nil
+	})
+	server.Record = func(ctx context.Context, recording *Recording) error {
+		recordings <- recording
+		return nil
+	}
+	listener := listen(t)
+	go server.Serve(listener)
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "token",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(attemptKey)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	session, _ := client.NewSession()
+	session.Output("whoami")
+	session.Close()
+
+	select {
+	case recording := <-recordings:
+		_, events := parseCast(t, recording.Cast)
+		if recording.Token != "token" || recording.Source != "ssh" || recording.Command != "whoami" {
+			t.Errorf("recording = %+v", recording)
+		}
+		if got := eventData(events, "o"); len(got) != 1 || got[0] != "root ran whoami" {
+			t.Errorf("output events = %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not recorded")
+	}
+}
+
+func TestTerminalRecordsSession(t *testing.T) {
+	attemptKey := newSigner(t)
+	pod := newChallengePod(t, attemptKey.PublicKey())
+
+	recordings := make(chan *Recording, 1)
+	terminal := NewTerminal(func(ctx context.Context, token string) (*Target, error) {
+		return &Target{Addr: pod, User: "root", Signer: attemptKey}, nil
+	}, nil)
+	terminal.Record = func(ctx context.Context, recording *Recording) error {
+		recordings <- recording
+		return nil
+	}
+	url := serveTerminal(t, terminal)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token=token", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "hello"})
+	readOutput(t, conn)
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "exit"})
+
+	select {
+	case recording := <-recordings:
+		header, events := parseCast(t, recording.Cast)
+		if recording.Source != "terminal" || header["width"] != 80.0 {
+			t.Errorf("recording = %+v, header = %v", recording, header)
+		}
+		if got := eventData(events, "i"); len(got) != 2 || got[0] != "hello" {
+			t.Errorf("input events = %q", got)
+		}
+		if got := eventData(events, "o"); len(got) != 1 || got[0] != "hello" {
+			t.Errorf("output events = %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not recorded")
+	}
+}
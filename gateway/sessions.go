@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"io"
+	"sync"
+)
+
+// Sessions tracks the upstream connections of live gateway and terminal
+// sessions, so access to an attempt can be withdrawn from those already in.
+type Sessions struct {
+	mu    sync.Mutex
+	conns map[string]map[io.Closer]struct{}
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{conns: map[string]map[io.Closer]struct{}{}}
+}
+
+// add tracks an attempt's connection until the returned func is called. A
+// nil Sessions tracks nothing.
+func (s *Sessions) add(token string, conn io.Closer) func() {
+	if s == nil {
+		return func() {}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[token] == nil {
+		s.conns[token] = map[io.Closer]struct{}{}
+	}
+	s.conns[token][conn] = struct{}{}
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.conns[token], conn)
+		if len(s.conns[token]) == 0 {
+			delete(s.conns, token)
+		}
+	}
+}
+
+// Close ends every session of an attempt, returning how many there were.
+// Closing the upstream connection hangs up on the participant.
+func (s *Sessions) Close(token string) int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	conns := s.conns[token]
+	delete(s.conns, token)
+	s.mu.Unlock()
+
+	for conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
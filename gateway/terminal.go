@@ -0,0 +1,210 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// TerminalMessage is sent by the browser as a text frame. Input carries
+// keystrokes, resize the new terminal size.
+type TerminalMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// Terminal serves web terminals into running attempts. Browsers connect
+// with ?token=<attempt token>&cols=&rows= and receive the TTY output as
+// binary frames.
+type Terminal struct {
+	lookup   LookupFunc
+	upgrader websocket.Upgrader
+	// IdleTimeout closes sessions without input from the browser.
+	IdleTimeout time.Duration
+	// DialTimeout bounds the connection to the challenge pod.
+	DialTimeout time.Duration
+	// Record, if set, stores every session as an asciicast recording.
+	Record SaveFunc
+	// Sessions, if set, tracks live sessions so they can be closed.
+	Sessions *Sessions
+}
+
+// NewTerminal returns a Terminal accepting connections from the given
+// origins, or only same-origin connections if there are none.
+func NewTerminal(lookup LookupFunc, allowedOrigins []string) *Terminal {
+	t := &Terminal{
+		lookup:      lookup,
+		IdleTimeout: 15 * time.Minute,
+		DialTimeout: 10 * time.Second,
+	}
+	if len(allowedOrigins) > 0 {
+		t.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			for _, allowed := range allowedOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return t
+}
+
+func (t *Terminal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	cols := queryInt(r, "cols", 80)
+	rows := queryInt(r, "rows", 24)
+
+	ctx, cancel := context.WithTimeout(r.Context(), t.DialTimeout)
+	target, err := t.lookup(ctx, token)
+	cancel()
+	if errors.Is(err, ErrUnknownAttempt) {
+		http.Error(w, "unknown attempt", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Terminal lookup of attempt %s failed: %s", token, err)
+		http.Error(w, "attempt unavailable", http.StatusBadGateway)
+		return
+	}
+
+	upstream, err := dial(target, t.DialTimeout)
+	if err != nil {
+		log.Printf("Terminal could not reach attempt %s at %s: %s", token, target.Addr, err)
+		http.Error(w, "attempt unavailable", http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	defer t.Sessions.add(token, upstream)()
+
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already answered the request
+		return
+	}
+	defer conn.Close()
+
+	log.Printf("Terminal opened for attempt %s", token)
+	err = t.session(token, conn, upstream, cols, rows)
+	log.Printf("Terminal closed for attempt %s: %v", token, err)
+}
+
+// session runs a shell on a TTY and pipes it to the WebSocket until either
+// side ends it.
+func (t *Terminal) session(token string, conn *websocket.Conn, upstream *ssh.Client, cols, rows int) error {
+	session, err := upstream.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := session.RequestPty("xterm-256color", rows, cols, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+		return err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	output := &wsWriter{conn: conn}
+	session.Stdout = output
+	session.Stderr = output
+
+	input := io.Discard
+	resize := func(cols, rows int) {}
+	if t.Record != nil {
+		rec := newRecorder(cols, rows, "xterm-256color")
+		session.Stdout = io.MultiWriter(output, rec.writer("o"))
+		session.Stderr = session.Stdout
+		input = rec.writer("i")
+		resize = rec.size
+		defer rec.save(t.Record, token, "terminal")
+	}
+	if err := session.Shell(); err != nil {
+		return err
+	}
+
+	// close the socket once the shell exits, ending the read loop below
+	exited := make(chan error, 1)
+	go func() {
+		err := session.Wait()
+		exited <- err
+		output.close(websocket.CloseNormalClosure, "session ended")
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(t.IdleTimeout))
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case err := <-exited:
+				return err
+			default:
+			}
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				output.close(websocket.CloseGoingAway, "idle timeout")
+			}
+			return err
+		}
+
+		var msg TerminalMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "input":
+			if _, err := io.WriteString(stdin, msg.Data); err != nil {
+				return err
+			}
+			io.WriteString(input, msg.Data)
+		case "resize":
+			if msg.Cols > 0 && msg.Rows > 0 {
+				session.WindowChange(msg.Rows, msg.Cols)
+				resize(msg.Cols, msg.Rows)
+			}
+		}
+	}
+}
+
+// wsWriter sends terminal output as binary frames. stdout and stderr write
+// concurrently, gorilla connections allow a single writer only.
+type wsWriter struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *wsWriter) close(code int, reason string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	w.conn.Close()
+}
+
+func queryInt(r *http.Request, key string, fallback int) int {
+	value, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestTerminal(t *testing.T, idle time.Duration) string {
+	t.Helper()
+
+	attemptKey := newSigner(t)
+	pod := newChallengePod(t, attemptKey.PublicKey())
+
+	terminal := NewTerminal(func(ctx context.Context, token string) (*Target, error) {
+		if token != "token" {
+			return nil, ErrUnknownAttempt
+		}
+		return &Target{Addr: pod, User: "root", Signer: attemptKey}, nil
+	}, nil)
+	terminal.IdleTimeout = idle
+
+	return serveTerminal(t, terminal)
+}
+
+// serveTerminal serves the terminal and returns its WebSocket URL.
+func serveTerminal(t *testing.T, terminal *Terminal) string {
+	t.Helper()
+	server := httptest.NewServer(terminal)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func readOutput(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	kind, payload, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	if kind != websocket.BinaryMessage {
+		t.Errorf("message type = %d, want binary", kind)
+	}
+	return string(payload)
+}
+
+func TestTerminalSession(t *testing.T) {
+	url := newTestTerminal(t, time.Minute)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token=token&cols=100&rows=30", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "hello"})
+	if got := readOutput(t, conn); got != "hello" {
+		t.Errorf("output = %q, want the echoed input", got)
+	}
+
+	conn.WriteJSON(TerminalMessage{Type: "resize", Cols: 120, Rows: 40})
+	if got := readOutput(t, conn); got != "resized 120x40" {
+		t.Errorf("output = %q, want the new window size", got)
+	}
+
+	conn.WriteJSON(TerminalMessage{Type: "input", Data: "exit"})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("ReadMessage() error = %v, want a normal close when the shell exits", err)
+	}
+}
+
+func TestTerminalIdleTimeout(t *testing.T) {
+	url := newTestTerminal(t, 50*time.Millisecond)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token=token", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("ReadMessage() error = %v, want an idle close", err)
+	}
+}
+
+func TestTerminalUnknownAttempt(t *testing.T) {
+	url := newTestTerminal(t, time.Minute)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?token=other", nil)
+	if err == nil {
+		t.Fatalf("Dial() should fail for an unknown attempt")
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("response = %v, want 404", resp)
+	}
+}
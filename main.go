@@ -2,24 +2,118 @@ package main
 
 import (
 	"log"
+	"net/http"
 
-	"sys.io/challenge-service/services"
+	"helm.sh/helm/v3/pkg/repo"
 	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/gateway"
+	"sys.io/challenge-service/services"
+	"sys.io/challenge-service/utils"
 )
 
 func main() {
 
+	// connect to the database, it loads its settings from secrets/.env
+	config.ConnectDB()
+
 	// init env
 	log.Println("Loadingg .env file")
 	config.InitEnv()
 	log.Println(".env loaded!")
 
+	config.InitAuditIndexes(config.Client, config.AUDIT_RETENTION)
+
 	rmq := config.SetupMQ()
 	defer rmq.Conn.Close()
 	defer rmq.Ch.Close()
 
+	// background work publishes on a channel of its own
+	publisher := service.NewPublisher(rmq)
+	defer publisher.Close()
+	service.SetPublisher(publisher)
+
+	kube := config.SetupKube()
+	service.SetKube(kube.Client, kube.Rest)
+
+	// configure the challenge deployers
+	endpoints, err := deploy.NewEndpointResolver(config.ENDPOINT_STRATEGY, config.ENDPOINT_PUBLIC_HOSTNAME, config.SSH_GATEWAY_PUBLIC_PORT)
+	utils.FailOnError(err, "Invalid endpoint strategy")
+
+	service.SetDeployer(deploy.BackendManifest, deploy.NewManifestDeployer(deploy.ManifestOptions{
+		Kube:       kube.Client,
+		RestConfig: kube.Rest,
+		Endpoints:  endpoints,
+	}))
+
+	helmDeployer, err := deploy.NewHelmDeployer(deploy.HelmOptions{
+		RestConfig: kube.Rest,
+		KubeConfig: kube.KubeConfig,
+		Kube:       kube.Client,
+		Namespace:  "challenge",
+		Repo: repo.Entry{
+			Name:               config.HELM_REPO_NAME,
+			URL:                config.HELM_REPO_URL,
+			Username:           config.HELM_REPO_USERNAME,
+			Password:           config.HELM_REPO_PASSWORD,
+			PassCredentialsAll: true,
+		},
+		ChartName: config.HELM_CHART_NAME,
+		Debug:     config.ENVIRONMENT == "DEV",
+		Endpoints: endpoints,
+	})
+	if err != nil {
+		log.Printf("Helm deployer unavailable: %s", err)
+	} else {
+		service.SetDeployer(deploy.BackendHelm, helmDeployer)
+	}
+
+	// sessions are closed when an attempt's access is withdrawn
+	sessions := gateway.NewSessions()
+	service.SetSessions(sessions)
+
+	// participants reach their challenge pods through one shared port
+	if config.SSH_GATEWAY_ADDR != "" {
+		hostKey, err := gateway.LoadHostKey(config.SSH_GATEWAY_HOST_KEY)
+		utils.FailOnError(err, "Failed to load SSH gateway host key")
+
+		server := gateway.NewServer(hostKey, service.GatewayTarget)
+		server.Sessions = sessions
+		if config.SESSION_RECORDING {
+			server.Record = service.SaveRecording
+		}
+		go func() {
+			err := server.ListenAndServe(config.SSH_GATEWAY_ADDR)
+			utils.FailOnError(err, "SSH gateway stopped")
+		}()
+	}
+
+	// browsers get a terminal into the same pods over WebSocket
+	if config.TERMINAL_ADDR != "" {
+		terminal := gateway.NewTerminal(service.GatewayTarget, config.TERMINAL_ALLOWED_ORIGINS)
+		terminal.IdleTimeout = config.TERMINAL_IDLE_TIMEOUT
+		terminal.Sessions = sessions
+		if config.SESSION_RECORDING {
+			terminal.Record = service.SaveRecording
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/terminal", terminal)
+		go func() {
+			log.Printf("Web terminal listening on %s", config.TERMINAL_ADDR)
+			err := http.ListenAndServe(config.TERMINAL_ADDR, mux)
+			utils.FailOnError(err, "Web terminal stopped")
+		}()
+	}
+
+	go service.MaintainPools(config.WARM_POOL_INTERVAL)
+	if config.RECONCILE_INTERVAL > 0 {
+		go service.RunReconciler(config.RECONCILE_INTERVAL, config.RECONCILE_GRACE, config.RECONCILE_DRY_RUN)
+	}
+
+	go service.RunScheduler(config.SCHEDULE_INTERVAL)
 
 	go service.Consume(rmq, "queue.challenge.toService")
-	
+
 	select {}
 }
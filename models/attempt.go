@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // Generated by https://quicktype.io
 
 type Attempt struct {
@@ -12,4 +14,19 @@ type Attempt struct {
 	ChallengeName     string  `json:"challengeName" bson:"challengeName"`
 	CreatorName       string  `json:"creatorName" bson:"creatorName"`
 	ImageRegistryLink string  `json:"imageRegistryLink" bson:"imageRegistryLink"`
+	Namespace         string  `json:"namespace,omitempty" bson:"namespace,omitempty"`
+	// ReleaseName is set when the attempt runs on a release not named after
+	// its token, e.g. one claimed from a warm pool.
+	ReleaseName string     `json:"releaseName,omitempty" bson:"releaseName,omitempty"`
+	StartedAt   *time.Time `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	EndedAt     *time.Time `json:"endedAt,omitempty" bson:"endedAt,omitempty"`
+	// Status is the attempt's lifecycle state, attempts stored before it
+	// existed have none and count as created.
+	Status      string              `json:"status,omitempty" bson:"status,omitempty"`
+	Transitions []AttemptTransition `json:"transitions,omitempty" bson:"transitions,omitempty"`
+	SubmittedAt *time.Time          `json:"submittedAt,omitempty" bson:"submittedAt,omitempty"`
+	GradedAt    *time.Time          `json:"gradedAt,omitempty" bson:"gradedAt,omitempty"`
+	// Feedback explains the result to the participant.
+	Feedback string       `json:"feedback,omitempty" bson:"feedback,omitempty"`
+	Checks   []GradeCheck `json:"checks,omitempty" bson:"checks,omitempty"`
 }
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// kinds of audit log entries
+const (
+	AuditCommand    = "command"
+	AuditTransition = "transition"
+	AuditAction     = "action"
+	AuditEvent      = "event"
+	AuditError      = "error"
+)
+
+// AuditEntry records one thing that happened to an attempt or challenge.
+// Entries are only ever appended.
+type AuditEntry struct {
+	Kind string `json:"kind" bson:"kind"`
+	// Action is the command's routing key, the published event status or
+	// the deployer operation.
+	Action        string `json:"action" bson:"action"`
+	Token         string `json:"token,omitempty" bson:"token,omitempty"`
+	CreatorName   string `json:"creatorName,omitempty" bson:"creatorName,omitempty"`
+	ChallengeName string `json:"challengeName,omitempty" bson:"challengeName,omitempty"`
+	CorID         string `json:"corId,omitempty" bson:"corId,omitempty"`
+	// Actor is who verifiably caused the entry, the background job for
+	// automatic actions and the broker user that published a command.
+	Actor string `json:"actor,omitempty" bson:"actor,omitempty"`
+	// ClaimedActor is the user a command names itself, as the platform
+	// sent it. Nothing here verifies it.
+	ClaimedActor string    `json:"claimedActor,omitempty" bson:"claimedActor,omitempty"`
+	Release      string    `json:"release,omitempty" bson:"release,omitempty"`
+	From         string    `json:"from,omitempty" bson:"from,omitempty"`
+	To           string    `json:"to,omitempty" bson:"to,omitempty"`
+	Error        string    `json:"error,omitempty" bson:"error,omitempty"`
+	At           time.Time `json:"at" bson:"at"`
+}
+
+// AuditQuery selects audit entries, empty fields match everything.
+type AuditQuery struct {
+	Token         string
+	CreatorName   string
+	ChallengeName string
+	CorID         string
+	Since         time.Time
+	// Until pages back, entries after it are left out.
+	Until time.Time
+	Limit int64
+}
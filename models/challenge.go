@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // Generated by https://quicktype.io
 
 type Challenge struct {
@@ -9,6 +11,66 @@ type Challenge struct {
 	ImageName         string   `json:"imageName" bson:"imageName"`
 	ImageTag          string   `json:"imageTag" bson:"imageTag"`
 	ImageRegistryLink string   `json:"imageRegistryLink" bson:"imageRegistryLink"`
+	ImageDigest       string   `json:"imageDigest,omitempty" bson:"imageDigest,omitempty"`
 	Duration          int64    `json:"duration" bson:"duration"`
 	Participants      []string `json:"participants" bson:"participants"`
+	Deployer          string   `json:"deployer,omitempty" bson:"deployer,omitempty"`
+	// Values override the chart values of every attempt.
+	Values map[string]interface{} `json:"values,omitempty" bson:"values,omitempty"`
+	// RegistryCredentials pull the image when it is not covered by the
+	// cluster's shared pull secret. They are only read from the command,
+	// the challenge keeps them in the secret RegistrySecret names.
+	RegistryCredentials *RegistryCredentials `json:"registryCredentials,omitempty" bson:"-"`
+	RegistrySecret      string               `json:"-" bson:"registrySecret,omitempty"`
+	Resources           *ResourceProfile     `json:"resources,omitempty" bson:"resources,omitempty"`
+	Egress              *EgressPolicy        `json:"egress,omitempty" bson:"egress,omitempty"`
+	// WarmPool is how many pre-started instances are kept ready to claim.
+	WarmPool int `json:"warmPool,omitempty" bson:"warmPool,omitempty"`
+	// Revision counts the updates that changed what attempts run.
+	Revision int `json:"revision,omitempty" bson:"revision,omitempty"`
+	// StartsAt and EndsAt bound when attempts may run, either may be unset.
+	StartsAt *time.Time `json:"startsAt,omitempty" bson:"startsAt,omitempty"`
+	EndsAt   *time.Time `json:"endsAt,omitempty" bson:"endsAt,omitempty"`
+	// PreStart is how many minutes before StartsAt an instance is started
+	// for every participant, so the window opens without waiting.
+	PreStart int64 `json:"preStart,omitempty" bson:"preStart,omitempty"`
+	// OnSubmit is what happens to an attempt's environment once submitted,
+	// SubmitFreeze (the default) or SubmitTeardown.
+	OnSubmit string `json:"onSubmit,omitempty" bson:"onSubmit,omitempty"`
+	// Grader grades submitted attempts, without one results are recorded
+	// with attemptResult.
+	Grader *GraderSpec `json:"grader,omitempty" bson:"grader,omitempty"`
+	// ClosedAt is set once the attempts were torn down at EndsAt.
+	ClosedAt *time.Time `json:"closedAt,omitempty" bson:"closedAt,omitempty"`
+	// ArchivedAt is set once the challenge was deleted but kept for its
+	// attempts' history.
+	ArchivedAt *time.Time `json:"archivedAt,omitempty" bson:"archivedAt,omitempty"`
+}
+
+type RegistryCredentials struct {
+	Username string `json:"username" bson:"username"`
+	Password string `json:"password" bson:"password"`
+}
+
+// ResourceProfile sizes every attempt pod of a challenge, either by naming a
+// tier (small, medium, large) or with explicit requests and limits, which
+// take precedence over the tier's.
+type ResourceProfile struct {
+	Tier     string       `json:"tier,omitempty" bson:"tier,omitempty"`
+	Requests ResourceList `json:"requests,omitempty" bson:"requests,omitempty"`
+	Limits   ResourceList `json:"limits,omitempty" bson:"limits,omitempty"`
+}
+
+type ResourceList struct {
+	CPU              string `json:"cpu,omitempty" bson:"cpu,omitempty"`
+	Memory           string `json:"memory,omitempty" bson:"memory,omitempty"`
+	EphemeralStorage string `json:"ephemeralStorage,omitempty" bson:"ephemeralStorage,omitempty"`
+}
+
+// EgressPolicy limits outbound traffic of attempt pods. Mode is "deny",
+// "dns" (DNS lookups only) or "allowlist"; empty leaves egress open.
+type EgressPolicy struct {
+	Mode      string   `json:"mode" bson:"mode"`
+	CIDRs     []string `json:"cidrs,omitempty" bson:"cidrs,omitempty"`
+	Hostnames []string `json:"hostnames,omitempty" bson:"hostnames,omitempty"`
 }
@@ -0,0 +1,35 @@
+package models
+
+import "errors"
+
+// GraderSpec grades submitted attempts automatically, either by running
+// Command in the attempt's pod or by running Image as a job next to it.
+// Both print the result as JSON on stdout.
+type GraderSpec struct {
+	Command []string `json:"command,omitempty" bson:"command,omitempty"`
+	Image   string   `json:"image,omitempty" bson:"image,omitempty"`
+	// Timeout is how many seconds grading may take, 0 uses the default.
+	Timeout int64 `json:"timeout,omitempty" bson:"timeout,omitempty"`
+}
+
+// GradeCheck is the outcome of one check a grader ran.
+type GradeCheck struct {
+	Name    string  `json:"name" bson:"name"`
+	Passed  bool    `json:"passed" bson:"passed"`
+	Score   float64 `json:"score,omitempty" bson:"score,omitempty"`
+	Message string  `json:"message,omitempty" bson:"message,omitempty"`
+}
+
+// Validate reports grader specs that do not say how to grade.
+func (g *GraderSpec) Validate() error {
+	if len(g.Command) == 0 && g.Image == "" {
+		return errors.New("grader needs a command or an image")
+	}
+	if len(g.Command) > 0 && g.Image != "" {
+		return errors.New("grader takes a command or an image, not both")
+	}
+	if g.Timeout < 0 {
+		return errors.New("grader timeout must not be negative")
+	}
+	return nil
+}
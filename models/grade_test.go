@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestGraderSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		grader  GraderSpec
+		wantErr bool
+	}{
+		{name: "command", grader: GraderSpec{Command: []string{"/grade.sh"}}},
+		{name: "image", grader: GraderSpec{Image: "registry.example/grader:1", Timeout: 120}},
+		{name: "neither", grader: GraderSpec{Timeout: 60}, wantErr: true},
+		{name: "both", grader: GraderSpec{Command: []string{"/grade.sh"}, Image: "grader"}, wantErr: true},
+		{name: "negative timeout", grader: GraderSpec{Image: "grader", Timeout: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.grader.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
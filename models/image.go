@@ -1,9 +1,11 @@
 package models
 
+// Image is a build recorded by the image builder service, one per tag.
 type Image struct {
 	CorId             string `json:"corId" bson:"corId"`
 	CreatorName       string `json:"creatorName" bson:"creatorName"`
 	ImageName         string `json:"imageName" bson:"imageName"`
+	ImageTag          string `json:"imageTag" bson:"imageTag"`
 	ImageRegistryLink string `json:"imageRegistryLink" bson:"imageRegistryLink"`
 	S3Path            string `json:"s3Path" bson:"s3Path"`
 }
@@ -11,3 +13,17 @@ type Image struct {
 type ImageList struct {
 	Images []Image `json:"images" bson:",inline"`
 }
+
+// ImageVersion is one tag of an image in the catalog.
+type ImageVersion struct {
+	CorId             string `json:"corId" bson:"corId"`
+	ImageTag          string `json:"imageTag" bson:"imageTag"`
+	ImageRegistryLink string `json:"imageRegistryLink" bson:"imageRegistryLink"`
+}
+
+// ImageCatalogEntry lists the versions of one of a creator's images.
+type ImageCatalogEntry struct {
+	CreatorName string         `json:"creatorName" bson:"creatorName"`
+	ImageName   string         `json:"imageName" bson:"imageName"`
+	Versions    []ImageVersion `json:"versions" bson:"versions"`
+}
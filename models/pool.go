@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// pool instance states
+const (
+	PoolWarming = "warming"
+	PoolReady   = "ready"
+)
+
+// PoolInstance is a pre-started release of a challenge waiting to be
+// claimed by an attempt.
+type PoolInstance struct {
+	Release       string `json:"release" bson:"release"`
+	Namespace     string `json:"namespace" bson:"namespace"`
+	CreatorName   string `json:"creatorName" bson:"creatorName"`
+	ChallengeName string `json:"challengeName" bson:"challengeName"`
+	Status        string `json:"status" bson:"status"`
+	// Revision is the challenge revision the instance was deployed from.
+	Revision  int       `json:"revision" bson:"revision"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Recording is a participant's terminal session in asciicast v2 format.
+type Recording struct {
+	Token     string    `json:"token" bson:"token"`
+	Session   string    `json:"session" bson:"session"`
+	Source    string    `json:"source" bson:"source"`
+	Command   string    `json:"command,omitempty" bson:"command,omitempty"`
+	StartedAt time.Time `json:"startedAt" bson:"startedAt"`
+	EndedAt   time.Time `json:"endedAt" bson:"endedAt"`
+	Truncated bool      `json:"truncated" bson:"truncated"`
+	Cast      string    `json:"cast" bson:"cast"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// attempt lifecycle states
+const (
+	AttemptCreated   = "created"
+	AttemptStarting  = "starting"
+	AttemptRunning   = "running"
+	AttemptStopping  = "stopping"
+	AttemptStopped   = "stopped"
+	AttemptExpired   = "expired"
+	AttemptFailed    = "failed"
+	AttemptSubmitted = "submitted"
+	AttemptGraded    = "graded"
+)
+
+// AttemptTransition records when an attempt entered a state.
+type AttemptTransition struct {
+	From string    `json:"from,omitempty" bson:"from,omitempty"`
+	To   string    `json:"to" bson:"to"`
+	At   time.Time `json:"at" bson:"at"`
+}
+
+// what happens to an attempt's environment on submission: frozen keeps it
+// for grading without participant access, teardown removes it right away
+const (
+	SubmitFreeze   = "freeze"
+	SubmitTeardown = "teardown"
+)
+
+// attemptTransitions lists the states each state may move to.
+var attemptTransitions = map[string][]string{
+	AttemptCreated:   {AttemptStarting, AttemptExpired},
+	AttemptStarting:  {AttemptRunning, AttemptFailed},
+	AttemptRunning:   {AttemptStopping, AttemptExpired, AttemptSubmitted, AttemptFailed},
+	AttemptStopping:  {AttemptStopped, AttemptFailed},
+	AttemptFailed:    {AttemptStarting, AttemptExpired},
+	AttemptSubmitted: {AttemptGraded, AttemptFailed},
+}
+
+// AttemptStatus returns an attempt's state, created for attempts stored
+// before states existed.
+func AttemptStatus(attempt *Attempt) string {
+	if attempt.Status == "" {
+		return AttemptCreated
+	}
+	return attempt.Status
+}
+
+// CanTransition reports whether an attempt may move from one state to
+// another.
+func CanTransition(from, to string) bool {
+	for _, next := range attemptTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// AttemptOver reports whether a state is final for the attempt's
+// environment.
+func AttemptOver(status string) bool {
+	switch status {
+	case AttemptStopped, AttemptExpired, AttemptGraded:
+		return true
+	}
+	return false
+}
+
+// AttemptActive reports whether an attempt in a state has an environment
+// deployed or being deployed.
+func AttemptActive(status string) bool {
+	switch status {
+	case AttemptStarting, AttemptRunning, AttemptStopping:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package models
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{AttemptCreated, AttemptStarting, true},
+		{AttemptStarting, AttemptRunning, true},
+		{AttemptRunning, AttemptStopping, true},
+		{AttemptStopping, AttemptStopped, true},
+		{AttemptFailed, AttemptStarting, true},
+		{AttemptRunning, AttemptSubmitted, true},
+		{AttemptSubmitted, AttemptGraded, true},
+		{AttemptRunning, AttemptStarting, false},
+		{AttemptCreated, AttemptStopping, false},
+		{AttemptStopped, AttemptStarting, false},
+		{AttemptGraded, AttemptSubmitted, false},
+		{AttemptExpired, AttemptRunning, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestAttemptStatus(t *testing.T) {
+	if got := AttemptStatus(&Attempt{}); got != AttemptCreated {
+		t.Errorf("AttemptStatus() of a legacy attempt = %v, want created", got)
+	}
+}
@@ -0,0 +1,101 @@
+package models
+
+import "reflect"
+
+// ChallengeUpdate is the part of a challenge an update may change, unset
+// fields keep their value.
+type ChallengeUpdate struct {
+	Duration     *int64           `json:"duration,omitempty"`
+	ImageTag     *string          `json:"imageTag,omitempty"`
+	Resources    *ResourceProfile `json:"resources,omitempty"`
+	Participants *[]string        `json:"participants,omitempty"`
+	// Force applies changes that affect attempts already running.
+	Force bool `json:"force,omitempty"`
+}
+
+// Apply returns the challenge with the update's fields set. A new image tag
+// still has to be resolved to its registry link and digest.
+func (u *ChallengeUpdate) Apply(challenge Challenge) Challenge {
+	if u.Duration != nil {
+		challenge.Duration = *u.Duration
+	}
+	if u.ImageTag != nil && *u.ImageTag != challenge.ImageTag {
+		challenge.ImageTag = *u.ImageTag
+		challenge.ImageRegistryLink = ""
+		challenge.ImageDigest = ""
+	}
+	if u.Resources != nil {
+		challenge.Resources = u.Resources
+	}
+	if u.Participants != nil {
+		challenge.Participants = *u.Participants
+	}
+	return challenge
+}
+
+// ChallengeChange is one changed field of a challenge. List fields report
+// what was added and removed instead of both values.
+type ChallengeChange struct {
+	Field   string      `json:"field"`
+	From    interface{} `json:"from,omitempty"`
+	To      interface{} `json:"to,omitempty"`
+	Added   []string    `json:"added,omitempty"`
+	Removed []string    `json:"removed,omitempty"`
+}
+
+// DiffChallenge lists the fields an update may change that differ between
+// two versions of a challenge.
+func DiffChallenge(before, after *Challenge) []ChallengeChange {
+	changes := []ChallengeChange{}
+	if before.Duration != after.Duration {
+		changes = append(changes, ChallengeChange{Field: "duration", From: before.Duration, To: after.Duration})
+	}
+	if before.ImageTag != after.ImageTag {
+		changes = append(changes, ChallengeChange{Field: "imageTag", From: before.ImageTag, To: after.ImageTag})
+	}
+	if before.ImageDigest != after.ImageDigest {
+		changes = append(changes, ChallengeChange{Field: "imageDigest", From: before.ImageDigest, To: after.ImageDigest})
+	}
+	if !reflect.DeepEqual(before.Resources, after.Resources) {
+		changes = append(changes, ChallengeChange{Field: "resources", From: before.Resources, To: after.Resources})
+	}
+	added, removed := DiffParticipants(before.Participants, after.Participants)
+	if len(added) > 0 || len(removed) > 0 {
+		changes = append(changes, ChallengeChange{Field: "participants", Added: added, Removed: removed})
+	}
+	return changes
+}
+
+// DiffParticipants returns who is in after but not before and the other way
+// round, in list order.
+func DiffParticipants(before, after []string) (added, removed []string) {
+	return missingFrom(before, after), missingFrom(after, before)
+}
+
+// missingFrom returns the entries of list not in other.
+func missingFrom(other, list []string) []string {
+	seen := map[string]bool{}
+	for _, s := range other {
+		seen[s] = true
+	}
+
+	var missing []string
+	for _, s := range list {
+		if !seen[s] {
+			missing = append(missing, s)
+			seen[s] = true
+		}
+	}
+	return missing
+}
+
+// SpecChanged reports whether a change alters what attempts run, so
+// instances deployed before it are out of date. A new tag only does if it
+// points at another digest.
+func (c ChallengeChange) SpecChanged() bool {
+	switch c.Field {
+	case "imageDigest", "resources":
+		return true
+	}
+	return false
+}
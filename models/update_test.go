@@ -0,0 +1,141 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChallengeUpdateApply(t *testing.T) {
+	duration := int64(90)
+	tag := "v2"
+	same := "v1"
+	participants := []string{"bob"}
+	challenge := Challenge{
+		Duration:          60,
+		ImageTag:          "v1",
+		ImageRegistryLink: "registry/app:v1",
+		ImageDigest:       "sha256:1",
+		Participants:      []string{"alice"},
+	}
+
+	tests := []struct {
+		name   string
+		update ChallengeUpdate
+		want   Challenge
+	}{
+		{
+			name:   "empty",
+			update: ChallengeUpdate{},
+			want:   challenge,
+		},
+		{
+			name:   "duration and participants",
+			update: ChallengeUpdate{Duration: &duration, Participants: &participants},
+			want: Challenge{
+				Duration:          90,
+				ImageTag:          "v1",
+				ImageRegistryLink: "registry/app:v1",
+				ImageDigest:       "sha256:1",
+				Participants:      []string{"bob"},
+			},
+		},
+		{
+			name:   "new tag drops the resolved image",
+			update: ChallengeUpdate{ImageTag: &tag},
+			want: Challenge{
+				Duration:     60,
+				ImageTag:     "v2",
+				Participants: []string{"alice"},
+			},
+		},
+		{
+			name:   "same tag keeps the resolved image",
+			update: ChallengeUpdate{ImageTag: &same},
+			want:   challenge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.update.Apply(challenge); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffChallenge(t *testing.T) {
+	before := Challenge{
+		Duration:     60,
+		ImageTag:     "v1",
+		ImageDigest:  "sha256:1",
+		Participants: []string{"alice", "bob"},
+	}
+
+	tests := []struct {
+		name  string
+		after Challenge
+		want  []ChallengeChange
+	}{
+		{
+			name:  "unchanged",
+			after: before,
+			want:  []ChallengeChange{},
+		},
+		{
+			name: "image",
+			after: Challenge{
+				Duration:     60,
+				ImageTag:     "v2",
+				ImageDigest:  "sha256:2",
+				Participants: []string{"alice", "bob"},
+			},
+			want: []ChallengeChange{
+				{Field: "imageTag", From: "v1", To: "v2"},
+				{Field: "imageDigest", From: "sha256:1", To: "sha256:2"},
+			},
+		},
+		{
+			name: "duration, resources and participants",
+			after: Challenge{
+				Duration:     30,
+				ImageTag:     "v1",
+				ImageDigest:  "sha256:1",
+				Resources:    &ResourceProfile{Tier: "small"},
+				Participants: []string{"bob", "carol"},
+			},
+			want: []ChallengeChange{
+				{Field: "duration", From: int64(60), To: int64(30)},
+				{Field: "resources", From: (*ResourceProfile)(nil), To: &ResourceProfile{Tier: "small"}},
+				{Field: "participants", Added: []string{"carol"}, Removed: []string{"alice"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffChallenge(&before, &tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffChallenge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChallengeChangeSpecChanged(t *testing.T) {
+	tests := []struct {
+		field string
+		want  bool
+	}{
+		{"duration", false},
+		{"imageTag", false},
+		{"imageDigest", true},
+		{"resources", true},
+		{"participants", false},
+	}
+
+	for _, tt := range tests {
+		if got := (ChallengeChange{Field: tt.field}).SpecChanged(); got != tt.want {
+			t.Errorf("SpecChanged() of %s = %v, want %v", tt.field, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// states of a challenge's window
+const (
+	WindowUpcoming = "upcoming"
+	WindowOpen     = "open"
+	WindowClosed   = "closed"
+)
+
+// Window returns whether the challenge's window is yet to open, open or
+// closed at now. Challenges without bounds are always open.
+func (c *Challenge) Window(now time.Time) string {
+	switch {
+	case c.EndsAt != nil && !now.Before(*c.EndsAt):
+		return WindowClosed
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		return WindowUpcoming
+	}
+	return WindowOpen
+}
+
+// PreStarting reports whether instances should be ready for every
+// participant at now: from PreStart minutes before the window opens until it
+// closes.
+func (c *Challenge) PreStarting(now time.Time) bool {
+	if c.PreStart <= 0 || c.StartsAt == nil || c.Window(now) == WindowClosed {
+		return false
+	}
+	return !now.Before(c.StartsAt.Add(-time.Duration(c.PreStart) * time.Minute))
+}
+
+// ValidateWindow checks the window's bounds are in order and pre-starting
+// has a start to count back from.
+func (c *Challenge) ValidateWindow() error {
+	if c.StartsAt != nil && c.EndsAt != nil && !c.StartsAt.Before(*c.EndsAt) {
+		return errors.New("endsAt must be after startsAt")
+	}
+	if c.PreStart < 0 {
+		return errors.New("preStart must not be negative")
+	}
+	if c.PreStart > 0 && c.StartsAt == nil {
+		return errors.New("preStart needs a startsAt")
+	}
+	return nil
+}
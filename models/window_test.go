@@ -0,0 +1,113 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChallengeWindow(t *testing.T) {
+	startsAt := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(2 * time.Hour)
+
+	tests := []struct {
+		name      string
+		challenge Challenge
+		now       time.Time
+		want      string
+		preStart  bool
+	}{
+		{
+			name:      "unbounded",
+			challenge: Challenge{},
+			now:       startsAt,
+			want:      WindowOpen,
+		},
+		{
+			name:      "before start",
+			challenge: Challenge{StartsAt: &startsAt, EndsAt: &endsAt},
+			now:       startsAt.Add(-time.Minute),
+			want:      WindowUpcoming,
+		},
+		{
+			name:      "at start",
+			challenge: Challenge{StartsAt: &startsAt, EndsAt: &endsAt},
+			now:       startsAt,
+			want:      WindowOpen,
+		},
+		{
+			name:      "at end",
+			challenge: Challenge{StartsAt: &startsAt, EndsAt: &endsAt},
+			now:       endsAt,
+			want:      WindowClosed,
+		},
+		{
+			name:      "only an end",
+			challenge: Challenge{EndsAt: &endsAt},
+			now:       startsAt.Add(-24 * time.Hour),
+			want:      WindowOpen,
+		},
+		{
+			name:      "before pre-start",
+			challenge: Challenge{StartsAt: &startsAt, EndsAt: &endsAt, PreStart: 10},
+			now:       startsAt.Add(-11 * time.Minute),
+			want:      WindowUpcoming,
+		},
+		{
+			name:      "pre-starting",
+			challenge: Challenge{StartsAt: &startsAt, EndsAt: &endsAt, PreStart: 10},
+			now:       startsAt.Add(-10 * time.Minute),
+			want:      WindowUpcoming,
+			preStart:  true,
+		},
+		{
+			name:      "pre-started while open",
+			challenge: Challenge{StartsAt: &startsAt, EndsAt: &endsAt, PreStart: 10},
+			now:       startsAt.Add(time.Hour),
+			want:      WindowOpen,
+			preStart:  true,
+		},
+		{
+			name:      "no pre-start once closed",
+			challenge: Challenge{StartsAt: &startsAt, EndsAt: &endsAt, PreStart: 10},
+			now:       endsAt,
+			want:      WindowClosed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.challenge.Window(tt.now); got != tt.want {
+				t.Errorf("Window() = %v, want %v", got, tt.want)
+			}
+			if got := tt.challenge.PreStarting(tt.now); got != tt.preStart {
+				t.Errorf("PreStarting() = %v, want %v", got, tt.preStart)
+			}
+		})
+	}
+}
+
+func TestChallengeValidateWindow(t *testing.T) {
+	startsAt := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(2 * time.Hour)
+
+	tests := []struct {
+		name      string
+		challenge Challenge
+		wantErr   bool
+	}{
+		{"unbounded", Challenge{}, false},
+		{"window", Challenge{StartsAt: &startsAt, EndsAt: &endsAt, PreStart: 5}, false},
+		{"ends before start", Challenge{StartsAt: &endsAt, EndsAt: &startsAt}, true},
+		{"empty window", Challenge{StartsAt: &startsAt, EndsAt: &startsAt}, true},
+		{"negative pre-start", Challenge{StartsAt: &startsAt, PreStart: -1}, true},
+		{"pre-start without start", Challenge{EndsAt: &endsAt, PreStart: 5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.challenge.ValidateWindow(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
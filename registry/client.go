@@ -0,0 +1,228 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sys.io/challenge-service/utils"
+)
+
+// manifest media types we accept, most specific first
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Client talks to OCI distribution registries to resolve tags to digests.
+type Client struct {
+	HTTPClient *http.Client
+	// InsecureHosts are spoken to over plain HTTP, e.g. a local test registry.
+	InsecureHosts []string
+}
+
+func NewClient(insecureHosts []string) *Client {
+	return &Client{
+		HTTPClient:    &http.Client{Timeout: 30 * time.Second},
+		InsecureHosts: insecureHosts,
+	}
+}
+
+// ResolveDigest returns the digest the image's tag currently points at. An
+// image that already carries a digest is returned as is.
+func (c *Client) ResolveDigest(ctx context.Context, image utils.ImageReference, creds *Credentials) (string, error) {
+	if image.Digest != "" {
+		return image.Digest, nil
+	}
+
+	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL(image.Registry), image.Repository, image.Tag)
+
+	// try HEAD first, some registries only send the digest header on GET
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		resp, err := c.do(ctx, method, manifestURL, image.Repository, creds)
+		if err != nil {
+			return "", err
+		}
+
+		digest, err := readDigest(resp, method)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %w", image.String(), err)
+		}
+		if digest != "" {
+			return digest, nil
+		}
+	}
+
+	return "", fmt.Errorf("registry returned no digest for %s", image.String())
+}
+
+func (c *Client) baseURL(host string) string {
+	// Docker Hub's API lives on a different host than its image names
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	scheme := "https"
+	for _, insecure := range c.InsecureHosts {
+		if insecure == host {
+			scheme = "http"
+		}
+	}
+	return scheme + "://" + host
+}
+
+// do sends the request, answering a Basic or Bearer auth challenge once.
+func (c *Client) do(ctx context.Context, method, manifestURL, repository string, creds *Credentials) (*http.Response, error) {
+	resp, err := c.send(ctx, method, manifestURL, "")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	var authorization string
+	switch scheme {
+	case "basic":
+		if creds == nil {
+			return nil, fmt.Errorf("registry requires credentials")
+		}
+		req, _ := http.NewRequest(http.MethodGet, manifestURL, http.NoBody)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		authorization = req.Header.Get("Authorization")
+	case "bearer":
+		token, err := c.fetchToken(ctx, params, repository, creds)
+		if err != nil {
+			return nil, err
+		}
+		authorization = "Bearer " + token
+	default:
+		return nil, fmt.Errorf("unsupported registry auth challenge %q", scheme)
+	}
+
+	return c.send(ctx, method, manifestURL, authorization)
+}
+
+func (c *Client) send(ctx context.Context, method, manifestURL, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, manifestURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// fetchToken gets a pull token from the realm named in a Bearer challenge.
+func (c *Client) fetchToken(ctx context.Context, params map[string]string, repository string, creds *Credentials) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+
+	query := realm.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", repository)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response contained no token")
+}
+
+// readDigest takes the digest from the response header, or hashes the
+// manifest body of a GET when the header is missing.
+func readDigest(resp *http.Response, method string) (string, error) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("image not found in registry")
+	default:
+		return "", fmt.Errorf("unexpected registry response: %s", resp.Status)
+	}
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	if method != http.MethodGet {
+		return "", nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its lower-cased
+// scheme and parameters.
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	params = map[string]string{}
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	scheme = strings.ToLower(scheme)
+
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+	return scheme, params
+}
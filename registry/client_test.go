@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sys.io/challenge-service/utils"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// newTestRegistry serves group/image:v1 behind the given auth scheme.
+func newTestRegistry(t *testing.T, auth string, sendHeader bool) (*httptest.Server, string) {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			user, pass, ok := r.BasicAuth()
+			if !ok || user != "user" || pass != "pass" || r.URL.Query().Get("scope") != "repository:group/image:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"t0ken"}`)
+			return
+		}
+
+		switch auth {
+		case "bearer":
+			if r.Header.Get("Authorization") != "Bearer t0ken" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "basic":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if r.URL.Path != "/v2/group/image/manifests/v1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+			t.Errorf("missing Accept header, got %q", r.Header.Get("Accept"))
+		}
+		if sendHeader {
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		}
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, `{"schemaVersion":2}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestResolveDigest(t *testing.T) {
+	bodyDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(`{"schemaVersion":2}`)))
+	creds := &Credentials{Username: "user", Password: "pass"}
+
+	tests := []struct {
+		name       string
+		auth       string
+		sendHeader bool
+		tag        string
+		creds      *Credentials
+		want       string
+		wantErr    bool
+	}{
+		{"Anonymous", "", true, "v1", nil, testDigest, false},
+		{"Bearer token", "bearer", true, "v1", creds, testDigest, false},
+		{"Basic auth", "basic", true, "v1", creds, testDigest, false},
+		{"Missing credentials", "basic", true, "v1", nil, "", true},
+		{"Digest from body", "", false, "v1", nil, bodyDigest, false},
+		{"Unknown tag", "", true, "v2", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, host := newTestRegistry(t, tt.auth, tt.sendHeader)
+			client := NewClient([]string{host})
+
+			image := utils.ImageReference{Registry: host, Repository: "group/image", Tag: tt.tag}
+			got, err := client.ResolveDigest(context.Background(), image, tt.creds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveDigest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveDigest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDigestPinned(t *testing.T) {
+	client := NewClient(nil)
+	image := utils.ImageReference{Registry: "unreachable.invalid", Repository: "img", Digest: testDigest}
+
+	got, err := client.ResolveDigest(context.Background(), image, nil)
+	if err != nil || got != testDigest {
+		t.Errorf("ResolveDigest() = %v, %v, want the pinned digest without a request", got, err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull"`)
+	if scheme != "bearer" {
+		t.Errorf("scheme = %v, want bearer", scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("params[%s] = %q, want %q", k, params[k], v)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// actors of the entries background jobs cause
+const (
+	poolActor      = "system:pool"
+	reconcileActor = "system:reconciler"
+	defaultActor   = "system"
+)
+
+// most entries a single audit list returns, older ones are paged to with
+// until
+const maxAuditEntries = 1000
+
+// auditScope is who and what the audit entries written under a context are
+// about.
+type auditScope struct {
+	Token         string `json:"token"`
+	CreatorName   string `json:"creatorName"`
+	ChallengeName string `json:"challengeName"`
+	CorID         string `json:"corId"`
+	// Actor is only set by the service itself, a command cannot name it.
+	Actor        string `json:"-"`
+	ClaimedActor string `json:"actor"`
+}
+
+type auditScopeKey struct{}
+
+func withAuditScope(ctx context.Context, scope auditScope) context.Context {
+	return context.WithValue(ctx, auditScopeKey{}, scope)
+}
+
+func auditScopeOf(ctx context.Context) auditScope {
+	scope, _ := ctx.Value(auditScopeKey{}).(auditScope)
+	return scope
+}
+
+// withAttempt narrows the context's scope to an attempt, keeping its actor
+// and corId.
+func withAttempt(ctx context.Context, attempt *models.Attempt) context.Context {
+	scope := auditScopeOf(ctx)
+	scope.Token = attempt.Token
+	scope.CreatorName = attempt.CreatorName
+	scope.ChallengeName = attempt.ChallengeName
+	return withAuditScope(ctx, scope)
+}
+
+// detached returns a context without ctx's deadline but with its scope, for
+// cleanups that must run after ctx timed out.
+func detached(ctx context.Context) context.Context {
+	return withAuditScope(context.Background(), auditScopeOf(ctx))
+}
+
+// commandScope is the scope of a command message. Its actor is the broker
+// user that published it, which the broker verifies when the user id is
+// set. Whoever the message names is only recorded as claimed. Commands
+// about an attempt are completed with its challenge.
+func commandScope(msg []byte, publisher string) auditScope {
+	var body struct {
+		auditScope
+		Participant string `json:"participant"`
+	}
+	if err := json.Unmarshal(msg, &body); err != nil {
+		return auditScope{Actor: publisher}
+	}
+
+	scope := body.auditScope
+	scope.Actor = publisher
+	if scope.ClaimedActor == "" {
+		scope.ClaimedActor = body.Participant
+	}
+	if scope.ClaimedActor == "" {
+		scope.ClaimedActor = scope.CreatorName
+	}
+	if scope.Token != "" && scope.ChallengeName == "" {
+		if attempt, err := store.GetAttempt(scope.Token); err == nil {
+			scope.CreatorName = attempt.CreatorName
+			scope.ChallengeName = attempt.ChallengeName
+		}
+	}
+	return scope
+}
+
+// audit appends an entry to the audit log, filling in what the context's
+// scope knows. Failing to write it never fails the audited operation.
+func audit(ctx context.Context, entry models.AuditEntry) {
+	scope := auditScopeOf(ctx)
+	if entry.Token == "" {
+		entry.Token = scope.Token
+	}
+	if entry.CreatorName == "" {
+		entry.CreatorName = scope.CreatorName
+	}
+	if entry.ChallengeName == "" {
+		entry.ChallengeName = scope.ChallengeName
+	}
+	entry.CorID = scope.CorID
+	entry.Actor = scope.Actor
+	entry.ClaimedActor = scope.ClaimedActor
+	if entry.Actor == "" && entry.ClaimedActor == "" {
+		entry.Actor = defaultActor
+	}
+	entry.At = time.Now()
+
+	if _, err := store.CreateAuditEntry(&entry); err != nil {
+		log.Printf("Failed to write audit entry %s %s: %s", entry.Kind, entry.Action, err)
+	}
+}
+
+// auditEvent records a published event, failures and events that could
+// not be published as errors.
+func auditEvent(ctx context.Context, data map[string]interface{}, eventStatus string, publishErr error) {
+	entry := models.AuditEntry{Kind: models.AuditEvent, Action: eventStatus}
+	if strings.HasSuffix(eventStatus, "Failed") {
+		entry.Kind = models.AuditError
+		reason, _ := data["failureReason"].(string)
+		message, _ := data["failureMessage"].(string)
+		entry.Error = strings.TrimSuffix(reason+": "+message, ": ")
+	}
+	if publishErr != nil {
+		entry.Kind = models.AuditError
+		entry.Error = strings.TrimPrefix(entry.Error+"; not published: "+publishErr.Error(), "; ")
+	}
+	audit(ctx, entry)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// auditedDeployer records every change a deployer makes to the cluster.
+type auditedDeployer struct {
+	deploy.Deployer
+	name string
+}
+
+func (d *auditedDeployer) Deploy(ctx context.Context, spec *deploy.Spec) error {
+	err := d.Deployer.Deploy(ctx, spec)
+	d.record(ctx, "deploy", spec.Release, err)
+	return err
+}
+
+func (d *auditedDeployer) Teardown(ctx context.Context, release deploy.Release) error {
+	err := d.Deployer.Teardown(ctx, release)
+	d.record(ctx, "teardown", release, err)
+	return err
+}
+
+func (d *auditedDeployer) record(ctx context.Context, action string, release deploy.Release, err error) {
+	audit(ctx, models.AuditEntry{
+		Kind:    models.AuditAction,
+		Action:  d.name + " " + action,
+		Release: release.Namespace + "/" + release.Name,
+		Error:   errorString(err),
+	})
+}
+
+// auditedInjector is an auditedDeployer of a deployer that can hand over
+// running releases, kept apart so type assertions still tell them apart.
+type auditedInjector struct {
+	*auditedDeployer
+	injector deploy.Injector
+}
+
+func (d *auditedInjector) Inject(ctx context.Context, release deploy.Release, injection *deploy.Injection) error {
+	err := d.injector.Inject(ctx, release, injection)
+	d.record(ctx, "inject", release, err)
+	return err
+}
+
+func auditDeployer(name string, d deploy.Deployer) deploy.Deployer {
+	audited := &auditedDeployer{Deployer: d, name: name}
+	if injector, ok := d.(deploy.Injector); ok {
+		return &auditedInjector{auditedDeployer: audited, injector: injector}
+	}
+	return audited
+}
+
+// ListAudit publishes the audit log of an attempt or a whole challenge to
+// the challenge's creator, newest first. A list cut short at
+// maxAuditEntries is marked truncated, nextUntil is the until that lists
+// the older entries. Entries stored within the same millisecond as the last one
+// listed may be listed again.
+func ListAudit(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	// one more than returned tells whether there are more
+	query := models.AuditQuery{Limit: maxAuditEntries + 1}
+	query.Token, _ = data["token"].(string)
+	query.CreatorName, _ = data["creatorName"].(string)
+	query.ChallengeName, _ = data["challengeName"].(string)
+	for key, bound := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		value, ok := data[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		*bound, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			data["failureReason"] = "invalidQuery"
+			data["failureMessage"] = fmt.Sprintf("invalid %s %q", key, value)
+			publishEvent(ch, ctx, data, "challengeAuditListFailed", routingKey)
+			return
+		}
+	}
+
+	switch {
+	case query.Token != "":
+		attempt, err := store.GetAttempt(query.Token)
+		// attempts of other creators look the same as missing ones
+		if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && attempt.CreatorName != query.CreatorName) {
+			data["failureReason"] = "attemptNotFound"
+			data["failureMessage"] = fmt.Sprintf("no attempt %s in challenges of %s", query.Token, query.CreatorName)
+			publishEvent(ch, ctx, data, "challengeAuditListFailed", routingKey)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to find attempt %s: %s", query.Token, err)
+			publishEvent(ch, ctx, data, "challengeAuditListFailed", routingKey)
+			return
+		}
+		// entries about the attempt may predate knowing its challenge
+		query.CreatorName, query.ChallengeName = "", ""
+	case query.CreatorName == "" || query.ChallengeName == "":
+		data["failureReason"] = "invalidQuery"
+		data["failureMessage"] = "either a token or a challenge is required"
+		publishEvent(ch, ctx, data, "challengeAuditListFailed", routingKey)
+		return
+	}
+
+	entries, err := store.ListAuditEntries(query)
+	if err != nil {
+		log.Printf("Failed to list audit entries: %s", err)
+		publishEvent(ch, ctx, data, "challengeAuditListFailed", routingKey)
+		return
+	}
+
+	data["truncated"] = len(entries) > maxAuditEntries
+	if len(entries) > maxAuditEntries {
+		data["nextUntil"] = entries[maxAuditEntries].At.Format(time.RFC3339Nano)
+		entries = entries[:maxAuditEntries]
+	}
+	data["entries"] = entries
+	publishEvent(ch, ctx, data, "challengeAuditListed", routingKey)
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestCommandScope(t *testing.T) {
+	m := &memStore{attempts: []models.Attempt{{Token: "token", CreatorName: "creator", ChallengeName: "ctf"}}}
+	useStore(t, m)
+
+	tests := []struct {
+		name      string
+		msg       map[string]interface{}
+		publisher string
+		want      auditScope
+	}{
+		{
+			name:      "attempt command",
+			msg:       map[string]interface{}{"token": "token", "participant": "alice", "corId": "c1"},
+			publisher: "platform",
+			want:      auditScope{Token: "token", CreatorName: "creator", ChallengeName: "ctf", CorID: "c1", Actor: "platform", ClaimedActor: "alice"},
+		},
+		{
+			name: "challenge command",
+			msg:  map[string]interface{}{"creatorName": "creator", "challengeName": "ctf"},
+			want: auditScope{CreatorName: "creator", ChallengeName: "ctf", ClaimedActor: "creator"},
+		},
+		{
+			// the message cannot pass itself off as the service
+			name:      "named actor",
+			msg:       map[string]interface{}{"creatorName": "creator", "challengeName": "ctf", "actor": reconcileActor},
+			publisher: "platform",
+			want:      auditScope{CreatorName: "creator", ChallengeName: "ctf", Actor: "platform", ClaimedActor: reconcileActor},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, _ := json.Marshal(tt.msg)
+			if got := commandScope(msg, tt.publisher); got != tt.want {
+				t.Errorf("commandScope() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditStop(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	attempt := models.Attempt{
+		Token:         "token",
+		CreatorName:   "creator",
+		ChallengeName: "ctf",
+		Status:        models.AttemptRunning,
+		StartedAt:     &startedAt,
+	}
+
+	tests := []struct {
+		name        string
+		teardownErr error
+		want        []models.AuditEntry
+	}{
+		{
+			name: "stopped",
+			want: []models.AuditEntry{
+				{Kind: models.AuditTransition, Action: models.AttemptStopping, From: models.AttemptRunning, To: models.AttemptStopping},
+				{Kind: models.AuditAction, Action: "fake teardown", Release: "challenge/atoken"},
+				{Kind: models.AuditTransition, Action: models.AttemptStopped, From: models.AttemptStopping, To: models.AttemptStopped},
+				{Kind: models.AuditEvent, Action: "challengeStopped"},
+			},
+		},
+		{
+			name:        "teardown fails",
+			teardownErr: errors.New("cluster unreachable"),
+			want: []models.AuditEntry{
+				{Kind: models.AuditTransition, Action: models.AttemptStopping, From: models.AttemptRunning, To: models.AttemptStopping},
+				{Kind: models.AuditAction, Action: "fake teardown", Release: "challenge/atoken", Error: "cluster unreachable"},
+				{Kind: models.AuditTransition, Action: models.AttemptFailed, From: models.AttemptStopping, To: models.AttemptFailed},
+				{Kind: models.AuditError, Action: "challengeStopFailed"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &memStore{
+				challenges: []models.Challenge{{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake"}},
+				attempts:   []models.Attempt{attempt},
+			}
+			fake := deploy.NewFake()
+			fake.TeardownErr = tt.teardownErr
+			deployRelease(t, fake, releaseOf(&attempt))
+			useStore(t, m)
+			useDeployer(t, fake)
+			useEvents(t)
+
+			msg, _ := json.Marshal(map[string]interface{}{"token": attempt.Token, "participant": "alice", "corId": "c1"})
+			ctx := withAuditScope(context.Background(), commandScope(msg, "platform"))
+			StopChallenge(nil, ctx, msg, "challengeStopped")
+
+			// every entry is about the attempt and caused by the command
+			got := []models.AuditEntry{}
+			for _, entry := range m.audit {
+				if entry.Token != attempt.Token || entry.ChallengeName != "ctf" || entry.Actor != "platform" || entry.ClaimedActor != "alice" || entry.CorID != "c1" || entry.At.IsZero() {
+					t.Errorf("entry %s %s has scope %s/%s by %q for %q corId %q", entry.Kind, entry.Action, entry.ChallengeName, entry.Token, entry.Actor, entry.ClaimedActor, entry.CorID)
+				}
+				got = append(got, models.AuditEntry{Kind: entry.Kind, Action: entry.Action, Release: entry.Release, From: entry.From, To: entry.To, Error: entry.Error})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("audit = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListAuditPages(t *testing.T) {
+	m := &memStore{}
+	first := time.Now().Add(-time.Hour)
+	for i := 0; i < maxAuditEntries+2; i++ {
+		m.audit = append(m.audit, models.AuditEntry{
+			Kind:          models.AuditAction,
+			Action:        fmt.Sprintf("action %d", i),
+			CreatorName:   "creator",
+			ChallengeName: "ctf",
+			At:            first.Add(time.Duration(i) * time.Second),
+		})
+	}
+	useStore(t, m)
+	events := useEvents(t)
+
+	list := func(until string) map[string]interface{} {
+		t.Helper()
+		msg, _ := json.Marshal(map[string]interface{}{"creatorName": "creator", "challengeName": "ctf", "until": until})
+		ListAudit(nil, context.Background(), msg, "challengeAuditListed")
+		event := events.Last()
+		if event["eventStatus"] != "challengeAuditListed" {
+			t.Fatalf("events = %v, want challengeAuditListed", events.Statuses())
+		}
+		return event
+	}
+	actions := func(event map[string]interface{}) []interface{} {
+		var actions []interface{}
+		entries, _ := event["entries"].([]interface{})
+		for _, entry := range entries {
+			actions = append(actions, entry.(map[string]interface{})["action"])
+		}
+		return actions
+	}
+
+	// the newest entries come first, the oldest are left to the next page
+	page := list("")
+	got := actions(page)
+	if len(got) != maxAuditEntries || got[0] != fmt.Sprintf("action %d", maxAuditEntries+1) {
+		t.Fatalf("first page has %d entries starting with %v, want %d starting with the newest", len(got), got[0], maxAuditEntries)
+	}
+	if page["truncated"] != true {
+		t.Errorf("truncated = %v, want true", page["truncated"])
+	}
+
+	until, _ := page["nextUntil"].(string)
+	page = list(until)
+	if got := actions(page); !reflect.DeepEqual(got, []interface{}{"action 1", "action 0"}) {
+		t.Errorf("second page = %v, want the two oldest entries", got)
+	}
+	if page["truncated"] != false {
+		t.Errorf("truncated = %v, want false", page["truncated"])
+	}
+}
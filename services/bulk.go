@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// bulkProgress counts the attempts a bulk command went through.
+type bulkProgress struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+}
+
+// bulkRun publishes a bulk command's progress after every attempt.
+type bulkRun struct {
+	ch             eventChannel
+	ctx            context.Context
+	data           map[string]interface{}
+	progressStatus string
+	routingKey     string
+
+	mu       sync.Mutex
+	progress bulkProgress
+}
+
+func (r *bulkRun) done(ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ok {
+		r.progress.Succeeded++
+	} else {
+		r.progress.Failed++
+	}
+
+	event := map[string]interface{}{}
+	for k, v := range r.data {
+		event[k] = v
+	}
+	event["progress"] = r.progress
+	publishEvent(r.ch, r.ctx, event, r.progressStatus, r.routingKey)
+}
+
+// fanOut runs fn for every attempt, at most concurrency at a time, and
+// returns once all are done.
+func (r *bulkRun) fanOut(attempts []models.Attempt, concurrency int, fn func(attempt *models.Attempt) bool) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range attempts {
+		attempt := &attempts[i]
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			r.done(fn(attempt))
+		}()
+	}
+	wg.Wait()
+}
+
+// bulkRuns counts the bulk commands still fanning out.
+var bulkRuns sync.WaitGroup
+
+// background runs a bulk command's fan-out off the consumer, which would
+// otherwise take no other command until every attempt is through. Its
+// events go out through the publisher, the consumer's channel may be gone
+// by the time they are published.
+func background(fn func()) {
+	bulkRuns.Add(1)
+	go func() {
+		defer bulkRuns.Done()
+		fn()
+	}()
+}
+
+// attemptEvent is the body of the per-attempt events a command publishes
+// besides its own, e.g. those of a bulk command.
+func attemptEvent(attempt *models.Attempt, data map[string]interface{}) map[string]interface{} {
+	event := map[string]interface{}{
+		"token":         attempt.Token,
+		"participant":   attempt.Participant,
+		"creatorName":   attempt.CreatorName,
+		"challengeName": attempt.ChallengeName,
+	}
+	if corID, ok := data["corId"]; ok {
+		event["corId"] = corID
+	}
+	return event
+}
+
+// bulkChallenge decodes a bulk command and loads its challenge, publishing
+// the failure event if either fails.
+func bulkChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, failedStatus string, routingKey string) (map[string]interface{}, *models.Challenge, bool) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return nil, nil, false
+	}
+
+	creatorName, _ := data["creatorName"].(string)
+	challengeName, _ := data["challengeName"].(string)
+	challenge, err := store.GetChallenge(creatorName, challengeName)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		data["failureReason"] = "challengeNotFound"
+		publishEvent(ch, ctx, data, failedStatus, routingKey)
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to find challenge %s: %s", challengeName, err)
+		publishEvent(ch, ctx, data, failedStatus, routingKey)
+		return nil, nil, false
+	}
+	return data, &challenge, true
+}
+
+// StartAllAttempts starts every attempt of a challenge that has not run or
+// failed, as many at once as BULK_CONCURRENCY allows and no more than the
+// cluster has room for.
+func StartAllAttempts(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+	data, challenge, ok := bulkChallenge(ch, ctx, msg, "challengeStartAllFailed", routingKey)
+	if !ok {
+		return
+	}
+	if !challengeStartable(challenge, data) {
+		publishEvent(ch, ctx, data, "challengeStartAllFailed", routingKey)
+		return
+	}
+
+	attempts, err := store.ListAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to list attempts of %s: %s", challenge.ChallengeName, err)
+		publishEvent(ch, ctx, data, "challengeStartAllFailed", routingKey)
+		return
+	}
+	startable := []models.Attempt{}
+	for _, attempt := range attempts {
+		if models.CanTransition(models.AttemptStatus(&attempt), models.AttemptStarting) {
+			startable = append(startable, attempt)
+		}
+	}
+
+	// attempts the cluster has no room for are reported and left as they are
+	var skipped []models.Attempt
+	if room := startCapacity(ctx, challenge); room >= 0 && room < len(startable) {
+		startable, skipped = startable[:room], startable[room:]
+	}
+	for i := range skipped {
+		event := attemptEvent(&skipped[i], data)
+		event["failureReason"] = "insufficientCapacity"
+		event["failureMessage"] = "the cluster has no room for this attempt, start it later"
+		publishEvent(ch, ctx, event, "challengeStartFailed", "challengeStarted")
+	}
+
+	run := &bulkRun{ch: publisher, ctx: ctx, data: data, progressStatus: "challengeStartAllProgress", routingKey: routingKey}
+	run.progress = bulkProgress{Total: len(startable) + len(skipped), Skipped: len(skipped)}
+	background(func() {
+		run.fanOut(startable, config.BULK_CONCURRENCY, func(attempt *models.Attempt) bool {
+			return startAttempt(publisher, withAttempt(ctx, attempt), challenge, *attempt, attemptEvent(attempt, data), "challengeStarted")
+		})
+
+		log.Printf("Started %d of %d attempts of %s", run.progress.Succeeded, run.progress.Total, challenge.ChallengeName)
+		data["progress"] = run.progress
+		publishEvent(publisher, ctx, data, "challengeStartedAll", routingKey)
+	})
+}
+
+// StopAllAttempts stops every running attempt of a challenge, as many at
+// once as BULK_CONCURRENCY allows.
+func StopAllAttempts(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+	data, challenge, ok := bulkChallenge(ch, ctx, msg, "challengeStopAllFailed", routingKey)
+	if !ok {
+		return
+	}
+
+	attempts, err := store.ListAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to list attempts of %s: %s", challenge.ChallengeName, err)
+		publishEvent(ch, ctx, data, "challengeStopAllFailed", routingKey)
+		return
+	}
+	running := []models.Attempt{}
+	for _, attempt := range attempts {
+		if models.CanTransition(models.AttemptStatus(&attempt), models.AttemptStopping) {
+			running = append(running, attempt)
+		}
+	}
+
+	run := &bulkRun{ch: publisher, ctx: ctx, data: data, progressStatus: "challengeStopAllProgress", routingKey: routingKey}
+	run.progress = bulkProgress{Total: len(running)}
+	background(func() {
+		run.fanOut(running, config.BULK_CONCURRENCY, func(attempt *models.Attempt) bool {
+			attemptCtx := withAttempt(ctx, attempt)
+			event := attemptEvent(attempt, data)
+			if err := stopAttempt(attemptCtx, attempt.Token); err != nil {
+				rejectCommand(publisher, attemptCtx, event, err, "challengeStopFailed", "challengeStopped")
+				return false
+			}
+			publishEvent(publisher, attemptCtx, event, "challengeStopped", "challengeStopped")
+			return true
+		})
+
+		log.Printf("Stopped %d of %d attempts of %s", run.progress.Succeeded, run.progress.Total, challenge.ChallengeName)
+		data["progress"] = run.progress
+		publishEvent(publisher, ctx, data, "challengeStoppedAll", routingKey)
+	})
+}
+
+// startCapacity is how many more attempts of the challenge the cluster has
+// room for, counting ready warm pool instances, or -1 if unknown.
+func startCapacity(ctx context.Context, challenge *models.Challenge) int {
+	if kubeClient == nil {
+		return -1
+	}
+
+	// attempts without a profile get the defaults of the smallest tier
+	resources, err := challengeResources(challenge)
+	if err != nil {
+		return -1
+	}
+	if resources == nil {
+		small := deploy.Tiers["small"]
+		resources = &small
+	}
+	requirements, err := resources.Requirements()
+	if err != nil {
+		return -1
+	}
+
+	free, err := deploy.FreeCapacity(ctx, kubeClient)
+	if err != nil {
+		log.Printf("Failed to check cluster capacity: %s", err)
+		return -1
+	}
+	room := deploy.Fits(free, requirements.Requests)
+	if room < 0 {
+		return -1
+	}
+
+	instances, err := store.ListPoolInstances(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to list warm pool of %s: %s", challenge.ChallengeName, err)
+		return room
+	}
+	for _, instance := range instances {
+		if instance.Status == models.PoolReady && instance.Revision == challenge.Revision {
+			room++
+		}
+	}
+	return room
+}
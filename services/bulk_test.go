@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestStopAllAttempts(t *testing.T) {
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake"}
+	startedAt := time.Now().Add(-10 * time.Minute)
+
+	m := &memStore{challenges: []models.Challenge{challenge}}
+	fake := deploy.NewFake()
+	for _, attempt := range []models.Attempt{
+		{Token: "one", Status: models.AttemptRunning, StartedAt: &startedAt},
+		{Token: "two", Status: models.AttemptRunning, StartedAt: &startedAt},
+		{Token: "graded", Status: models.AttemptGraded, StartedAt: &startedAt},
+	} {
+		attempt.CreatorName = challenge.CreatorName
+		attempt.ChallengeName = challenge.ChallengeName
+		m.attempts = append(m.attempts, attempt)
+		if attempt.Status == models.AttemptRunning {
+			deployRelease(t, fake, releaseOf(&attempt))
+		}
+	}
+	useStore(t, m)
+	useDeployer(t, fake)
+	events := useEvents(t)
+
+	msg, _ := json.Marshal(map[string]interface{}{"creatorName": challenge.CreatorName, "challengeName": challenge.ChallengeName})
+	StopAllAttempts(nil, context.Background(), msg, "challengeStopAll")
+	bulkRuns.Wait()
+
+	for _, token := range []string{"one", "two"} {
+		attempt := m.Attempt(token)
+		if attempt.Status != models.AttemptStopped {
+			t.Errorf("attempt %s status = %q, want %q", token, attempt.Status, models.AttemptStopped)
+		}
+		if _, deployed := fake.Spec(releaseOf(&attempt)); deployed {
+			t.Errorf("attempt %s release was not torn down", token)
+		}
+	}
+	if status := m.Attempt("graded").Status; status != models.AttemptGraded {
+		t.Errorf("graded attempt status = %q, want it left alone", status)
+	}
+
+	statuses := events.Statuses()
+	if last := statuses[len(statuses)-1]; last != "challengeStoppedAll" {
+		t.Errorf("events = %v, want challengeStoppedAll last", statuses)
+	}
+	// the fan-out publishes nothing on the consumer's channel
+	if detached := events.PublishedOn(publisher); len(detached) != len(statuses) {
+		t.Errorf("events on the publisher = %v, want all of %v", detached, statuses)
+	}
+}
+
+func TestStopAllAttemptsWithoutBroker(t *testing.T) {
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake"}
+	startedAt := time.Now().Add(-10 * time.Minute)
+	attempt := models.Attempt{
+		Token:         "token",
+		CreatorName:   challenge.CreatorName,
+		ChallengeName: challenge.ChallengeName,
+		Status:        models.AttemptRunning,
+		StartedAt:     &startedAt,
+	}
+
+	m := &memStore{challenges: []models.Challenge{challenge}, attempts: []models.Attempt{attempt}}
+	fake := deploy.NewFake()
+	deployRelease(t, fake, releaseOf(&attempt))
+	useStore(t, m)
+	useDeployer(t, fake)
+	// a publisher without a broker fails every publish
+	usePublisher(t, &Publisher{})
+
+	msg, _ := json.Marshal(map[string]interface{}{"creatorName": challenge.CreatorName, "challengeName": challenge.ChallengeName})
+	StopAllAttempts(nil, context.Background(), msg, "challengeStopAll")
+	bulkRuns.Wait()
+
+	if status := m.Attempt(attempt.Token).Status; status != models.AttemptStopped {
+		t.Errorf("attempt status = %q, want %q", status, models.AttemptStopped)
+	}
+	unpublished := 0
+	for _, entry := range m.audit {
+		if entry.Kind == models.AuditError && strings.Contains(entry.Error, "not published") {
+			unpublished++
+		}
+	}
+	// the attempt's event, the progress and the summary
+	if unpublished != 3 {
+		t.Errorf("audited %d unpublished events, want 3", unpublished)
+	}
+}
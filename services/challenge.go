@@ -3,27 +3,25 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/google/uuid"
-	helmclient "github.com/mittwald/go-helm-client"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"helm.sh/helm/v3/pkg/repo"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	"go.mongodb.org/mongo-driver/mongo"
 	"sys.io/challenge-service/collections"
 	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/deploy"
 	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/registry"
 	"sys.io/challenge-service/utils"
 )
 
+// how long a challenge pod may take to leave Pending
+const startTimeout = 10 * time.Minute
+
 func StartChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
 
 	// Unpack JSON data.
@@ -38,382 +36,435 @@ func StartChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, routingKe
 	var attempt models.Attempt
 	err = json.Unmarshal(msg, &attempt)
 	if err != nil {
-		data["eventStatus"] = "challengeCreateFailed"
 		log.Printf("Failed to decode JSON message body: %s", err)
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return
+	}
 
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
+	challenge, err := store.GetChallenge(attempt.CreatorName, attempt.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to find challenge %s: %s", attempt.ChallengeName, err)
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return
+	}
+	if !challengeStartable(&challenge, data) {
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
 		return
 	}
 
-	//Get repository, tag and release_id
-	url := strings.TrimPrefix(attempt.ImageRegistryLink, "https://")
+	startAttempt(ch, ctx, &challenge, attempt, data, routingKey)
+}
 
-	repository, tag := strings.Split(url, ":")[0], strings.Split(url, ":")[1]
-	release_id := fmt.Sprintf("a%s", attempt.Token)
+// challengeStartable reports whether attempts of the challenge may start
+// now, describing why not on data.
+func challengeStartable(challenge *models.Challenge, data map[string]interface{}) bool {
+	if challenge.ArchivedAt != nil {
+		data["failureReason"] = "challengeArchived"
+		return false
+	}
+	if window := challenge.Window(time.Now()); window != models.WindowOpen {
+		data["failureReason"] = "challengeNotOpen"
+		data["failureMessage"] = fmt.Sprintf("the challenge window is %s", window)
+		data["window"] = window
+		return false
+	}
+	return true
+}
 
-	// Create a Kubernetes client.
-	var kconfig *rest.Config
+// startAttempt brings up an attempt's environment, publishing its progress
+// on data, and reports whether it started.
+func startAttempt(ch eventChannel, ctx context.Context, challenge *models.Challenge, attempt models.Attempt, data map[string]interface{}, routingKey string) bool {
 
-	if config.ENVIRONMENT == "DEV" {
-		kconfig, err = clientcmd.BuildConfigFromFlags("", config.KUBECONFIG)
-		if err != nil {
-			data["eventStatus"] = "challengeCreateFailed"
-			log.Printf("Failed to create Kubernetes client: %s", err)
+	deployer, err := deployerFor(challenge)
+	if err != nil {
+		log.Printf("%s", err)
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return false
+	}
 
-			msgBody, _ := json.Marshal(data)
-			Publish(ch, ctx, msgBody, routingKey)
-			return
+	// start on a pre-started instance if the pool has one, deploy otherwise
+	directRelease := deploy.Release{
+		Name:      attemptRelease(attempt.Token),
+		Namespace: releaseNamespace(challenge, attemptRelease(attempt.Token)),
+	}
+	release := directRelease
+	instance, claimed := takePoolInstance(deployer, challenge)
+	if claimed {
+		release = deploy.Release{Name: instance.Release, Namespace: instance.Namespace}
+	}
+	// keep the reconciler off the release until the attempt is running on it
+	defer markStarting(release)()
+
+	// only created attempts and failed ones being retried can start, the
+	// attempt names its release from here on for anyone abandoning it
+	if _, err := beginAttempt(ctx, attempt.Token, release); err != nil {
+		if claimed {
+			returnPoolInstance(instance)
 		}
-	} else {
-		kconfig, err = rest.InClusterConfig()
-		if err != nil {
-			data["eventStatus"] = "challengeCreateFailed"
-			log.Printf("Failed to create Kubernetes client: %s", err)
-
-			msgBody, _ := json.Marshal(data)
-			Publish(ch, ctx, msgBody, routingKey)
-			return
+		rejectCommand(ch, ctx, data, err, "challengeStartFailed", routingKey)
+		return false
+	}
+	// every failure below leaves the attempt failed
+	started := false
+	defer func() {
+		if !started {
+			transitionAttempt(ctx, attempt.Token, models.AttemptFailed)
 		}
+	}()
+
+	// top the pool up again, or shrink it now one participant less waits
+	if keepsPool(challenge) {
+		defer FillPool(*challenge)
 	}
 
 	// generate ssh keys and convert them into strings
 	pubKey, privKey, err := utils.MakeSSHKeyPair()
 	if err != nil {
 		log.Printf("%s", err)
-		data["eventStatus"] = "challengeStartFailed"
-		log.Printf("Challenge %s start failed ...", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
-		return
+		log.Printf("Challenge %s start failed ...", attempt.Token)
+		if claimed {
+			discardPoolInstance(deployer, instance)
+		}
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return false
 	}
-	log.Printf("pub: %s, priv: %s", pubKey, privKey)
-
-	// create a helm client
-	var namespace = "challenge"
 
-	var helmClient helmclient.Client
+	// an instance that could not be handed over is gone, deploy instead
+	if claimed && !handOverPoolInstance(ctx, deployer, instance, attempt.Token, pubKey) {
+		claimed = false
+		release = directRelease
+		defer markStarting(release)()
+		if err := store.SetAttemptRelease(attempt.Token, release.Name, release.Namespace); err != nil {
+			log.Printf("Challenge %s no longer starting: %s", release.Name, err)
+			publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+			return false
+		}
+	}
 
-	if config.ENVIRONMENT == "DEV" {
+	if !claimed {
 
-		kubeConfig, err := os.ReadFile(config.KUBECONFIG)
+		spec, err := challengeSpec(challenge, release)
 		if err != nil {
-			log.Printf("Failed to read file from kube/config: %v\n", err)
+			log.Printf("%s", err)
+			log.Printf("Challenge %s start failed ...", release.Name)
+			publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+			return false
 		}
+		spec.AuthorizedKeys = pubKey
+		spec.Env = attemptEnv(attempt.Token)
 
-		helmClient, err = helmclient.NewClientFromKubeConf(
-			&helmclient.KubeConfClientOptions{
-				Options: &helmclient.Options{
-					Namespace:        namespace,
-					RepositoryCache:  "/tmp/.helmcache",
-					RepositoryConfig: "/tmp/.helmrepo",
-					Debug:            true,
-					Linting:          true,
-					Output:           nil,
-				},
-				KubeConfig:  kubeConfig,
-				KubeContext: "",
-			},
-		)
+		// deploy the challenge
+		err = deployer.Deploy(ctx, spec)
 		if err != nil {
-			log.Printf("Failed to create HelmClient: %v\n", err)
-			data["eventStatus"] = "challengeStartFailed"
-			log.Printf("Challenge %s start failed ...", release_id)
-
-			msgBody, _ := json.Marshal(data)
-			Publish(ch, ctx, msgBody, routingKey)
-			return
-		}
-	} else {
-		opt := &helmclient.RestConfClientOptions{
-			Options: &helmclient.Options{
-				Namespace:        namespace, // Change this to the namespace you wish the client to operate in.
-				RepositoryCache:  "/tmp/.helmcache",
-				RepositoryConfig: "/tmp/.helmrepo",
-				Debug:            true,
-				Linting:          true, // Change this to false if you don't want linting.
-				DebugLog: func(format string, v ...interface{}) {
-					// Change this to your own logger. Default is 'log.Printf(format, v...)'.
-				},
-			},
-			RestConfig: kconfig,
+			log.Printf("Failed to deploy challenge: %s", err)
+			log.Printf("Challenge %s start failed ...", release.Name)
+			publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+			return false
 		}
+	}
 
-		helmClient, err = helmclient.NewClientFromRestConf(opt)
-		if err != nil {
-			log.Printf("Failed to create HelmClient: %v\n", err)
-			data["eventStatus"] = "challengeStartFailed"
-			log.Printf("Challenge %s start failed ...", release_id)
+	log.Printf("Challenge %s deployed!", release.Name)
 
-			msgBody, _ := json.Marshal(data)
-			Publish(ch, ctx, msgBody, routingKey)
-			return
-		}
+	// check pod status every 5 seconds
+	waitCtx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+
+	status, err := deploy.WaitReady(waitCtx, deployer, release, 5*time.Second, func() {
+		log.Printf("Challenge %s is starting ... ", release.Name)
+		publishEvent(ch, ctx, data, "challengeStarting", routingKey)
+	})
+	if err != nil || status != deploy.StatusRunning {
+		log.Printf("Challenge %s did not start (%s): %v", release.Name, status, err)
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return false
+	}
+
+	// get the address participants connect to
+	endpoint, err := deploy.WaitEndpoint(waitCtx, deployer, release, 5*time.Second)
+	if err != nil {
+		log.Printf("%s", err)
+		log.Printf("Challenge %s start failed ...", release.Name)
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return false
 	}
 
-	log.Print("helmClient Configured !!")
+	log.Printf("Challenge %s reachable on %s:%d\n", release.Name, endpoint.Host, endpoint.Port)
 
-	// create a repo reference
-	repo := repo.Entry{
-		Name:               config.HELM_REPO_NAME,
-		URL:                config.HELM_REPO_URL,
-		Username:           config.HELM_REPO_USERNAME,
-		Password:           config.HELM_REPO_PASSWORD,
-		PassCredentialsAll: true,
+	// the window may have closed or the challenge been archived meanwhile
+	if current, err := store.GetChallenge(challenge.CreatorName, challenge.ChallengeName); err == nil && !challengeStartable(&current, data) {
+		log.Printf("Challenge %s is no longer open, removing %s", challenge.ChallengeName, release.Name)
+		if err := deployer.Teardown(ctx, release); err != nil {
+			log.Printf("Failed to remove challenge %s: %s", release.Name, err)
+		}
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return false
 	}
 
-	// add the chart repo reference
-	err = helmClient.AddOrUpdateChartRepo(repo)
+	// Update attempt
+	attempt.Ipaddress = endpoint.Host
+	attempt.Port = strconv.FormatInt(int64(endpoint.Port), 10)
+	attempt.Sshkey = privKey
+	attempt.Namespace = release.Namespace
+	attempt.ReleaseName = release.Name
+	startedAt := time.Now()
+	attempt.StartedAt = &startedAt
+	// a retried attempt starts over
+	attempt.EndedAt = nil
+	attempt.SubmittedAt = nil
+
+	_, err = store.UpdateAttempt(&attempt)
 	if err != nil {
-		log.Printf("Failed to add or update HelmChartRepo: %v\n", err)
-		data["eventStatus"] = "challengeStartFailed"
-		log.Printf("Challenge %s start failed ...", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
-		return
+		log.Printf("%s", err)
+		log.Printf("Challenge %s start failed ...", release.Name)
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return false
 	}
 
-	log.Print("Helm Repository Added!!")
-
-	// specify the challenge chart
-	chartSpec := helmclient.ChartSpec{
-		ReleaseName:     release_id,
-		ChartName:       fmt.Sprintf("%s/%s", config.HELM_REPO_NAME, config.HELM_CHART_NAME),
-		Namespace:       namespace,
-		CreateNamespace: true,
-		GenerateName:    true,
-		ValuesYaml: fmt.Sprintf(`
-image:
-  registry: registry.gitlab.com
-  repository: %s
-  pullPolicy: Always
-  tag: %s
-imagePullSecrets:
-  - name: docker-registry-credentials
-authorized_keys: %s
-env:
-  - name: PLATFORM_PASSWORD
-    value: %s
-  - name: PLATFORM_USERNAME
-    value: %s
-  - name: ATTEMPT_TOKEN
-    value: %s`, repository, tag, pubKey, config.PLATFORM_PASSWORD, config.PLATFORM_USERNAME, attempt.Token),
-	}
-
-	// install or upgrade a chart release
-	if _, err := helmClient.InstallOrUpgradeChart(context.Background(), &chartSpec, nil); err != nil {
-		log.Printf("Failed to install or upgradeChart client: %v\n", err)
-		data["eventStatus"] = "challengeStartFailed"
-		log.Printf("Challenge %s start failed ...", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
-		return
+	// Successfully started, unless the attempt was abandoned meanwhile
+	if _, err := moveAttempt(ctx, attempt.Token, models.AttemptRunning); err != nil {
+		log.Printf("Challenge %s no longer starting: %s", release.Name, err)
+		if err := deployer.Teardown(ctx, release); err != nil {
+			log.Printf("Failed to remove abandoned challenge %s: %s", release.Name, err)
+		}
+		if err := store.EndAttempt(attempt.Token, time.Now()); err != nil {
+			log.Printf("Failed to end attempt %s: %s", attempt.Token, err)
+		}
+		publishEvent(ch, ctx, data, "challengeStartFailed", routingKey)
+		return false
 	}
+	started = true
+	log.Printf("Challenge %s started ...", release.Name)
+	publishEvent(ch, ctx, data, "challengeStarted", routingKey)
+	return true
+}
 
-	log.Print("Helm installed or upgraded challenge!!")
+func CreateChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
 
-	//Configure kubenetes client
-	client, err := kubernetes.NewForConfig(kconfig)
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create Kubernetes client: %v\n", err)
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
 		return
 	}
 
-	log.Print("Kubernetes Configured !!")
+	// never echo the registry password back onto the exchange
+	delete(data, "registryCredentials")
 
-	time.Sleep(2 * time.Second)
-
-	// check pod status
-	// Get the pod list for the deployment.
-	podList, err := client.CoreV1().Pods(namespace).List(context.Background(), v1.ListOptions{
-		LabelSelector: fmt.Sprintf("app.kubernetes.io/instance=%s", release_id),
-	})
+	var challenge models.Challenge
+	err = json.Unmarshal(msg, &challenge)
 	if err != nil {
-		fmt.Println(err)
+		log.Printf("Failed to decode JSON message body: %s", err)
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
 		return
 	}
 
-	pod := podList.Items[0]
-
-	// Check status every 5 seconds
-	for pod.Status.Phase == "Pending" {
-		data["eventStatus"] = "challengeStarting"
-		log.Printf("Challenge %s is starting ... ", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
+	// check the requested deployer exists
+	deployer, err := deployerFor(&challenge)
+	if err != nil {
+		log.Printf("%s", err)
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
+		return
+	}
 
-		time.Sleep(5 * time.Second)
+	// check what submitting does
+	switch challenge.OnSubmit {
+	case "", models.SubmitFreeze, models.SubmitTeardown:
+	default:
+		data["failureReason"] = "invalidOnSubmit"
+		data["failureMessage"] = fmt.Sprintf("onSubmit must be %s or %s", models.SubmitFreeze, models.SubmitTeardown)
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
+		return
+	}
 
-		podList, err := client.CoreV1().Pods(namespace).List(context.Background(), v1.ListOptions{
-			LabelSelector: fmt.Sprintf("app.kubernetes.io/instance=%s", release_id),
-		})
-		if err != nil {
-			fmt.Println(err)
+	// check the grader says how to grade
+	if challenge.Grader != nil {
+		if err := challenge.Grader.Validate(); err != nil {
+			data["failureReason"] = "invalidGrader"
+			data["failureMessage"] = err.Error()
+			publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
 			return
 		}
-		pod = podList.Items[0]
 	}
 
-	if pod.Status.Phase != "Running" {
-		data["eventStatus"] = "challengeStartFailed"
-		log.Printf("Challenge %s start failed ...", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
+	// check the window's bounds
+	if err := challenge.ValidateWindow(); err != nil {
+		log.Printf("Invalid window: %s", err)
+		data["failureReason"] = "invalidWindow"
+		data["failureMessage"] = err.Error()
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
 		return
 	}
 
-	// get pod IP and port functions
-	// Get the external IP address of the first node.
-	nodeList, err := client.CoreV1().Nodes().List(context.Background(), v1.ListOptions{})
-	if err != nil {
-		log.Printf("%s", err)
-		data["eventStatus"] = "challengeStartFailed"
-		log.Printf("Challenge %s start failed ...", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
+	// check the warm pool size
+	if err := validateWarmPool(&challenge, deployer, config.WARM_POOL_MAX); err != nil {
+		log.Printf("Invalid warm pool: %s", err)
+		data["failureReason"] = "invalidWarmPool"
+		data["failureMessage"] = err.Error()
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
 		return
 	}
 
-	var publicIPAddress string
-	Address := nodeList.Items[0].Status.Addresses
-	for _, address := range Address {
-		if address.Type == "ExternalIP" {
-			log.Printf("Node ExternalIP: %s\n", address.Address)
-			publicIPAddress = address.Address
-			break
-		}
+	// check the resource profile fits within the configured maxima
+	if _, err := challengeResources(&challenge); err != nil {
+		log.Printf("Invalid resource profile: %s", err)
+		data["failureReason"] = "invalidResources"
+		data["failureMessage"] = err.Error()
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
+		return
 	}
 
-	if publicIPAddress == "" {
-		publicIPAddress = nodeList.Items[0].Status.Addresses[0].Address
-		log.Printf("No external IP, Node InternalIP: %s\n", publicIPAddress)
+	// check the egress settings
+	if err := deploy.ValidateEgress(challengeEgress(&challenge)); err != nil {
+		log.Printf("Invalid egress policy: %s", err)
+		data["failureReason"] = "invalidEgress"
+		data["failureMessage"] = err.Error()
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
+		return
 	}
 
-	// Get the NodePort port number for the `my-service` Service.
-	service, err := client.CoreV1().Services(namespace).Get(context.Background(), fmt.Sprintf("%s-challenge", release_id), v1.GetOptions{
-		TypeMeta: v1.TypeMeta{
-			Kind:       "",
-			APIVersion: "",
-		},
-		ResourceVersion: "",
-	})
-	if err != nil {
-		log.Printf("%s", err)
-		data["eventStatus"] = "challengeStartFailed"
-		log.Printf("Challenge %s start failed ...", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
+	if err := pinChallengeImage(ctx, &challenge, data); err != nil {
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
 		return
 	}
+	data["imageDigest"] = challenge.ImageDigest
 
-	nodePort := service.Spec.Ports[0].NodePort
-
-	// Print the NodePort port number.
-	fmt.Printf("NodePort port number for the `my-service` Service on the public IP address of the node exposing the pod: %s:%d\n", publicIPAddress, nodePort)
-
-	// Update attempt
-	attempt.Ipaddress = publicIPAddress
-	attempt.Port = strconv.FormatInt(int64(nodePort), 10)
-	attempt.Sshkey = privKey
+	// Create challenge
 
-	_, err = collections.UpdateAttempt(&attempt)
+	// the challenge's own credentials are kept in a secret, not with it
+	if challenge.RegistryCredentials != nil {
+		challenge.RegistrySecret = registrySecretName(&challenge)
+	}
+	_, err = store.CreateChallenge(&challenge)
 	if err != nil {
-		log.Printf("%s", err)
-		data["eventStatus"] = "challengeStartFailed"
-		log.Printf("Challenge %s start failed ...", release_id)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
+		log.Printf("Failed to create challenge: %s", err)
+		if mongo.IsDuplicateKeyError(err) {
+			data["failureReason"] = "challengeExists"
+			data["failureMessage"] = "a challenge of that name exists, archived ones included"
+		}
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
 		return
 	}
-
-	// Successfully started
-	data["eventStatus"] = "challengeStarted"
-	log.Printf("Challenge %s started ...", release_id)
-
-	msgBody, _ := json.Marshal(data)
-	Publish(ch, ctx, msgBody, routingKey)
-
-}
-
-func CreateChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
-
-	// Unpack JSON data.
-	var data map[string]interface{}
-	err := json.Unmarshal(msg, &data)
-	if err != nil {
-		log.Printf("Failed to decode JSON message body: %s", err)
-		utils.FailOnError(err, "Failed to decode JSON message body")
+	// saved once the name is ours, the secret of a challenge of the same
+	// name must not be overwritten
+	if err := saveRegistryCredentials(ctx, &challenge); err != nil {
+		log.Printf("Failed to save registry credentials: %s", err)
+		if err := store.DeleteChallenge(challenge.CreatorName, challenge.ChallengeName); err != nil {
+			log.Printf("Failed to delete challenge %s: %s", challenge.ChallengeName, err)
+		}
+		publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
 		return
 	}
 
-	var challenge models.Challenge
-	err = json.Unmarshal(msg, &challenge)
-	if err != nil {
-		data["eventStatus"] = "challengeCreateFailed"
-		log.Printf("Failed to decode JSON message body: %s", err)
+	// Create attempts
+	for _, v := range challenge.Participants {
 
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
-		return
+		_, err = store.CreateAttempt(newAttempt(&challenge, v))
+		if err != nil {
+			log.Printf("Failed to create attempt for %s: %s", v, err)
+			publishEvent(ch, ctx, data, "challengeCreateFailed", routingKey)
+			return
+		}
+	}
+
+	// pre-start instances so the first attempts start without waiting
+	if keepsPool(&challenge) {
+		FillPool(challenge)
 	}
 
+	publishEvent(ch, ctx, data, "challengeCreated", routingKey)
+}
+
+// pinChallengeImage looks up the challenge's image tag and pins it to a
+// digest so every attempt runs the same image. Failures the creator can fix
+// are described on data.
+func pinChallengeImage(ctx context.Context, challenge *models.Challenge, data map[string]interface{}) error {
 	//find image
-	image, err := collections.GetImage(challenge.CreatorName, challenge.ImageName, challenge.ImageTag)
+	image, err := store.GetImage(challenge.CreatorName, challenge.ImageName, challenge.ImageTag)
 	if err != nil {
-		data["eventStatus"] = "challengeCreateFailed"
 		log.Printf("Failed to Find image: %s", err)
-
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
-		return
+		switch {
+		case errors.Is(err, collections.ErrImageNotFound):
+			data["failureReason"] = "imageNotFound"
+		case errors.Is(err, collections.ErrImageTagNotFound):
+			data["failureReason"] = "imageTagNotFound"
+			data["availableTags"] = availableTags(challenge.CreatorName, challenge.ImageName)
+		}
+		return err
 	}
 	challenge.ImageRegistryLink = image.ImageRegistryLink
 
-	// Create challenge
-
-	_, err = collections.CreateChallenge(&challenge)
+	imageRef, err := utils.ParseImageReference(image.ImageRegistryLink)
 	if err != nil {
-		data["eventStatus"] = "challengeCreateFailed"
-		log.Printf("Failed to create challenge: %s", err)
+		log.Printf("Failed to parse image %s: %s", image.ImageRegistryLink, err)
+		return err
+	}
 
-		msgBody, _ := json.Marshal(data)
-		Publish(ch, ctx, msgBody, routingKey)
-		return
+	creds := registryCredentials(ctx, challenge, imageRef.Registry)
+	challenge.ImageDigest, err = registry.NewClient(config.REGISTRY_INSECURE_HOSTS).ResolveDigest(ctx, imageRef, creds)
+	if err != nil {
+		log.Printf("Failed to resolve image digest: %s", err)
+		return err
 	}
+	return nil
+}
 
-	// Create attempts
-	for _, v := range challenge.Participants {
+// registryCredentials are what the challenge's image is looked up with: the
+// challenge's own credentials or else those of the shared pull secret its
+// pods would pull with, nil if neither has any.
+func registryCredentials(ctx context.Context, challenge *models.Challenge, server string) *registry.Credentials {
+	own, err := challengeCredentials(ctx, challenge, server)
+	if err != nil {
+		log.Printf("Failed to read registry credentials of challenge %s: %s", challenge.ChallengeName, err)
+		return nil
+	}
+	if own != nil {
+		return &registry.Credentials{Username: own.Username, Password: own.Password}
+	}
+	if kubeClient == nil {
+		return nil
+	}
 
-		_, err = collections.CreateAttempt(&models.Attempt{
-			Participant:       v,
-			Token:             uuid.NewString(),
-			Sshkey:            "",
-			Result:            0,
-			Ipaddress:         "",
-			Port:              "",
-			ChallengeName:     challenge.ChallengeName,
-			CreatorName:       challenge.CreatorName,
-			ImageRegistryLink: challenge.ImageRegistryLink,
-		})
-		if err != nil {
-			data["eventStatus"] = "challengeCreateFailed"
-			log.Printf("Failed to create attempt for %s: %s", v, err)
+	shared, err := deploy.SharedPullCredentials(ctx, kubeClient, challengeNamespace, server)
+	if err != nil {
+		log.Printf("Failed to read shared registry credentials: %s", err)
+		return nil
+	}
+	if shared == nil {
+		return nil
+	}
+	return &registry.Credentials{Username: shared.Username, Password: shared.Password}
+}
 
-			msgBody, _ := json.Marshal(data)
-			Publish(ch, ctx, msgBody, routingKey)
-			return
-		}
+// challengeCredentials returns the challenge's own credentials for server,
+// from the command while it is created and from their secret after, nil if
+// it has none.
+func challengeCredentials(ctx context.Context, challenge *models.Challenge, server string) (*deploy.RegistryCredentials, error) {
+	if creds := challenge.RegistryCredentials; creds != nil {
+		return &deploy.RegistryCredentials{Server: server, Username: creds.Username, Password: creds.Password}, nil
+	}
+	if challenge.RegistrySecret == "" {
+		return nil, nil
+	}
+	if kubeClient == nil {
+		return nil, fmt.Errorf("no cluster to read secret %s from", challenge.RegistrySecret)
 	}
+	return deploy.LoadRegistryCredentials(ctx, kubeClient, challengeNamespace, challenge.RegistrySecret, server)
+}
 
-	data["eventStatus"] = "challengeCreated"
-	msgBody, _ := json.Marshal(data)
-	Publish(ch, ctx, msgBody, routingKey)
+// saveRegistryCredentials keeps the challenge's own credentials in the
+// secret it names.
+func saveRegistryCredentials(ctx context.Context, challenge *models.Challenge) error {
+	creds := challenge.RegistryCredentials
+	if creds == nil {
+		return nil
+	}
+	if kubeClient == nil {
+		return fmt.Errorf("no cluster to keep registry credentials in")
+	}
+	return deploy.SaveRegistryCredentials(ctx, kubeClient, challengeNamespace, challenge.RegistrySecret, &deploy.RegistryCredentials{
+		Username: creds.Username,
+		Password: creds.Password,
+	})
 }
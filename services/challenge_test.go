@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/registry"
+)
+
+func TestStartAttemptRetry(t *testing.T) {
+	challenge := models.Challenge{
+		CreatorName:       "creator",
+		ChallengeName:     "ctf",
+		Deployer:          "fake",
+		Duration:          60,
+		ImageRegistryLink: "registry.example/ctf:1",
+	}
+	earlier := time.Now().Add(-2 * time.Hour)
+
+	tests := []struct {
+		name    string
+		attempt models.Attempt
+	}{
+		{
+			name:    "failed while running",
+			attempt: models.Attempt{Token: "failed", Status: models.AttemptFailed, StartedAt: &earlier, EndedAt: &earlier},
+		},
+		{
+			name:    "failed after submitting",
+			attempt: models.Attempt{Token: "submitted", Status: models.AttemptFailed, StartedAt: &earlier, SubmittedAt: &earlier, EndedAt: &earlier},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := tt.attempt
+			attempt.CreatorName = challenge.CreatorName
+			attempt.ChallengeName = challenge.ChallengeName
+			m := &memStore{challenges: []models.Challenge{challenge}, attempts: []models.Attempt{attempt}}
+			useStore(t, m)
+			useDeployer(t, deploy.NewFake())
+			events := useEvents(t)
+
+			data := map[string]interface{}{"token": attempt.Token}
+			if !startAttempt(nil, context.Background(), &challenge, attempt, data, "challengeStarted") {
+				t.Fatalf("startAttempt() failed, events %v", events.Statuses())
+			}
+
+			started := m.Attempt(attempt.Token)
+			if started.Status != models.AttemptRunning {
+				t.Errorf("status = %q, want %q", started.Status, models.AttemptRunning)
+			}
+			if started.EndedAt != nil || started.SubmittedAt != nil {
+				t.Errorf("ended %v, submitted %v, want the earlier run cleared", started.EndedAt, started.SubmittedAt)
+			}
+			if attemptEnded(&started, &challenge, time.Now()) {
+				t.Error("restarted attempt counts as ended")
+			}
+		})
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	kube := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "docker-registry-credentials", Namespace: challengeNamespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(
+			`{"auths": {"registry.gitlab.com": {"username": "deploy", "password": "shared"}}}`,
+		)},
+	})
+	useKube(t, kube)
+	err := deploy.SaveRegistryCredentials(context.Background(), kube, challengeNamespace, "registry-ctf", &deploy.RegistryCredentials{Username: "creator", Password: "kept"})
+	if err != nil {
+		t.Fatalf("SaveRegistryCredentials() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		challenge models.Challenge
+		server    string
+		want      *registry.Credentials
+	}{
+		{
+			name:      "own credentials",
+			challenge: models.Challenge{RegistryCredentials: &models.RegistryCredentials{Username: "creator", Password: "own"}},
+			server:    "registry.gitlab.com",
+			want:      &registry.Credentials{Username: "creator", Password: "own"},
+		},
+		{
+			name:      "credentials kept in a secret",
+			challenge: models.Challenge{RegistrySecret: "registry-ctf"},
+			server:    "registry.gitlab.com",
+			want:      &registry.Credentials{Username: "creator", Password: "kept"},
+		},
+		{
+			name:   "shared pull secret",
+			server: "registry.gitlab.com",
+			want:   &registry.Credentials{Username: "deploy", Password: "shared"},
+		},
+		{
+			name:   "registry without credentials",
+			server: "docker.io",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := registryCredentials(context.Background(), &tt.challenge, tt.server)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("registryCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryCredentialsNotStored(t *testing.T) {
+	challenge := models.Challenge{
+		ChallengeName:       "ctf",
+		RegistryCredentials: &models.RegistryCredentials{Username: "creator", Password: "hunter2"},
+		RegistrySecret:      "registry-ctf",
+	}
+	doc, err := bson.Marshal(&challenge)
+	if err != nil {
+		t.Fatalf("bson.Marshal() error = %v", err)
+	}
+	if bytes.Contains(doc, []byte("hunter2")) {
+		t.Error("the stored challenge holds the registry password")
+	}
+	var stored models.Challenge
+	if err := bson.Unmarshal(doc, &stored); err != nil {
+		t.Fatalf("bson.Unmarshal() error = %v", err)
+	}
+	if stored.RegistrySecret != "registry-ctf" {
+		t.Errorf("RegistrySecret = %q, want registry-ctf", stored.RegistrySecret)
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "registry credentials",
+			body: `{"challengeName":"ctf","registryCredentials":{"username":"creator","password":"hunter2"}}`,
+			want: `{"challengeName":"ctf","registryCredentials":"(redacted)"}`,
+		},
+		{
+			name: "no credentials",
+			body: `{"token":"abc"}`,
+			want: `{"token":"abc"}`,
+		},
+		{
+			name: "not JSON",
+			body: `{"registryCredentials":{"password":"hunter2"`,
+			want: `(44 bytes, not JSON)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactCommand([]byte(tt.body))); got != tt.want {
+				t.Errorf("redactCommand() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartAttemptWindowClosed(t *testing.T) {
+	challenge := models.Challenge{
+		CreatorName:       "creator",
+		ChallengeName:     "ctf",
+		Deployer:          "fake",
+		ImageRegistryLink: "registry.example/ctf:1",
+	}
+	// the window closes while the attempt deploys
+	closed := challenge
+	endsAt := time.Now().Add(-time.Minute)
+	closed.EndsAt = &endsAt
+
+	attempt := models.Attempt{Token: "late", CreatorName: challenge.CreatorName, ChallengeName: challenge.ChallengeName}
+	m := &memStore{challenges: []models.Challenge{closed}, attempts: []models.Attempt{attempt}}
+	useStore(t, m)
+	fake := deploy.NewFake()
+	useDeployer(t, fake)
+	useEvents(t)
+
+	data := map[string]interface{}{"token": attempt.Token}
+	if startAttempt(nil, context.Background(), &challenge, attempt, data, "challengeStarted") {
+		t.Fatal("startAttempt() started an attempt after the window closed")
+	}
+	if data["failureReason"] != "challengeNotOpen" {
+		t.Errorf("failureReason = %v, want challengeNotOpen", data["failureReason"])
+	}
+	if status := m.Attempt(attempt.Token).Status; status != models.AttemptFailed {
+		t.Errorf("status = %q, want %q", status, models.AttemptFailed)
+	}
+	if _, deployed := fake.Spec(releaseOf(&attempt)); deployed {
+		t.Error("release left deployed")
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// deleteSummary lists what deleting a challenge cleaned up.
+type deleteSummary struct {
+	Archived        bool     `json:"archived"`
+	StoppedAttempts []string `json:"stoppedAttempts"`
+	// AbandonedAttempts were starting or submitted, their environments are
+	// removed and they are left failed, or expired when archiving.
+	AbandonedAttempts []string `json:"abandonedAttempts,omitempty"`
+	ExpiredAttempts   []string `json:"expiredAttempts,omitempty"`
+	DeletedAttempts   int64    `json:"deletedAttempts"`
+	DeletedRecordings int64    `json:"deletedRecordings"`
+	PoolInstances     []string `json:"poolInstances"`
+	Namespaces        []string `json:"namespaces"`
+	// Failures are cleanups that did not work, releases left behind are
+	// uninstalled by the reconciler.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// DeleteChallenge stops every attempt of a challenge, removes its warm pool
+// and namespace and deletes it with its attempts. With archive set the
+// challenge and attempts are kept, marked over; an archived challenge keeps
+// its name taken until it is deleted for good. A challenge whose cleanup
+// failed is archived but not deleted, so the command can be retried.
+func DeleteChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	creatorName, _ := data["creatorName"].(string)
+	challengeName, _ := data["challengeName"].(string)
+	archive, _ := data["archive"].(bool)
+
+	challenge, err := store.GetChallenge(creatorName, challengeName)
+	// archived challenges can still be deleted for good
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && archive && challenge.ArchivedAt != nil) {
+		data["failureReason"] = "challengeNotFound"
+		publishEvent(ch, ctx, data, "challengeDeleteFailed", routingKey)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to find challenge %s: %s", challengeName, err)
+		publishEvent(ch, ctx, data, "challengeDeleteFailed", routingKey)
+		return
+	}
+	deployer, err := deployerFor(&challenge)
+	if err != nil {
+		log.Printf("%s", err)
+		publishEvent(ch, ctx, data, "challengeDeleteFailed", routingKey)
+		return
+	}
+
+	// archiving first keeps attempts from starting and the pool from
+	// filling while the cleanup runs
+	if challenge.ArchivedAt == nil {
+		if err := store.ArchiveChallenge(creatorName, challengeName, time.Now()); err != nil {
+			log.Printf("Failed to archive challenge %s: %s", challengeName, err)
+			publishEvent(ch, ctx, data, "challengeDeleteFailed", routingKey)
+			return
+		}
+	}
+
+	summary := cleanUpChallenge(ctx, deployer, &challenge, archive)
+
+	if !archive {
+		// deleting the attempts would hide environments left running
+		if len(summary.Failures) > 0 {
+			log.Printf("Not deleting challenge %s, its cleanup failed", challengeName)
+			data["failureReason"] = "cleanupFailed"
+			data["cleanup"] = summary
+			publishEvent(ch, ctx, data, "challengeDeleteFailed", routingKey)
+			return
+		}
+		if err := purgeChallenge(&challenge, &summary); err != nil {
+			log.Printf("Failed to delete challenge %s: %s", challengeName, err)
+			data["cleanup"] = summary
+			publishEvent(ch, ctx, data, "challengeDeleteFailed", routingKey)
+			return
+		}
+	}
+
+	audit(ctx, models.AuditEntry{Kind: models.AuditAction, Action: "delete challenge"})
+	log.Printf("Challenge %s deleted, archived: %v", challengeName, archive)
+	data["cleanup"] = summary
+	publishEvent(ch, ctx, data, "challengeDeleted", routingKey)
+}
+
+// cleanUpChallenge removes everything a challenge runs on the cluster:
+// attempt releases, warm pool instances, its registry credentials and its
+// isolated namespace. Running attempts are stopped, starting and submitted
+// ones abandoned. When archiving, attempts that never ran are expired.
+func cleanUpChallenge(ctx context.Context, deployer deploy.Deployer, challenge *models.Challenge, archive bool) deleteSummary {
+	summary := deleteSummary{
+		Archived:        archive,
+		StoppedAttempts: []string{},
+		PoolInstances:   []string{},
+		Namespaces:      []string{},
+	}
+	fail := func(format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+		log.Printf("Deleting challenge %s: %s", challenge.ChallengeName, message)
+		summary.Failures = append(summary.Failures, message)
+	}
+
+	attempts, err := store.ListAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		fail("listing attempts: %s", err)
+	}
+	for i := range attempts {
+		attempt := &attempts[i]
+		status := models.AttemptStatus(attempt)
+		switch {
+		case status == models.AttemptStarting || status == models.AttemptSubmitted:
+			if err := abandonAttempt(withAttempt(ctx, attempt), attempt); err != nil {
+				fail("abandoning attempt %s: %s", attempt.Token, err)
+				continue
+			}
+			summary.AbandonedAttempts = append(summary.AbandonedAttempts, attempt.Token)
+			if archive {
+				if _, err := moveAttempt(ctx, attempt.Token, models.AttemptExpired); err != nil {
+					fail("expiring attempt %s: %s", attempt.Token, err)
+					continue
+				}
+				summary.ExpiredAttempts = append(summary.ExpiredAttempts, attempt.Token)
+			}
+		case models.AttemptActive(status):
+			if err := stopAttempt(withAttempt(ctx, attempt), attempt.Token); err != nil {
+				fail("stopping attempt %s: %s", attempt.Token, err)
+				continue
+			}
+			summary.StoppedAttempts = append(summary.StoppedAttempts, attempt.Token)
+		case archive && models.CanTransition(status, models.AttemptExpired):
+			if _, err := moveAttempt(ctx, attempt.Token, models.AttemptExpired); err != nil {
+				fail("expiring attempt %s: %s", attempt.Token, err)
+				continue
+			}
+			summary.ExpiredAttempts = append(summary.ExpiredAttempts, attempt.Token)
+		}
+	}
+
+	instances, err := store.ListPoolInstances(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		fail("listing warm pool: %s", err)
+	}
+	for _, instance := range instances {
+		discardPoolInstance(deployer, instance)
+		summary.PoolInstances = append(summary.PoolInstances, instance.Release)
+	}
+
+	if challenge.RegistrySecret != "" && kubeClient != nil {
+		if err := deploy.DeleteRegistryCredentials(ctx, kubeClient, challengeNamespace, challenge.RegistrySecret); err != nil {
+			fail("deleting registry credentials: %s", err)
+		}
+	}
+
+	// attempt namespaces go with their release, a challenge's own once all
+	// of them are gone
+	if config.ISOLATION_MODE == deploy.IsolationChallenge && kubeClient != nil {
+		namespace := releaseNamespace(challenge, "")
+		if err := deploy.DeleteNamespace(ctx, kubeClient, namespace); err != nil {
+			fail("deleting namespace %s: %s", namespace, err)
+		} else {
+			summary.Namespaces = append(summary.Namespaces, namespace)
+		}
+	}
+
+	return summary
+}
+
+// abandonAttempt fails a starting or submitted attempt and removes its
+// environment. A start still in progress finds the attempt failed and
+// removes what it deployed itself.
+func abandonAttempt(ctx context.Context, attempt *models.Attempt) error {
+	if _, err := moveAttempt(ctx, attempt.Token, models.AttemptFailed); err != nil {
+		return err
+	}
+	if err := teardownAttempt(ctx, attempt); err != nil {
+		return err
+	}
+	if err := store.EndAttempt(attempt.Token, time.Now()); err != nil {
+		log.Printf("Failed to end attempt %s: %s", attempt.Token, err)
+	}
+	return nil
+}
+
+// purgeChallenge deletes a challenge with its attempts and their
+// recordings. The audit log keeps their history.
+func purgeChallenge(challenge *models.Challenge, summary *deleteSummary) error {
+	attempts, err := store.ListAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		return err
+	}
+	tokens := []string{}
+	for _, attempt := range attempts {
+		tokens = append(tokens, attempt.Token)
+	}
+
+	if summary.DeletedRecordings, err = store.DeleteRecordings(tokens); err != nil {
+		return err
+	}
+	if summary.DeletedAttempts, err = store.DeleteAttempts(challenge.CreatorName, challenge.ChallengeName); err != nil {
+		return err
+	}
+	return store.DeleteChallenge(challenge.CreatorName, challenge.ChallengeName)
+}
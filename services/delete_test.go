@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestCleanUpChallenge(t *testing.T) {
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake"}
+	startedAt := time.Now().Add(-10 * time.Minute)
+
+	tests := []struct {
+		name    string
+		attempt models.Attempt
+		// deployed attempts have a release
+		deployed bool
+
+		wantStatus    string
+		wantStopped   bool
+		wantAbandoned bool
+	}{
+		{
+			name:        "running",
+			attempt:     models.Attempt{Token: "running", Status: models.AttemptRunning, StartedAt: &startedAt},
+			deployed:    true,
+			wantStatus:  models.AttemptStopped,
+			wantStopped: true,
+		},
+		{
+			name:          "starting",
+			attempt:       models.Attempt{Token: "starting", Status: models.AttemptStarting},
+			deployed:      true,
+			wantStatus:    models.AttemptExpired,
+			wantAbandoned: true,
+		},
+		{
+			name:          "starting without a release",
+			attempt:       models.Attempt{Token: "undeployed", Status: models.AttemptStarting, ReleaseName: attemptRelease("undeployed")},
+			wantStatus:    models.AttemptExpired,
+			wantAbandoned: true,
+		},
+		{
+			name:          "starting on a pool instance",
+			attempt:       models.Attempt{Token: "claimed", Status: models.AttemptStarting, ReleaseName: "pclaimed", Namespace: challengeNamespace},
+			deployed:      true,
+			wantStatus:    models.AttemptExpired,
+			wantAbandoned: true,
+		},
+		{
+			name:          "submitted",
+			attempt:       models.Attempt{Token: "submitted", Status: models.AttemptSubmitted, StartedAt: &startedAt, SubmittedAt: &startedAt},
+			deployed:      true,
+			wantStatus:    models.AttemptExpired,
+			wantAbandoned: true,
+		},
+		{
+			name:       "never started",
+			attempt:    models.Attempt{Token: "created"},
+			wantStatus: models.AttemptExpired,
+		},
+		{
+			name:       "failed",
+			attempt:    models.Attempt{Token: "failed", Status: models.AttemptFailed},
+			wantStatus: models.AttemptExpired,
+		},
+		{
+			name:       "graded",
+			attempt:    models.Attempt{Token: "graded", Status: models.AttemptGraded, StartedAt: &startedAt},
+			wantStatus: models.AttemptGraded,
+		},
+	}
+
+	m := &memStore{
+		challenges: []models.Challenge{challenge},
+		instances: []models.PoolInstance{{
+			Release:       "pready",
+			Namespace:     challengeNamespace,
+			CreatorName:   challenge.CreatorName,
+			ChallengeName: challenge.ChallengeName,
+			Status:        models.PoolReady,
+		}},
+	}
+	fake := deploy.NewFake()
+	deployRelease(t, fake, deploy.Release{Name: "pready", Namespace: challengeNamespace})
+	for _, tt := range tests {
+		attempt := tt.attempt
+		attempt.CreatorName = challenge.CreatorName
+		attempt.ChallengeName = challenge.ChallengeName
+		m.attempts = append(m.attempts, attempt)
+		if tt.deployed {
+			deployRelease(t, fake, releaseOf(&attempt))
+		}
+	}
+	useStore(t, m)
+	useDeployer(t, fake)
+
+	deployer, _ := deployerFor(&challenge)
+	summary := cleanUpChallenge(context.Background(), deployer, &challenge, true)
+
+	if len(summary.Failures) != 0 {
+		t.Errorf("cleanup failures = %v", summary.Failures)
+	}
+	if len(summary.PoolInstances) != 1 || len(m.instances) != 0 {
+		t.Errorf("pool instances discarded = %v, left = %v", summary.PoolInstances, m.instances)
+	}
+	if _, ok := fake.Spec(deploy.Release{Name: "pready", Namespace: challengeNamespace}); ok {
+		t.Error("pool instance release was not torn down")
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := m.Attempt(tt.attempt.Token)
+			if attempt.Status != tt.wantStatus {
+				t.Errorf("attempt status = %q, want %q", attempt.Status, tt.wantStatus)
+			}
+			if _, deployed := fake.Spec(releaseOf(&attempt)); deployed {
+				t.Error("attempt release was not torn down")
+			}
+			if stopped := contains(summary.StoppedAttempts, attempt.Token); stopped != tt.wantStopped {
+				t.Errorf("reported stopped = %v, want %v", stopped, tt.wantStopped)
+			}
+			if abandoned := contains(summary.AbandonedAttempts, attempt.Token); abandoned != tt.wantAbandoned {
+				t.Errorf("reported abandoned = %v, want %v", abandoned, tt.wantAbandoned)
+			}
+			wantExpired := tt.wantStatus == models.AttemptExpired
+			if expired := contains(summary.ExpiredAttempts, attempt.Token); expired != wantExpired {
+				t.Errorf("reported expired = %v, want %v", expired, wantExpired)
+			}
+		})
+	}
+}
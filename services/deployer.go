@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// namespace the challenge releases are installed into
+const challengeNamespace = "challenge"
+
+var deployers = map[string]deploy.Deployer{}
+
+// SetDeployer registers a backend challenges can select by name. Its
+// changes to the cluster are audited.
+func SetDeployer(name string, d deploy.Deployer) {
+	deployers[name] = auditDeployer(name, d)
+}
+
+// deployerFor returns the backend the challenge asks for, falling back to
+// DEFAULT_DEPLOYER.
+func deployerFor(challenge *models.Challenge) (deploy.Deployer, error) {
+	name := challenge.Deployer
+	if name == "" {
+		name = config.DEFAULT_DEPLOYER
+	}
+
+	d, ok := deployers[name]
+	if !ok {
+		return nil, fmt.Errorf("deployer %q is not available", name)
+	}
+	return d, nil
+}
+
+// attemptRelease is the release name of an attempt's challenge pod.
+func attemptRelease(token string) string {
+	return fmt.Sprintf("a%s", token)
+}
+
+// releaseOf returns the release an attempt runs on.
+func releaseOf(attempt *models.Attempt) deploy.Release {
+	name := attempt.ReleaseName
+	if name == "" {
+		name = attemptRelease(attempt.Token)
+	}
+	namespace := attempt.Namespace
+	if namespace == "" {
+		namespace = challengeNamespace
+	}
+	return deploy.Release{Name: name, Namespace: namespace}
+}
+
+// challengeEnv are the env values every release of a challenge gets.
+func challengeEnv() []deploy.EnvVar {
+	return []deploy.EnvVar{
+		{Name: "PLATFORM_PASSWORD", Value: config.PLATFORM_PASSWORD},
+		{Name: "PLATFORM_USERNAME", Value: config.PLATFORM_USERNAME},
+	}
+}
+
+// attemptEnv are the env values of an attempt's release, written to
+// deploy.AttemptEnvPath alike whether the release was deployed for the
+// attempt or claimed from a warm pool.
+func attemptEnv(token string) []deploy.EnvVar {
+	return append(challengeEnv(), deploy.EnvVar{Name: "ATTEMPT_TOKEN", Value: token})
+}
+
+// challengeSpec is the part of a release's spec shared by all attempts of a
+// challenge, without an attempt's key and token.
+func challengeSpec(challenge *models.Challenge, release deploy.Release) (*deploy.Spec, error) {
+	// parse the image the challenge runs, pinned to the digest resolved
+	// when the challenge was created
+	image, err := utils.ParseImageReference(challenge.ImageRegistryLink)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image %s: %w", challenge.ImageRegistryLink, err)
+	}
+	if challenge.ImageDigest != "" {
+		image.Digest = challenge.ImageDigest
+	}
+
+	resources, err := challengeResources(challenge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource profile: %w", err)
+	}
+
+	// use the challenge's own registry credentials if it has any
+	pullCredentials, err := challengeCredentials(context.Background(), challenge, image.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &deploy.Spec{
+		Release:         release,
+		Image:           image,
+		Env:             challengeEnv(),
+		Values:          challenge.Values,
+		PullCredentials: pullCredentials,
+		Resources:       resources,
+		Isolation:       releaseIsolation(challenge, resources),
+		Egress:          challengeEgress(challenge),
+	}, nil
+}
+
+// challengeResources resolves the challenge's resource profile against the
+// configured maxima, nil if it has none.
+func challengeResources(challenge *models.Challenge) (*deploy.Resources, error) {
+	profile := challenge.Resources
+	if profile == nil {
+		return nil, nil
+	}
+
+	return deploy.ResolveResources(
+		profile.Tier,
+		deploy.ResourceList(profile.Requests),
+		deploy.ResourceList(profile.Limits),
+		deploy.ResourceList{
+			CPU:              config.CHALLENGE_MAX_CPU,
+			Memory:           config.CHALLENGE_MAX_MEMORY,
+			EphemeralStorage: config.CHALLENGE_MAX_EPHEMERAL_STORAGE,
+		},
+	)
+}
+
+// releaseNamespace picks the namespace an attempt's release lives in for the
+// configured isolation mode.
+func releaseNamespace(challenge *models.Challenge, release string) string {
+	switch config.ISOLATION_MODE {
+	case deploy.IsolationAttempt:
+		return release
+	case deploy.IsolationChallenge:
+		sum := sha1.Sum([]byte(challenge.CreatorName + "/" + challenge.ChallengeName))
+		return fmt.Sprintf("c-%x", sum[:10])
+	default:
+		return challengeNamespace
+	}
+}
+
+// registrySecretName is the secret a challenge's own registry credentials
+// are kept in.
+func registrySecretName(challenge *models.Challenge) string {
+	sum := sha1.Sum([]byte(challenge.CreatorName + "/" + challenge.ChallengeName))
+	return fmt.Sprintf("registry-%x", sum[:10])
+}
+
+// releaseIsolation describes the isolated namespace of a release, nil when
+// attempts share the challenge namespace.
+func releaseIsolation(challenge *models.Challenge, resources *deploy.Resources) *deploy.Isolation {
+	// each attempt gets room for its pod and one more during upgrades
+	var pods int
+	switch config.ISOLATION_MODE {
+	case deploy.IsolationAttempt:
+		pods = 2
+	case deploy.IsolationChallenge:
+		pods = 2 * len(challenge.Participants)
+	default:
+		return nil
+	}
+
+	defaults := deploy.Tiers["small"]
+	if resources != nil {
+		defaults = *resources
+	}
+
+	return &deploy.Isolation{
+		Mode:             config.ISOLATION_MODE,
+		Pods:             pods,
+		Defaults:         defaults,
+		PodCIDR:          config.ISOLATION_POD_CIDR,
+		SharedNamespace:  challengeNamespace,
+		ServiceNamespace: config.ISOLATION_SERVICE_NAMESPACE,
+		ServiceLabels:    config.ISOLATION_SERVICE_LABELS,
+	}
+}
+
+// challengeEgress converts the challenge's egress settings, nil if it has none.
+func challengeEgress(challenge *models.Challenge) *deploy.Egress {
+	if challenge.Egress == nil {
+		return nil
+	}
+	return &deploy.Egress{
+		Mode:      challenge.Egress.Mode,
+		CIDRs:     challenge.Egress.CIDRs,
+		Hostnames: challenge.Egress.Hostnames,
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/gateway"
+	"sys.io/challenge-service/models"
+)
+
+var kubeClient kubernetes.Interface
+var restConfig *rest.Config
+var sessions *gateway.Sessions
+
+// SetKube sets the cluster client used outside of the deployers, and the
+// config exec into pods needs.
+func SetKube(kube kubernetes.Interface, rest *rest.Config) {
+	kubeClient = kube
+	restConfig = rest
+}
+
+// SetSessions sets the live gateway and terminal sessions to close when
+// access to an attempt is withdrawn.
+func SetSessions(s *gateway.Sessions) {
+	sessions = s
+}
+
+// GatewayTarget resolves an attempt token to its challenge pod's ClusterIP
+// service for the SSH gateway.
+func GatewayTarget(ctx context.Context, token string) (*gateway.Target, error) {
+	attempt, err := store.GetAttempt(token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, gateway.ErrUnknownAttempt
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the key is only stored once the attempt was started, submitted
+	// attempts are kept for grading only
+	status := models.AttemptStatus(&attempt)
+	if attempt.Sshkey == "" || status == models.AttemptSubmitted || models.AttemptOver(status) {
+		return nil, gateway.ErrUnknownAttempt
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(attempt.Sshkey))
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := deploy.ServiceAddress(ctx, kubeClient, releaseOf(&attempt))
+	if err != nil {
+		return nil, err
+	}
+
+	return &gateway.Target{Addr: addr, User: config.SSH_GATEWAY_USER, Signer: signer}, nil
+}
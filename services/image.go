@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sys.io/challenge-service/utils"
+)
+
+// ListImages publishes the catalog of images a creator can build challenges from.
+func ListImages(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	creatorName, _ := data["creatorName"].(string)
+	if creatorName == "" {
+		log.Printf("Image list request without creatorName")
+		publishEvent(ch, ctx, data, "challengeImageListFailed", routingKey)
+		return
+	}
+
+	images, err := store.ListImages(creatorName)
+	if err != nil {
+		log.Printf("Failed to list images for %s: %s", creatorName, err)
+		publishEvent(ch, ctx, data, "challengeImageListFailed", routingKey)
+		return
+	}
+
+	data["images"] = images
+	publishEvent(ch, ctx, data, "challengeImageListed", routingKey)
+}
+
+// availableTags lists an image's tags for failure events, empty on error.
+func availableTags(creatorName, imageName string) []string {
+	tags := []string{}
+
+	versions, err := store.ListImageVersions(creatorName, imageName)
+	if err != nil {
+		log.Printf("Failed to list versions of %s: %s", imageName, err)
+		return tags
+	}
+
+	for _, v := range versions {
+		tags = append(tags, v.ImageTag)
+	}
+	return tags
+}
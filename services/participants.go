@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// AddParticipants adds participants to an existing challenge, each gets an
+// attempt with a fresh token.
+func AddParticipants(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+	data, challenge, participants, ok := participantsCommand(ch, ctx, msg, "challengeParticipantsAddFailed", routingKey)
+	if !ok {
+		return
+	}
+
+	// attempts first, the challenge only lists participants that have one
+	requested, _ := models.DiffParticipants(challenge.Participants, participants)
+	added, err := addParticipants(challenge, requested)
+	if len(added) > 0 {
+		// keep the ones that made it if others failed, they can be retried
+		if addErr := store.AddChallengeParticipants(challenge.CreatorName, challenge.ChallengeName, added); addErr != nil && err == nil {
+			err = addErr
+		}
+	}
+	data["added"] = added
+	if err != nil {
+		log.Printf("Failed to add participants to %s: %s", challenge.ChallengeName, err)
+		publishEvent(ch, ctx, data, "challengeParticipantsAddFailed", routingKey)
+		return
+	}
+
+	// the pool may grow with the new participants
+	if keepsPool(challenge) {
+		FillPool(*challenge)
+	}
+
+	log.Printf("Added %d participants to %s", len(added), challenge.ChallengeName)
+	publishEvent(ch, ctx, data, "challengeParticipantsAdded", routingKey)
+}
+
+// RemoveParticipants removes participants from an existing challenge,
+// tearing down and deleting their attempts.
+func RemoveParticipants(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+	data, challenge, participants, ok := participantsCommand(ch, ctx, msg, "challengeParticipantsRemoveFailed", routingKey)
+	if !ok {
+		return
+	}
+
+	// off the list first so a failed teardown never leaves an attempt of a
+	// participant the challenge no longer lists
+	if err := store.RemoveChallengeParticipants(challenge.CreatorName, challenge.ChallengeName, participants); err != nil {
+		log.Printf("Failed to remove participants from %s: %s", challenge.ChallengeName, err)
+		publishEvent(ch, ctx, data, "challengeParticipantsRemoveFailed", routingKey)
+		return
+	}
+
+	attempts, err := store.ListAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to list attempts of %s: %s", challenge.ChallengeName, err)
+		publishEvent(ch, ctx, data, "challengeParticipantsRemoveFailed", routingKey)
+		return
+	}
+	data["removed"] = removeParticipants(ctx, attempts, participants)
+
+	// the pool shrinks with the participants that left
+	if keepsPool(challenge) {
+		FillPool(*challenge)
+	}
+
+	log.Printf("Removed %d participants from %s", len(participants), challenge.ChallengeName)
+	publishEvent(ch, ctx, data, "challengeParticipantsRemoved", routingKey)
+}
+
+// participantsCommand decodes a participants command and loads its
+// challenge, publishing the failure event if either fails.
+func participantsCommand(ch *amqp.Channel, ctx context.Context, msg []byte, failedStatus string, routingKey string) (map[string]interface{}, *models.Challenge, []string, bool) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return nil, nil, nil, false
+	}
+
+	var command struct {
+		CreatorName   string   `json:"creatorName"`
+		ChallengeName string   `json:"challengeName"`
+		Participants  []string `json:"participants"`
+	}
+	if err := json.Unmarshal(msg, &command); err != nil || len(command.Participants) == 0 {
+		data["failureReason"] = "invalidParticipants"
+		data["failureMessage"] = "participants must be a non-empty list of names"
+		publishEvent(ch, ctx, data, failedStatus, routingKey)
+		return nil, nil, nil, false
+	}
+
+	challenge, err := store.GetChallenge(command.CreatorName, command.ChallengeName)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		data["failureReason"] = "challengeNotFound"
+		publishEvent(ch, ctx, data, failedStatus, routingKey)
+		return nil, nil, nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to find challenge %s: %s", command.ChallengeName, err)
+		publishEvent(ch, ctx, data, failedStatus, routingKey)
+		return nil, nil, nil, false
+	}
+	if challenge.ArchivedAt != nil {
+		data["failureReason"] = "challengeArchived"
+		publishEvent(ch, ctx, data, failedStatus, routingKey)
+		return nil, nil, nil, false
+	}
+	return data, &challenge, command.Participants, true
+}
+
+// addParticipants creates an attempt for each participant and returns the
+// participants that have one, also if it existed already.
+func addParticipants(challenge *models.Challenge, participants []string) ([]string, error) {
+	added := []string{}
+	for _, participant := range participants {
+		_, err := store.CreateAttempt(newAttempt(challenge, participant))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return added, fmt.Errorf("attempt for %s: %w", participant, err)
+		}
+		added = append(added, participant)
+	}
+	return added, nil
+}
+
+// removeParticipants tears down the environments of participants' attempts
+// and deletes the attempts, returning the participants whose attempts are
+// gone. Attempts whose teardown failed are kept with their release, to be
+// removed again.
+func removeParticipants(ctx context.Context, attempts []models.Attempt, participants []string) []string {
+	removed := []string{}
+	for i := range attempts {
+		attempt := &attempts[i]
+		if !contains(participants, attempt.Participant) {
+			continue
+		}
+
+		attemptCtx := withAttempt(ctx, attempt)
+		var err error
+		switch status := models.AttemptStatus(attempt); {
+		case status == models.AttemptStarting || status == models.AttemptSubmitted:
+			err = abandonAttempt(attemptCtx, attempt)
+		case models.AttemptActive(status):
+			err = stopAttempt(attemptCtx, attempt.Token)
+		}
+		if err != nil {
+			log.Printf("Failed to remove the environment of attempt %s: %s", attempt.Token, err)
+			continue
+		}
+		if err := store.DeleteAttempt(attempt.Token); err != nil {
+			log.Printf("Failed to delete attempt %s: %s", attempt.Token, err)
+			continue
+		}
+		audit(attemptCtx, models.AuditEntry{Kind: models.AuditAction, Action: "delete attempt"})
+		removed = append(removed, attempt.Participant)
+	}
+	return removed
+}
+
+// newAttempt is a participant's attempt at a challenge before it starts.
+func newAttempt(challenge *models.Challenge, participant string) *models.Attempt {
+	return &models.Attempt{
+		Participant:       participant,
+		Token:             uuid.NewString(),
+		Sshkey:            "",
+		Result:            0,
+		Ipaddress:         "",
+		Port:              "",
+		ChallengeName:     challenge.ChallengeName,
+		CreatorName:       challenge.CreatorName,
+		ImageRegistryLink: challenge.ImageRegistryLink,
+		Status:            models.AttemptCreated,
+		Transitions:       []models.AttemptTransition{{To: models.AttemptCreated, At: time.Now()}},
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+// participantsOf lists the participants the stored attempts of a challenge
+// belong to.
+func participantsOf(m *memStore, challenge *models.Challenge) []string {
+	attempts, _ := m.listAttempts(challenge.CreatorName, challenge.ChallengeName)
+	participants := []string{}
+	for _, attempt := range attempts {
+		participants = append(participants, attempt.Participant)
+	}
+	return participants
+}
+
+func TestAddParticipants(t *testing.T) {
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Participants: []string{"alice"}}
+
+	tests := []struct {
+		name        string
+		attemptErrs map[string]error
+
+		wantEvent        string
+		wantParticipants []string
+	}{
+		{
+			name:             "added",
+			wantEvent:        "challengeParticipantsAdded",
+			wantParticipants: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:             "some fail",
+			attemptErrs:      map[string]error{"carol": errors.New("write conflict")},
+			wantEvent:        "challengeParticipantsAddFailed",
+			wantParticipants: []string{"alice", "bob"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &memStore{
+				challenges:  []models.Challenge{challenge},
+				attempts:    []models.Attempt{{Token: "alice", Participant: "alice", CreatorName: "creator", ChallengeName: "ctf"}},
+				attemptErrs: tt.attemptErrs,
+			}
+			useStore(t, m)
+			events := useEvents(t)
+
+			msg, _ := json.Marshal(map[string]interface{}{
+				"creatorName":   "creator",
+				"challengeName": "ctf",
+				"participants":  []string{"alice", "bob", "carol"},
+			})
+			AddParticipants(nil, context.Background(), msg, "challengeParticipantsAdded")
+
+			if event := events.Last(); event["eventStatus"] != tt.wantEvent {
+				t.Fatalf("events = %v, want %s", events.Statuses(), tt.wantEvent)
+			}
+			// the challenge lists exactly the participants with an attempt
+			if got := m.Challenge("creator", "ctf").Participants; !reflect.DeepEqual(got, tt.wantParticipants) {
+				t.Errorf("participants = %v, want %v", got, tt.wantParticipants)
+			}
+			if got := participantsOf(m, &challenge); !reflect.DeepEqual(got, tt.wantParticipants) {
+				t.Errorf("attempts of %v, want %v", got, tt.wantParticipants)
+			}
+		})
+	}
+}
+
+func TestRemoveParticipants(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake", Participants: []string{"alice", "bob", "carol"}}
+
+	tests := []struct {
+		name        string
+		status      string
+		teardownErr error
+
+		wantRemoved  []interface{}
+		wantAttempts []string
+		wantDeployed bool
+	}{
+		{
+			name:         "running",
+			status:       models.AttemptRunning,
+			wantRemoved:  []interface{}{"bob", "carol"},
+			wantAttempts: []string{"alice"},
+		},
+		{
+			name:         "starting",
+			status:       models.AttemptStarting,
+			wantRemoved:  []interface{}{"bob", "carol"},
+			wantAttempts: []string{"alice"},
+		},
+		{
+			name:         "submitted",
+			status:       models.AttemptSubmitted,
+			wantRemoved:  []interface{}{"bob", "carol"},
+			wantAttempts: []string{"alice"},
+		},
+		{
+			name:         "teardown fails",
+			status:       models.AttemptRunning,
+			teardownErr:  errors.New("cluster unreachable"),
+			wantRemoved:  []interface{}{"carol"},
+			wantAttempts: []string{"alice", "bob"},
+			wantDeployed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bob := models.Attempt{
+				Token:         "bob",
+				Participant:   "bob",
+				CreatorName:   "creator",
+				ChallengeName: "ctf",
+				Status:        tt.status,
+				StartedAt:     &startedAt,
+			}
+			m := &memStore{
+				challenges: []models.Challenge{challenge},
+				attempts: []models.Attempt{
+					{Token: "alice", Participant: "alice", CreatorName: "creator", ChallengeName: "ctf"},
+					bob,
+					{Token: "carol", Participant: "carol", CreatorName: "creator", ChallengeName: "ctf"},
+				},
+			}
+			fake := deploy.NewFake()
+			deployRelease(t, fake, releaseOf(&bob))
+			fake.TeardownErr = tt.teardownErr
+			useStore(t, m)
+			useDeployer(t, fake)
+			events := useEvents(t)
+
+			msg, _ := json.Marshal(map[string]interface{}{
+				"creatorName":   "creator",
+				"challengeName": "ctf",
+				"participants":  []string{"bob", "carol", "dave"},
+			})
+			RemoveParticipants(nil, context.Background(), msg, "challengeParticipantsRemoved")
+
+			event := events.Last()
+			if event["eventStatus"] != "challengeParticipantsRemoved" {
+				t.Fatalf("events = %v, want challengeParticipantsRemoved", events.Statuses())
+			}
+			// dave never had an attempt
+			if removed, _ := event["removed"].([]interface{}); !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", event["removed"], tt.wantRemoved)
+			}
+			if got := m.Challenge("creator", "ctf").Participants; !reflect.DeepEqual(got, []string{"alice"}) {
+				t.Errorf("participants = %v, want alice", got)
+			}
+			// an attempt whose release is still there is kept to find it by
+			if got := participantsOf(m, &challenge); !reflect.DeepEqual(got, tt.wantAttempts) {
+				t.Errorf("attempts of %v, want %v", got, tt.wantAttempts)
+			}
+			if _, deployed := fake.Spec(releaseOf(&bob)); deployed != tt.wantDeployed {
+				t.Errorf("release of bob deployed = %v, want %v", deployed, tt.wantDeployed)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+// challenges with a pool fill running, one fill per challenge at a time
+var filling sync.Map
+
+// poolRelease names a new pool instance, attempts keep the name on claim.
+func poolRelease() string {
+	return "p" + strings.ReplaceAll(uuid.NewString(), "-", "")[:16]
+}
+
+// keepsPool reports whether a challenge has a warm pool, either sized or
+// from pre-starting.
+func keepsPool(challenge *models.Challenge) bool {
+	return challenge.WarmPool > 0 || challenge.PreStart > 0
+}
+
+// poolTaper is how long before EndsAt pools of challenges without a
+// duration start to shrink.
+const poolTaper = time.Hour
+
+// poolTarget is how many instances a challenge's pool should hold at now:
+// one per participant who may still start while pre-starting, none outside
+// the window and never more than participants who may still start. Fewer
+// participants start late, so over the last attempt duration before EndsAt
+// the pool shrinks in proportion to the time left.
+func poolTarget(challenge *models.Challenge, unstarted int64, now time.Time) int {
+	switch {
+	case challenge.PreStarting(now):
+		return int(unstarted)
+	case challenge.Window(now) != models.WindowOpen:
+		return 0
+	}
+
+	target := challenge.WarmPool
+	if int64(target) > unstarted {
+		target = int(unstarted)
+	}
+	if challenge.EndsAt == nil {
+		return target
+	}
+
+	taper := time.Duration(challenge.Duration) * time.Minute
+	if taper <= 0 {
+		taper = poolTaper
+	}
+	if left := challenge.EndsAt.Sub(now); left < taper {
+		target = int(math.Ceil(float64(target) * float64(left) / float64(taper)))
+	}
+	return target
+}
+
+// poolContext scopes the audit entries of pool upkeep to the challenge.
+func poolContext(creatorName, challengeName string) context.Context {
+	return withAuditScope(context.Background(), auditScope{
+		CreatorName:   creatorName,
+		ChallengeName: challengeName,
+		Actor:         poolActor,
+	})
+}
+
+// validateWarmPool checks a challenge's pool size against WARM_POOL_MAX and
+// that its deployer can hand instances over.
+func validateWarmPool(challenge *models.Challenge, deployer deploy.Deployer, max int) error {
+	if challenge.WarmPool < 0 || challenge.WarmPool > max {
+		return fmt.Errorf("warm pool of %d outside 0-%d", challenge.WarmPool, max)
+	}
+	if _, ok := deployer.(deploy.Injector); keepsPool(challenge) && !ok {
+		return fmt.Errorf("deployer of challenge %s cannot hand over running releases", challenge.ChallengeName)
+	}
+	return nil
+}
+
+// MaintainPools resizes every warm pool periodically, replacing instances
+// that died and shrinking pools as participants start.
+func MaintainPools(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		challenges, err := store.ListPoolChallenges()
+		if err != nil {
+			log.Printf("Failed to list warm pools: %s", err)
+			continue
+		}
+		for _, challenge := range challenges {
+			FillPool(challenge)
+		}
+	}
+}
+
+// FillPool resizes a challenge's warm pool in the background.
+func FillPool(challenge models.Challenge) {
+	key := challenge.CreatorName + "/" + challenge.ChallengeName
+	if _, busy := filling.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+
+	go func() {
+		defer filling.Delete(key)
+		fillPool(&challenge)
+	}()
+}
+
+func fillPool(challenge *models.Challenge) {
+	deployer, err := deployerFor(challenge)
+	if err != nil {
+		log.Printf("Warm pool of %s: %s", challenge.ChallengeName, err)
+		return
+	}
+
+	instances, err := store.ListPoolInstances(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to list warm pool of %s: %s", challenge.ChallengeName, err)
+		return
+	}
+	unstarted, err := store.CountUnstartedAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to count attempts of %s: %s", challenge.ChallengeName, err)
+		return
+	}
+	target := poolTarget(challenge, unstarted, time.Now())
+
+	// instances stuck warming past the start timeout will never be ready,
+	// ones deployed before the challenge changed are replaced once ready
+	size := 0
+	for _, instance := range instances {
+		if instance.Status == models.PoolWarming && time.Since(instance.CreatedAt) > startTimeout {
+			log.Printf("Warm pool instance %s never became ready", instance.Release)
+			discardPoolInstance(deployer, instance)
+			continue
+		}
+		if instance.Revision == challenge.Revision {
+			size++
+		}
+	}
+	for {
+		instance, err := store.TakeStalePoolInstance(challenge.CreatorName, challenge.ChallengeName, challenge.Revision)
+		if err != nil {
+			break
+		}
+		log.Printf("Warm pool instance %s of %s is out of date", instance.Release, challenge.ChallengeName)
+		discardPoolInstance(deployer, instance)
+	}
+
+	// shrink, only ready instances can be taken out
+	for ; size > target; size-- {
+		instance, err := store.TakePoolInstance(challenge.CreatorName, challenge.ChallengeName, challenge.Revision)
+		if err != nil {
+			break
+		}
+		log.Printf("Shrinking warm pool of %s, removing %s", challenge.ChallengeName, instance.Release)
+		discardPoolInstance(deployer, instance)
+	}
+
+	// grow
+	var wg sync.WaitGroup
+	for ; size < target; size++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			warmPoolInstance(deployer, challenge)
+		}()
+	}
+	wg.Wait()
+}
+
+// warmPoolInstance deploys a keyless release and adds it to the pool once
+// it runs.
+func warmPoolInstance(deployer deploy.Deployer, challenge *models.Challenge) {
+	name := poolRelease()
+	release := deploy.Release{Name: name, Namespace: releaseNamespace(challenge, name)}
+	instance := models.PoolInstance{
+		Release:       release.Name,
+		Namespace:     release.Namespace,
+		CreatorName:   challenge.CreatorName,
+		ChallengeName: challenge.ChallengeName,
+		Status:        models.PoolWarming,
+		Revision:      challenge.Revision,
+		CreatedAt:     time.Now(),
+	}
+
+	spec, err := challengeSpec(challenge, release)
+	if err != nil {
+		log.Printf("Warm pool of %s: %s", challenge.ChallengeName, err)
+		return
+	}
+	if _, err := store.CreatePoolInstance(&instance); err != nil {
+		log.Printf("Failed to record warm pool instance %s: %s", name, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(poolContext(challenge.CreatorName, challenge.ChallengeName), startTimeout)
+	defer cancel()
+
+	if err := deployer.Deploy(ctx, spec); err != nil {
+		log.Printf("Failed to deploy warm pool instance %s: %s", name, err)
+		discardPoolInstance(deployer, instance)
+		return
+	}
+	status, err := deploy.WaitReady(ctx, deployer, release, 5*time.Second, nil)
+	if err != nil || status != deploy.StatusRunning {
+		log.Printf("Warm pool instance %s did not start (%s): %v", name, status, err)
+		discardPoolInstance(deployer, instance)
+		return
+	}
+
+	if err := store.MarkPoolInstanceReady(name); err != nil {
+		log.Printf("Failed to mark warm pool instance %s ready: %s", name, err)
+		return
+	}
+	log.Printf("Warm pool instance %s of %s ready", name, challenge.ChallengeName)
+}
+
+// discardPoolInstance uninstalls an instance and forgets it.
+func discardPoolInstance(deployer deploy.Deployer, instance models.PoolInstance) {
+	release := deploy.Release{Name: instance.Release, Namespace: instance.Namespace}
+	if err := deployer.Teardown(poolContext(instance.CreatorName, instance.ChallengeName), release); err != nil {
+		log.Printf("Failed to tear down warm pool instance %s: %s", instance.Release, err)
+	}
+	if err := store.DeletePoolInstance(instance.Release); err != nil {
+		log.Printf("Failed to delete warm pool instance %s: %s", instance.Release, err)
+	}
+}
+
+// takePoolInstance takes a ready instance of the challenge out of its warm
+// pool for an attempt to start on. It reports false if there is none or the
+// deployer cannot hand instances over.
+func takePoolInstance(deployer deploy.Deployer, challenge *models.Challenge) (models.PoolInstance, bool) {
+	if _, ok := deployer.(deploy.Injector); !keepsPool(challenge) || !ok {
+		return models.PoolInstance{}, false
+	}
+
+	instance, err := store.TakePoolInstance(challenge.CreatorName, challenge.ChallengeName, challenge.Revision)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Warm pool of %s is empty", challenge.ChallengeName)
+		return models.PoolInstance{}, false
+	}
+	if err != nil {
+		log.Printf("Failed to claim from warm pool of %s: %s", challenge.ChallengeName, err)
+		return models.PoolInstance{}, false
+	}
+	return instance, true
+}
+
+// returnPoolInstance puts an instance taken for an attempt that did not
+// start back into the pool.
+func returnPoolInstance(instance models.PoolInstance) {
+	if _, err := store.CreatePoolInstance(&instance); err != nil {
+		log.Printf("Failed to return warm pool instance %s: %s", instance.Release, err)
+	}
+}
+
+// handOverPoolInstance hands an instance taken from the pool to the
+// attempt. An instance that could not be handed over is torn down.
+func handOverPoolInstance(ctx context.Context, deployer deploy.Deployer, instance models.PoolInstance, token, authorizedKeys string) bool {
+	release := deploy.Release{Name: instance.Release, Namespace: instance.Namespace}
+	err := deployer.(deploy.Injector).Inject(ctx, release, &deploy.Injection{
+		AuthorizedKeys: authorizedKeys,
+		Env:            attemptEnv(token),
+		Labels:         map[string]string{deploy.AttemptLabel: token},
+	})
+	if err != nil {
+		log.Printf("Failed to hand warm pool instance %s to %s: %s", instance.Release, token, err)
+		if err := deployer.Teardown(detached(ctx), release); err != nil {
+			log.Printf("Failed to tear down warm pool instance %s: %s", instance.Release, err)
+		}
+		return false
+	}
+
+	log.Printf("Attempt %s claimed warm pool instance %s", token, instance.Release)
+	return true
+}
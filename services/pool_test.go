@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestPoolTarget(t *testing.T) {
+	startsAt := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(2 * time.Hour)
+
+	tests := []struct {
+		name      string
+		challenge models.Challenge
+		unstarted int64
+		now       time.Time
+		want      int
+	}{
+		{
+			name:      "sized pool",
+			challenge: models.Challenge{WarmPool: 3},
+			unstarted: 10,
+			now:       startsAt,
+			want:      3,
+		},
+		{
+			name:      "fewer participants left than the pool",
+			challenge: models.Challenge{WarmPool: 3},
+			unstarted: 2,
+			now:       startsAt,
+			want:      2,
+		},
+		{
+			name:      "everyone started",
+			challenge: models.Challenge{WarmPool: 3},
+			unstarted: 0,
+			now:       startsAt,
+			want:      0,
+		},
+		{
+			name:      "pre-starting",
+			challenge: models.Challenge{StartsAt: &startsAt, EndsAt: &endsAt, PreStart: 15},
+			unstarted: 10,
+			now:       startsAt.Add(-10 * time.Minute),
+			want:      10,
+		},
+		{
+			name:      "before pre-starting",
+			challenge: models.Challenge{StartsAt: &startsAt, EndsAt: &endsAt, PreStart: 15, WarmPool: 2},
+			unstarted: 10,
+			now:       startsAt.Add(-time.Hour),
+			want:      0,
+		},
+		{
+			name:      "tapering",
+			challenge: models.Challenge{StartsAt: &startsAt, EndsAt: &endsAt, WarmPool: 4, Duration: 60},
+			unstarted: 10,
+			now:       endsAt.Add(-20 * time.Minute),
+			want:      2,
+		},
+		{
+			name:      "tapering without a duration",
+			challenge: models.Challenge{StartsAt: &startsAt, EndsAt: &endsAt, WarmPool: 4},
+			unstarted: 10,
+			now:       endsAt.Add(-time.Minute),
+			want:      1,
+		},
+		{
+			name:      "before tapering",
+			challenge: models.Challenge{StartsAt: &startsAt, EndsAt: &endsAt, WarmPool: 4, Duration: 60},
+			unstarted: 10,
+			now:       endsAt.Add(-time.Hour),
+			want:      4,
+		},
+		{
+			name:      "closed",
+			challenge: models.Challenge{StartsAt: &startsAt, EndsAt: &endsAt, WarmPool: 2},
+			unstarted: 10,
+			now:       endsAt,
+			want:      0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := poolTarget(&tt.challenge, tt.unstarted, tt.now); got != tt.want {
+				t.Errorf("poolTarget() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttemptEnvFromPool(t *testing.T) {
+	direct := models.Challenge{
+		CreatorName:       "creator",
+		ChallengeName:     "direct",
+		Deployer:          "fake",
+		ImageRegistryLink: "registry.example/ctf:1",
+	}
+	pooled := direct
+	pooled.ChallengeName = "pooled"
+	pooled.WarmPool = 1
+
+	fake := deploy.NewFake()
+	pool := deploy.Release{Name: "pinstance", Namespace: challengeNamespace}
+	m := &memStore{
+		challenges: []models.Challenge{direct},
+		attempts:   []models.Attempt{{Token: "direct", CreatorName: "creator", ChallengeName: "direct"}},
+		instances: []models.PoolInstance{{
+			Release:       pool.Name,
+			Namespace:     pool.Namespace,
+			CreatorName:   "creator",
+			ChallengeName: "pooled",
+			Status:        models.PoolReady,
+		}},
+	}
+	useStore(t, m)
+	useDeployer(t, fake)
+	useEvents(t)
+
+	// a pool instance is deployed before it belongs to anyone
+	spec, err := challengeSpec(&pooled, pool)
+	if err != nil {
+		t.Fatalf("challengeSpec() error = %v", err)
+	}
+	if err := fake.Deploy(context.Background(), spec); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+
+	if !startAttempt(nil, context.Background(), &direct, m.Attempt("direct"), map[string]interface{}{}, "challengeStarted") {
+		t.Fatal("startAttempt() failed")
+	}
+	deployed, _ := fake.Spec(deploy.Release{Name: attemptRelease("direct"), Namespace: challengeNamespace})
+
+	deployer, _ := deployerFor(&pooled)
+	instance, claimed := takePoolInstance(deployer, &pooled)
+	if !claimed || instance.Release != pool.Name {
+		t.Fatalf("takePoolInstance() = %v, %v", instance, claimed)
+	}
+	if !handOverPoolInstance(context.Background(), deployer, instance, "pooled", "ssh-ed25519 AAAA") {
+		t.Fatal("handOverPoolInstance() failed")
+	}
+	injection, _ := fake.Injection(pool)
+
+	// both get the same values in the env file, each with its own token
+	values := func(env []deploy.EnvVar) map[string]string {
+		m := map[string]string{}
+		for _, e := range env {
+			m[e.Name] = e.Value
+		}
+		return m
+	}
+	got, want := values(injection.Env), values(deployed.Env)
+	if got["ATTEMPT_TOKEN"] != "pooled" || want["ATTEMPT_TOKEN"] != "direct" {
+		t.Errorf("ATTEMPT_TOKEN pool %q, direct %q", got["ATTEMPT_TOKEN"], want["ATTEMPT_TOKEN"])
+	}
+	delete(got, "ATTEMPT_TOKEN")
+	delete(want, "ATTEMPT_TOKEN")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pool env = %v, want %v as deployed directly", got, want)
+	}
+}
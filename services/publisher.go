@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sys.io/challenge-service/config"
+)
+
+// eventChannel is where events are published: the consumer's channel while
+// a command is handled, the detached publisher for work that outlives it.
+type eventChannel interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Publisher publishes on a connection and channel of its own, reopened when
+// they close. The consumer's channel is replaced on every reconnect, so
+// background work must not hold on to it.
+type Publisher struct {
+	rmq *config.RabbitMQ
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+func NewPublisher(rmq *config.RabbitMQ) *Publisher {
+	return &Publisher{rmq: rmq}
+}
+
+// PublishWithContext publishes on the publisher's channel, opening a new
+// one first if it was closed.
+func (p *Publisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func (p *Publisher) channel() (*amqp.Channel, error) {
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+
+	if p.rmq == nil {
+		return nil, fmt.Errorf("no MQ to publish to")
+	}
+	if p.conn == nil || p.conn.IsClosed() {
+		conn, err := connectToRabbitMQ(p.rmq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to MQ: %w", err)
+		}
+		p.conn = conn
+	}
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a channel: %w", err)
+	}
+	p.ch = ch
+	return ch, nil
+}
+
+// Close closes the publisher's connection.
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.ch = nil, nil
+}
+
+// publisher publishes the events of background work.
+var publisher eventChannel = &Publisher{}
+
+// SetPublisher sets the publisher of the events of background work.
+func SetPublisher(p *Publisher) {
+	publisher = p
+}
@@ -2,12 +2,14 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/models"
 	"sys.io/challenge-service/utils"
 )
 
@@ -83,14 +85,15 @@ func Consume(rmq *config.RabbitMQ, queueName string) {
 					ch.Close()
 					break consumeLoop
 				case d := <-msgs:
-					ctx := context.Background()
+					ctx := withAuditScope(context.Background(), commandScope(d.Body, d.UserId))
 		
 					// Process the message
-					log.Printf("Received a message from queue %s: %s", queueName, d.Body)
+					log.Printf("Received a message from queue %s: %s", queueName, redactCommand(d.Body))
 		
 					// Process message based on Routing Key
 		
 					routingKey := utils.GetSuffix(d.RoutingKey)
+					audit(ctx, models.AuditEntry{Kind: models.AuditCommand, Action: routingKey})
 		
 					if routingKey == "challengeCreate" {
 						newRoutingKey := "challengeCreated"
@@ -98,6 +101,42 @@ func Consume(rmq *config.RabbitMQ, queueName string) {
 					} else if routingKey == "challengeStart" {
 						newRoutingKey := "challengeStarted"
 						StartChallenge(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeUpdate" {
+						newRoutingKey := "challengeUpdated"
+						UpdateChallenge(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeParticipantsAdd" {
+						newRoutingKey := "challengeParticipantsAdded"
+						AddParticipants(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeParticipantsRemove" {
+						newRoutingKey := "challengeParticipantsRemoved"
+						RemoveParticipants(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeDelete" {
+						newRoutingKey := "challengeDeleted"
+						DeleteChallenge(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeStartAll" {
+						newRoutingKey := "challengeStartedAll"
+						StartAllAttempts(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeStopAll" {
+						newRoutingKey := "challengeStoppedAll"
+						StopAllAttempts(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeStop" {
+						newRoutingKey := "challengeStopped"
+						StopChallenge(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "attemptSubmit" {
+						newRoutingKey := "attemptSubmitted"
+						SubmitAttempt(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "attemptResult" {
+						newRoutingKey := "attemptGraded"
+						RecordResult(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeImageList" {
+						newRoutingKey := "challengeImageListed"
+						ListImages(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "attemptRecordingList" {
+						newRoutingKey := "attemptRecordingListed"
+						ListRecordings(ch, ctx, d.Body, newRoutingKey)
+					} else if routingKey == "challengeAuditList" {
+						newRoutingKey := "challengeAuditListed"
+						ListAudit(ch, ctx, d.Body, newRoutingKey)
 					}
 		
 					// Acknowledge the message
@@ -112,7 +151,28 @@ func Consume(rmq *config.RabbitMQ, queueName string) {
 	<-forever
 }
 
-func Publish(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+// redactCommand returns a command body fit for the log, with the registry
+// credentials challengeCreate brings masked. Bodies that are not JSON are
+// left out, they cannot be masked.
+func redactCommand(body []byte) []byte {
+	var command map[string]interface{}
+	if err := json.Unmarshal(body, &command); err != nil {
+		return []byte(fmt.Sprintf("(%d bytes, not JSON)", len(body)))
+	}
+	if _, ok := command["registryCredentials"]; !ok {
+		return body
+	}
+	command["registryCredentials"] = "(redacted)"
+	redacted, err := json.Marshal(command)
+	if err != nil {
+		return []byte("(not printable)")
+	}
+	return redacted
+}
+
+// Publish sends a message to the exchange. Failures are logged and returned,
+// the service keeps running when the broker is away.
+func Publish(ch eventChannel, ctx context.Context, msg []byte, routingKey string) error {
 
 	err := ch.PublishWithContext(
 		ctx,
@@ -124,6 +184,23 @@ func Publish(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey strin
 			ContentType: "text/plain",
 			Body:        msg,
 		})
-	utils.FailOnError(err, "Failed to publish a message")
+	if err != nil {
+		log.Printf("Failed to publish a message with routing key %s: %s", fmt.Sprintf("challenge.fromService.%s", routingKey), err)
+		return err
+	}
 	log.Printf("Published a message with routing key %s", fmt.Sprintf("challenge.fromService.%s", routingKey))
+	return nil
+}
+
+// publish sends a message to the exchange, a var so tests can capture it.
+var publish = Publish
+
+// publishEvent sets the eventStatus on data, publishes it and records it in
+// the audit log.
+func publishEvent(ch eventChannel, ctx context.Context, data map[string]interface{}, eventStatus string, routingKey string) error {
+	data["eventStatus"] = eventStatus
+	msgBody, _ := json.Marshal(data)
+	err := publish(ch, ctx, msgBody, routingKey)
+	auditEvent(ctx, data, eventStatus, err)
+	return err
 }
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+// releases an attempt is being started on, they have no record yet
+var starting sync.Map
+
+func markStarting(release deploy.Release) func() {
+	starting.Store(release, struct{}{})
+	return func() { starting.Delete(release) }
+}
+
+// RunReconciler reconciles every interval until the process exits.
+func RunReconciler(interval, grace time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := withAuditScope(context.Background(), auditScope{Actor: reconcileActor})
+		if err := Reconcile(ctx, grace, dryRun); err != nil {
+			log.Printf("Reconcile failed: %s", err)
+		}
+	}
+}
+
+// Reconcile brings the releases on the cluster in line with the attempt and
+// warm pool records. Releases without a record or of attempts that are over
+// are uninstalled, attempts whose release vanished are marked ended.
+// Attempts left starting for longer than grace by a start that is no longer
+// running are failed. A dry run only logs what would be done. The pass is
+// abandoned if a challenge cannot be looked up, its releases would look
+// orphaned.
+func Reconcile(ctx context.Context, grace time.Duration, dryRun bool) error {
+	attempts, err := store.ListStartedAttempts()
+	if err != nil {
+		return err
+	}
+	startingAttempts, err := store.ListStartingAttempts()
+	if err != nil {
+		return err
+	}
+	instances, err := store.ListAllPoolInstances()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	prefix := "Reconcile:"
+	if dryRun {
+		prefix = "Reconcile (dry run):"
+	}
+	challenges := map[[2]string]*models.Challenge{}
+	// challengeOf returns nil for challenges that are gone, their releases
+	// count as orphaned
+	challengeOf := func(creatorName, challengeName string) (*models.Challenge, error) {
+		key := [2]string{creatorName, challengeName}
+		if challenge, ok := challenges[key]; ok {
+			return challenge, nil
+		}
+		challenge, err := store.GetChallenge(creatorName, challengeName)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Reconcile: challenge %s is gone", challengeName)
+			challenges[key] = nil
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("finding challenge %s: %w", challengeName, err)
+		}
+		challenges[key] = &challenge
+		return &challenge, nil
+	}
+
+	// group the records by the deployer that runs them, records whose
+	// deployer is unknown still keep their release from being orphaned
+	recorded := map[deploy.Deployer][]deploy.RecordedRelease{}
+	owners := map[deploy.Release]*models.Attempt{}
+	unresolved := map[deploy.Release]bool{}
+
+	// a starting attempt's release is its own, it is only removed with the
+	// attempt once no start is left to finish it, like after a restart
+	for i := range startingAttempts {
+		attempt := &startingAttempts[i]
+		release := releaseOf(attempt)
+		unresolved[release] = true
+		if _, ok := starting.Load(release); ok {
+			continue
+		}
+		since := startingSince(attempt)
+		if now.Sub(since) < grace {
+			continue
+		}
+		log.Printf("%s attempt %s is starting since %s with no start running, failing it", prefix, attempt.Token, since.Format(time.RFC3339))
+		if !dryRun {
+			if err := abandonAttempt(withAttempt(ctx, attempt), attempt); err != nil {
+				log.Printf("Failed to fail starting attempt %s: %s", attempt.Token, err)
+			}
+		}
+	}
+
+	for i := range attempts {
+		attempt := &attempts[i]
+		challenge, err := challengeOf(attempt.CreatorName, attempt.ChallengeName)
+		if err != nil {
+			return err
+		}
+		if challenge == nil {
+			continue
+		}
+		release := releaseOf(attempt)
+		deployer, err := deployerFor(challenge)
+		if err != nil {
+			log.Printf("Reconcile: attempt %s: %s", attempt.Token, err)
+			unresolved[release] = true
+			continue
+		}
+
+		owners[release] = attempt
+		recorded[deployer] = append(recorded[deployer], deploy.RecordedRelease{
+			Release: release,
+			Since:   *attempt.StartedAt,
+			Expired: attemptEnded(attempt, challenge, now),
+		})
+	}
+	for _, instance := range instances {
+		challenge, err := challengeOf(instance.CreatorName, instance.ChallengeName)
+		if err != nil {
+			return err
+		}
+		if challenge == nil {
+			continue
+		}
+		release := deploy.Release{Name: instance.Release, Namespace: instance.Namespace}
+		deployer, err := deployerFor(challenge)
+		if err != nil {
+			log.Printf("Reconcile: warm pool instance %s: %s", instance.Release, err)
+			unresolved[release] = true
+			continue
+		}
+		recorded[deployer] = append(recorded[deployer], deploy.RecordedRelease{
+			Release: release,
+			Since:   instance.CreatedAt,
+		})
+	}
+
+	for name, deployer := range deployers {
+		running, err := deployer.List(ctx)
+		if err != nil {
+			log.Printf("%s listing %s releases failed: %s", prefix, name, err)
+			continue
+		}
+		plan := deploy.PlanReconcile(running, recorded[deployer], grace, now)
+
+		for _, release := range plan.Orphaned {
+			if _, ok := starting.Load(release); ok || unresolved[release] {
+				continue
+			}
+			log.Printf("%s release %s/%s has no attempt, uninstalling", prefix, release.Namespace, release.Name)
+			if !dryRun {
+				teardownRelease(ctx, deployer, release)
+			}
+		}
+
+		for _, release := range plan.Expired {
+			log.Printf("%s attempt on release %s/%s is over, uninstalling", prefix, release.Namespace, release.Name)
+			if !dryRun {
+				attemptCtx := withAttempt(ctx, owners[release])
+				teardownRelease(attemptCtx, deployer, release)
+				endAttempt(attemptCtx, owners[release], models.AttemptExpired, now)
+			}
+		}
+
+		for _, release := range plan.Vanished {
+			attempt, ok := owners[release]
+			if !ok {
+				log.Printf("%s warm pool instance %s vanished, forgetting it", prefix, release.Name)
+				if !dryRun {
+					if err := store.DeletePoolInstance(release.Name); err != nil {
+						log.Printf("Failed to delete warm pool instance %s: %s", release.Name, err)
+					}
+				}
+				continue
+			}
+			if attempt.EndedAt != nil {
+				continue
+			}
+			log.Printf("%s release %s/%s of attempt %s vanished, ending the attempt", prefix, release.Namespace, release.Name, attempt.Token)
+			if !dryRun {
+				endAttempt(withAttempt(ctx, attempt), attempt, models.AttemptFailed, now)
+			}
+		}
+	}
+	return nil
+}
+
+// startingSince returns when an attempt moved to starting, the zero time
+// if it has no record of it.
+func startingSince(attempt *models.Attempt) time.Time {
+	for i := len(attempt.Transitions) - 1; i >= 0; i-- {
+		if transition := attempt.Transitions[i]; transition.To == models.AttemptStarting {
+			return transition.At
+		}
+	}
+	return time.Time{}
+}
+
+func teardownRelease(ctx context.Context, deployer deploy.Deployer, release deploy.Release) {
+	if err := deployer.Teardown(ctx, release); err != nil {
+		log.Printf("Failed to uninstall release %s: %s", release.Name, err)
+	}
+}
+
+// endAttempt moves an attempt that has not ended yet to its final state
+// and records the end.
+func endAttempt(ctx context.Context, attempt *models.Attempt, status string, now time.Time) {
+	if attempt == nil || attempt.EndedAt != nil {
+		return
+	}
+	if current := models.AttemptStatus(attempt); current != status && models.CanTransition(current, status) {
+		transitionAttempt(ctx, attempt.Token, status)
+	}
+	if err := store.EndAttempt(attempt.Token, now); err != nil {
+		log.Printf("Failed to end attempt %s: %s", attempt.Token, err)
+	}
+}
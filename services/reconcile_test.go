@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+// deployRelease deploys a bare release on the fake.
+func deployRelease(t *testing.T, fake *deploy.Fake, release deploy.Release) {
+	t.Helper()
+	if err := fake.Deploy(context.Background(), &deploy.Spec{Release: release}); err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake", Duration: 60}
+	startedAt := time.Now().Add(-30 * time.Minute)
+	overdue := time.Now().Add(-2 * time.Hour)
+
+	tests := []struct {
+		name string
+		// attempt owns the release, if set, otherwise instance does, if
+		// set, otherwise nothing does
+		attempt  *models.Attempt
+		instance *models.PoolInstance
+		deployed bool
+
+		wantDeployed bool
+		wantStatus   string
+		wantEnded    bool
+		wantInstance bool
+	}{
+		{
+			name:         "running attempt",
+			attempt:      &models.Attempt{Token: "running", Status: models.AttemptRunning, StartedAt: &startedAt},
+			deployed:     true,
+			wantDeployed: true,
+			wantStatus:   models.AttemptRunning,
+		},
+		{
+			name:         "attempt past its duration",
+			attempt:      &models.Attempt{Token: "overdue", Status: models.AttemptRunning, StartedAt: &overdue},
+			deployed:     true,
+			wantDeployed: false,
+			wantStatus:   models.AttemptExpired,
+			wantEnded:    true,
+		},
+		{
+			name:         "attempt whose release vanished",
+			attempt:      &models.Attempt{Token: "vanished", Status: models.AttemptRunning, StartedAt: &startedAt},
+			deployed:     false,
+			wantDeployed: false,
+			wantStatus:   models.AttemptFailed,
+			wantEnded:    true,
+		},
+		{
+			name:         "ready pool instance",
+			instance:     &models.PoolInstance{Release: "pready", Namespace: challengeNamespace, Status: models.PoolReady},
+			deployed:     true,
+			wantDeployed: true,
+			wantInstance: true,
+		},
+		{
+			name:         "pool instance whose release vanished",
+			instance:     &models.PoolInstance{Release: "pgone", Namespace: challengeNamespace, Status: models.PoolReady},
+			deployed:     false,
+			wantInstance: false,
+		},
+		{
+			name:         "orphaned release",
+			deployed:     true,
+			wantDeployed: false,
+		},
+	}
+
+	m := &memStore{challenges: []models.Challenge{challenge}}
+	fake := deploy.NewFake()
+	releases := make([]deploy.Release, len(tests))
+	for i, tt := range tests {
+		switch {
+		case tt.attempt != nil:
+			attempt := *tt.attempt
+			attempt.CreatorName = challenge.CreatorName
+			attempt.ChallengeName = challenge.ChallengeName
+			m.attempts = append(m.attempts, attempt)
+			releases[i] = releaseOf(&attempt)
+		case tt.instance != nil:
+			instance := *tt.instance
+			instance.CreatorName = challenge.CreatorName
+			instance.ChallengeName = challenge.ChallengeName
+			instance.CreatedAt = overdue
+			m.instances = append(m.instances, instance)
+			releases[i] = deploy.Release{Name: instance.Release, Namespace: instance.Namespace}
+		default:
+			releases[i] = deploy.Release{Name: "aorphan", Namespace: challengeNamespace}
+		}
+		if tt.deployed {
+			deployRelease(t, fake, releases[i])
+		}
+	}
+	useStore(t, m)
+	useDeployer(t, fake)
+
+	if err := Reconcile(context.Background(), 0, false); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, deployed := fake.Spec(releases[i]); deployed != tt.wantDeployed {
+				t.Errorf("release deployed = %v, want %v", deployed, tt.wantDeployed)
+			}
+			if tt.attempt != nil {
+				attempt := m.Attempt(tt.attempt.Token)
+				if attempt.Status != tt.wantStatus {
+					t.Errorf("attempt status = %q, want %q", attempt.Status, tt.wantStatus)
+				}
+				if ended := attempt.EndedAt != nil; ended != tt.wantEnded {
+					t.Errorf("attempt ended = %v, want %v", ended, tt.wantEnded)
+				}
+			}
+			if tt.instance != nil {
+				instances, _ := m.listAllPoolInstances()
+				found := false
+				for _, instance := range instances {
+					found = found || instance.Release == tt.instance.Release
+				}
+				if found != tt.wantInstance {
+					t.Errorf("pool instance kept = %v, want %v", found, tt.wantInstance)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileKeepsUnresolvedReleases(t *testing.T) {
+	startedAt := time.Now().Add(-30 * time.Minute)
+
+	tests := []struct {
+		name         string
+		challengeErr error
+		deployer     string
+		wantErr      bool
+	}{
+		{
+			name:         "challenge lookup fails",
+			challengeErr: errors.New("server selection timeout"),
+			deployer:     "fake",
+			wantErr:      true,
+		},
+		{
+			name:     "deployer unavailable",
+			deployer: "retired",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: tt.deployer}
+			attempt := models.Attempt{
+				Token:         "running",
+				CreatorName:   challenge.CreatorName,
+				ChallengeName: challenge.ChallengeName,
+				Status:        models.AttemptRunning,
+				StartedAt:     &startedAt,
+			}
+			instance := models.PoolInstance{
+				Release:       "pready",
+				Namespace:     challengeNamespace,
+				CreatorName:   challenge.CreatorName,
+				ChallengeName: challenge.ChallengeName,
+				Status:        models.PoolReady,
+				CreatedAt:     startedAt,
+			}
+			m := &memStore{
+				challenges:   []models.Challenge{challenge},
+				attempts:     []models.Attempt{attempt},
+				instances:    []models.PoolInstance{instance},
+				challengeErr: tt.challengeErr,
+			}
+			fake := deploy.NewFake()
+			releases := []deploy.Release{releaseOf(&attempt), {Name: instance.Release, Namespace: instance.Namespace}}
+			for _, release := range releases {
+				deployRelease(t, fake, release)
+			}
+			useStore(t, m)
+			useDeployer(t, fake)
+
+			err := Reconcile(context.Background(), 0, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, release := range releases {
+				if _, ok := fake.Spec(release); !ok {
+					t.Errorf("release %s was torn down", release.Name)
+				}
+			}
+			if got := m.Attempt(attempt.Token); got.Status != models.AttemptRunning || got.EndedAt != nil {
+				t.Errorf("attempt = %s, ended %v, want it left running", got.Status, got.EndedAt)
+			}
+		})
+	}
+}
+
+func TestReconcileStartingAttempts(t *testing.T) {
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake", Duration: 60}
+	grace := 10 * time.Minute
+
+	tests := []struct {
+		name         string
+		since        time.Duration
+		inProgress   bool
+		dryRun       bool
+		wantStatus   string
+		wantDeployed bool
+	}{
+		{
+			name:         "starting within grace",
+			since:        time.Minute,
+			wantStatus:   models.AttemptStarting,
+			wantDeployed: true,
+		},
+		{
+			name:         "left starting by a restart",
+			since:        time.Hour,
+			wantStatus:   models.AttemptFailed,
+			wantDeployed: false,
+		},
+		{
+			name:         "slow start still running",
+			since:        time.Hour,
+			inProgress:   true,
+			wantStatus:   models.AttemptStarting,
+			wantDeployed: true,
+		},
+		{
+			name:         "dry run",
+			since:        time.Hour,
+			dryRun:       true,
+			wantStatus:   models.AttemptStarting,
+			wantDeployed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := models.Attempt{
+				Token:         "starting",
+				CreatorName:   challenge.CreatorName,
+				ChallengeName: challenge.ChallengeName,
+				Status:        models.AttemptStarting,
+				ReleaseName:   "astarting",
+				Transitions: []models.AttemptTransition{
+					{From: models.AttemptCreated, To: models.AttemptStarting, At: time.Now().Add(-tt.since)},
+				},
+			}
+			m := &memStore{
+				challenges: []models.Challenge{challenge},
+				attempts:   []models.Attempt{attempt},
+			}
+			fake := deploy.NewFake()
+			release := releaseOf(&attempt)
+			deployRelease(t, fake, release)
+			if tt.inProgress {
+				t.Cleanup(markStarting(release))
+			}
+			useStore(t, m)
+			useDeployer(t, fake)
+
+			ctx := withAuditScope(context.Background(), auditScope{Actor: reconcileActor})
+			if err := Reconcile(ctx, grace, tt.dryRun); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			got := m.Attempt(attempt.Token)
+			if got.Status != tt.wantStatus {
+				t.Errorf("attempt status = %q, want %q", got.Status, tt.wantStatus)
+			}
+			if _, deployed := fake.Spec(release); deployed != tt.wantDeployed {
+				t.Errorf("release deployed = %v, want %v", deployed, tt.wantDeployed)
+			}
+			// failing the attempt is audited as the reconciler's doing
+			audited := false
+			for _, entry := range m.audit {
+				audited = audited || (entry.Kind == models.AuditTransition && entry.To == models.AttemptFailed && entry.Actor == reconcileActor)
+			}
+			if wantAudited := tt.wantStatus == models.AttemptFailed; audited != wantAudited {
+				t.Errorf("failure audited = %v, want %v", audited, wantAudited)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/gateway"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// SaveRecording stores a gateway or web terminal session.
+func SaveRecording(ctx context.Context, recording *gateway.Recording) error {
+	_, err := store.CreateRecording(&models.Recording{
+		Token:     recording.Token,
+		Session:   recording.Session,
+		Source:    recording.Source,
+		Command:   recording.Command,
+		StartedAt: recording.StartedAt,
+		EndedAt:   recording.EndedAt,
+		Truncated: recording.Truncated,
+		Cast:      string(recording.Cast),
+	})
+	return err
+}
+
+// ListRecordings publishes the session recordings of an attempt to its
+// challenge's creator once the attempt has ended.
+func ListRecordings(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	token, _ := data["token"].(string)
+	creatorName, _ := data["creatorName"].(string)
+
+	attempt, err := store.GetAttempt(token)
+	// attempts of other creators look the same as missing ones
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && attempt.CreatorName != creatorName) {
+		data["failureReason"] = "attemptNotFound"
+		data["failureMessage"] = fmt.Sprintf("no attempt %s in challenges of %s", token, creatorName)
+		publishEvent(ch, ctx, data, "attemptRecordingListFailed", routingKey)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to find attempt %s: %s", token, err)
+		publishEvent(ch, ctx, data, "attemptRecordingListFailed", routingKey)
+		return
+	}
+
+	challenge, err := store.GetChallenge(attempt.CreatorName, attempt.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to find challenge %s: %s", attempt.ChallengeName, err)
+		publishEvent(ch, ctx, data, "attemptRecordingListFailed", routingKey)
+		return
+	}
+	if !attemptEnded(&attempt, &challenge, time.Now()) {
+		data["failureReason"] = "attemptNotEnded"
+		data["failureMessage"] = "recordings are available once the attempt has ended"
+		publishEvent(ch, ctx, data, "attemptRecordingListFailed", routingKey)
+		return
+	}
+
+	recordings, err := store.ListRecordings(token)
+	if err != nil {
+		log.Printf("Failed to list recordings of %s: %s", token, err)
+		publishEvent(ch, ctx, data, "attemptRecordingListFailed", routingKey)
+		return
+	}
+
+	data["recordings"] = recordings
+	publishEvent(ch, ctx, data, "attemptRecordingListed", routingKey)
+}
+
+// attemptEnded reports whether an attempt is over: its state is final, it
+// was ended explicitly, its challenge's window closed or it has run longer
+// than the challenge's duration in minutes. Submitted attempts are over once
+// held for SUBMISSION_HOLD.
+func attemptEnded(attempt *models.Attempt, challenge *models.Challenge, now time.Time) bool {
+	status := models.AttemptStatus(attempt)
+	if models.AttemptOver(status) {
+		return true
+	}
+	// submitted environments are kept for grading, within limits
+	if status == models.AttemptSubmitted && attempt.EndedAt == nil {
+		return attempt.SubmittedAt != nil && !now.Before(attempt.SubmittedAt.Add(config.SUBMISSION_HOLD))
+	}
+	if challenge.Window(now) == models.WindowClosed {
+		return true
+	}
+	if attempt.EndedAt != nil {
+		return !now.Before(*attempt.EndedAt)
+	}
+	if attempt.StartedAt == nil || challenge.Duration <= 0 {
+		return false
+	}
+	deadline := attempt.StartedAt.Add(time.Duration(challenge.Duration) * time.Minute)
+	return !now.Before(deadline)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/models"
+)
+
+func TestAttemptEnded(t *testing.T) {
+	defer func(hold time.Duration) { config.SUBMISSION_HOLD = hold }(config.SUBMISSION_HOLD)
+	config.SUBMISSION_HOLD = time.Hour
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name      string
+		attempt   models.Attempt
+		challenge models.Challenge
+		want      bool
+	}{
+		{
+			name:    "not started",
+			attempt: models.Attempt{},
+			want:    false,
+		},
+		{
+			name:      "within its duration",
+			attempt:   models.Attempt{Status: models.AttemptRunning, StartedAt: at(-30 * time.Minute)},
+			challenge: models.Challenge{Duration: 60},
+			want:      false,
+		},
+		{
+			name:      "past its duration",
+			attempt:   models.Attempt{Status: models.AttemptRunning, StartedAt: at(-time.Hour)},
+			challenge: models.Challenge{Duration: 60},
+			want:      true,
+		},
+		{
+			name:    "without a duration",
+			attempt: models.Attempt{Status: models.AttemptRunning, StartedAt: at(-48 * time.Hour)},
+			want:    false,
+		},
+		{
+			name:    "final state",
+			attempt: models.Attempt{Status: models.AttemptStopped, StartedAt: at(-time.Minute)},
+			want:    true,
+		},
+		{
+			name:    "ended explicitly",
+			attempt: models.Attempt{Status: models.AttemptFailed, StartedAt: at(-time.Hour), EndedAt: at(-time.Minute)},
+			want:    true,
+		},
+		{
+			name:      "window closed",
+			attempt:   models.Attempt{Status: models.AttemptRunning, StartedAt: at(-time.Minute)},
+			challenge: models.Challenge{EndsAt: at(-time.Second)},
+			want:      true,
+		},
+		{
+			name:      "submitted and held",
+			attempt:   models.Attempt{Status: models.AttemptSubmitted, StartedAt: at(-2 * time.Hour), SubmittedAt: at(-30 * time.Minute)},
+			challenge: models.Challenge{Duration: 60},
+			want:      false,
+		},
+		{
+			name:    "submitted past the hold",
+			attempt: models.Attempt{Status: models.AttemptSubmitted, StartedAt: at(-2 * time.Hour), SubmittedAt: at(-time.Hour)},
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attemptEnded(&tt.attempt, &tt.challenge, now); got != tt.want {
+				t.Errorf("attemptEnded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
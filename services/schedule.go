@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"sys.io/challenge-service/models"
+)
+
+const scheduleActor = "system:scheduler"
+
+// RunScheduler closes challenges whose window ended, checking every
+// interval until the process exits.
+func RunScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := withAuditScope(context.Background(), auditScope{Actor: scheduleActor})
+		if err := CloseChallenges(ctx, time.Now()); err != nil {
+			log.Printf("Closing challenges failed: %s", err)
+		}
+	}
+}
+
+// CloseChallenges tears down the attempts of every challenge whose window
+// ended by now. Challenges with attempts left running are retried on the
+// next call.
+func CloseChallenges(ctx context.Context, now time.Time) error {
+	challenges, err := store.ListClosingChallenges(now)
+	if err != nil {
+		return err
+	}
+
+	for i := range challenges {
+		challenge := &challenges[i]
+		challengeCtx := withAuditScope(ctx, auditScope{
+			CreatorName:   challenge.CreatorName,
+			ChallengeName: challenge.ChallengeName,
+			Actor:         scheduleActor,
+		})
+		if err := closeChallenge(challengeCtx, challenge); err != nil {
+			log.Printf("Failed to close challenge %s: %s", challenge.ChallengeName, err)
+			continue
+		}
+		if err := store.CloseChallenge(challenge.CreatorName, challenge.ChallengeName, now); err != nil {
+			log.Printf("Failed to close challenge %s: %s", challenge.ChallengeName, err)
+			continue
+		}
+		audit(challengeCtx, models.AuditEntry{Kind: models.AuditAction, Action: "close challenge"})
+		log.Printf("Challenge %s closed", challenge.ChallengeName)
+	}
+	return nil
+}
+
+// closeChallenge expires the attempts of a challenge, tearing down running
+// and starting ones, and empties its warm pool. Submitted attempts keep
+// their state for results to be recorded, their environments are removed
+// unless a grader is still at work on them.
+func closeChallenge(ctx context.Context, challenge *models.Challenge) error {
+	deployer, err := deployerFor(challenge)
+	if err != nil {
+		return err
+	}
+	attempts, err := store.ListAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i := range attempts {
+		attempt := &attempts[i]
+		attemptCtx := withAttempt(ctx, attempt)
+
+		switch status := models.AttemptStatus(attempt); {
+		case status == models.AttemptRunning:
+			release := releaseOf(attempt)
+			if err := deployer.Teardown(attemptCtx, release); err != nil {
+				log.Printf("Failed to tear down challenge %s: %s", release.Name, err)
+				failed++
+				continue
+			}
+			endAttempt(attemptCtx, attempt, models.AttemptExpired, time.Now())
+		case status == models.AttemptStarting:
+			if err := abandonAttempt(attemptCtx, attempt); err != nil {
+				log.Printf("Failed to abandon attempt %s: %s", attempt.Token, err)
+				failed++
+				continue
+			}
+			transitionAttempt(attemptCtx, attempt.Token, models.AttemptExpired)
+		case status == models.AttemptSubmitted && attempt.EndedAt == nil:
+			// the grader removes the environment once it is done
+			if _, busy := gradingAttempts.Load(attempt.Token); busy {
+				continue
+			}
+			release := releaseOf(attempt)
+			if err := deployer.Teardown(attemptCtx, release); err != nil {
+				log.Printf("Failed to tear down challenge %s: %s", release.Name, err)
+				failed++
+				continue
+			}
+			endAttempt(attemptCtx, attempt, models.AttemptSubmitted, time.Now())
+		case models.CanTransition(status, models.AttemptExpired):
+			transitionAttempt(attemptCtx, attempt.Token, models.AttemptExpired)
+		}
+	}
+
+	instances, err := store.ListPoolInstances(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		discardPoolInstance(deployer, instance)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d attempts could not be torn down", failed)
+	}
+	return nil
+}
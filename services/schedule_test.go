@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestCloseChallenge(t *testing.T) {
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake"}
+	startedAt := time.Now().Add(-10 * time.Minute)
+
+	tests := []struct {
+		name    string
+		attempt models.Attempt
+		// graded attempts have a grader running
+		graded bool
+
+		wantStatus   string
+		wantDeployed bool
+		wantEnded    bool
+	}{
+		{
+			name:       "running",
+			attempt:    models.Attempt{Token: "running", Status: models.AttemptRunning, StartedAt: &startedAt},
+			wantStatus: models.AttemptExpired,
+			wantEnded:  true,
+		},
+		{
+			name:       "starting",
+			attempt:    models.Attempt{Token: "starting", Status: models.AttemptStarting},
+			wantStatus: models.AttemptExpired,
+			wantEnded:  true,
+		},
+		{
+			name:       "submitted",
+			attempt:    models.Attempt{Token: "submitted", Status: models.AttemptSubmitted, StartedAt: &startedAt, SubmittedAt: &startedAt},
+			wantStatus: models.AttemptSubmitted,
+			wantEnded:  true,
+		},
+		{
+			name:         "being graded",
+			attempt:      models.Attempt{Token: "grading", Status: models.AttemptSubmitted, StartedAt: &startedAt, SubmittedAt: &startedAt},
+			graded:       true,
+			wantStatus:   models.AttemptSubmitted,
+			wantDeployed: true,
+		},
+		{
+			name:       "never started",
+			attempt:    models.Attempt{Token: "created"},
+			wantStatus: models.AttemptExpired,
+		},
+	}
+
+	m := &memStore{challenges: []models.Challenge{challenge}}
+	fake := deploy.NewFake()
+	for _, tt := range tests {
+		attempt := tt.attempt
+		attempt.CreatorName = challenge.CreatorName
+		attempt.ChallengeName = challenge.ChallengeName
+		m.attempts = append(m.attempts, attempt)
+		if attempt.Status != "" {
+			deployRelease(t, fake, releaseOf(&attempt))
+		}
+		if tt.graded {
+			gradingAttempts.Store(attempt.Token, struct{}{})
+			t.Cleanup(func() { gradingAttempts.Delete(attempt.Token) })
+		}
+	}
+	useStore(t, m)
+	useDeployer(t, fake)
+	useEvents(t)
+
+	if err := closeChallenge(context.Background(), &challenge); err != nil {
+		t.Fatalf("closeChallenge() error = %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := m.Attempt(tt.attempt.Token)
+			if attempt.Status != tt.wantStatus {
+				t.Errorf("attempt status = %q, want %q", attempt.Status, tt.wantStatus)
+			}
+			if _, deployed := fake.Spec(releaseOf(&attempt)); deployed != tt.wantDeployed {
+				t.Errorf("release deployed = %v, want %v", deployed, tt.wantDeployed)
+			}
+			if ended := attempt.EndedAt != nil; ended != tt.wantEnded {
+				t.Errorf("ended = %v, want %v", ended, tt.wantEnded)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/collections"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// rejectCommand answers a command for an attempt that does not exist or
+// whose state does not allow it.
+func rejectCommand(ch eventChannel, ctx context.Context, data map[string]interface{}, err error, eventStatus string, routingKey string) {
+	log.Printf("Rejected %s: %s", eventStatus, err)
+
+	var transitionErr *collections.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		data["failureReason"] = "invalidState"
+		data["failureMessage"] = err.Error()
+		data["status"] = transitionErr.From
+	case errors.Is(err, mongo.ErrNoDocuments):
+		data["failureReason"] = "attemptNotFound"
+	}
+	publishEvent(ch, ctx, data, eventStatus, routingKey)
+}
+
+// moveAttempt transitions an attempt and audits the transition.
+func moveAttempt(ctx context.Context, token, to string) (models.Attempt, error) {
+	attempt, err := store.TransitionAttempt(token, to)
+	if err != nil {
+		return attempt, err
+	}
+	auditTransition(ctx, &attempt)
+	return attempt, nil
+}
+
+// beginAttempt moves an attempt to starting on release and audits the
+// transition.
+func beginAttempt(ctx context.Context, token string, release deploy.Release) (models.Attempt, error) {
+	attempt, err := store.BeginAttempt(token, release.Name, release.Namespace)
+	if err != nil {
+		return attempt, err
+	}
+	auditTransition(ctx, &attempt)
+	return attempt, nil
+}
+
+// auditTransition records the transition an attempt went through last.
+func auditTransition(ctx context.Context, attempt *models.Attempt) {
+	transition := attempt.Transitions[len(attempt.Transitions)-1]
+	audit(withAttempt(ctx, attempt), models.AuditEntry{
+		Kind:   models.AuditTransition,
+		Action: transition.To,
+		From:   transition.From,
+		To:     transition.To,
+	})
+}
+
+// transitionAttempt moves an attempt on after the fact, failures are only
+// logged since the action they record has already happened.
+func transitionAttempt(ctx context.Context, token, to string) {
+	if _, err := moveAttempt(ctx, token, to); err != nil {
+		log.Printf("Failed to move attempt %s to %s: %s", token, to, err)
+	}
+}
+
+// StopChallenge tears down a running attempt's environment.
+func StopChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	token, _ := data["token"].(string)
+	if err := stopAttempt(ctx, token); err != nil {
+		rejectCommand(ch, ctx, data, err, "challengeStopFailed", routingKey)
+		return
+	}
+
+	publishEvent(ch, ctx, data, "challengeStopped", routingKey)
+}
+
+// stopAttempt tears down a running attempt's release and ends the attempt.
+// Attempts that cannot be stopped are left as they are, the others end up
+// stopped or failed.
+func stopAttempt(ctx context.Context, token string) error {
+	attempt, err := moveAttempt(ctx, token, models.AttemptStopping)
+	if err != nil {
+		return err
+	}
+
+	if err := teardownAttempt(ctx, &attempt); err != nil {
+		transitionAttempt(ctx, token, models.AttemptFailed)
+		return err
+	}
+
+	transitionAttempt(ctx, token, models.AttemptStopped)
+	if err := store.EndAttempt(token, time.Now()); err != nil {
+		log.Printf("Failed to end attempt %s: %s", token, err)
+	}
+
+	log.Printf("Challenge %s stopped ...", releaseOf(&attempt).Name)
+	return nil
+}
+
+// teardownAttempt uninstalls the release an attempt runs on.
+func teardownAttempt(ctx context.Context, attempt *models.Attempt) error {
+	challenge, err := store.GetChallenge(attempt.CreatorName, attempt.ChallengeName)
+	if err != nil {
+		return fmt.Errorf("failed to find challenge %s: %v", attempt.ChallengeName, err)
+	}
+	deployer, err := deployerFor(&challenge)
+	if err != nil {
+		return err
+	}
+
+	release := releaseOf(attempt)
+	if err := deployer.Teardown(ctx, release); err != nil {
+		return fmt.Errorf("failed to tear down challenge %s: %w", release.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestStopChallenge(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	running := models.Attempt{
+		Token:         "running",
+		CreatorName:   "creator",
+		ChallengeName: "ctf",
+		Status:        models.AttemptRunning,
+		StartedAt:     &startedAt,
+	}
+	created := models.Attempt{Token: "created", CreatorName: "creator", ChallengeName: "ctf"}
+
+	tests := []struct {
+		name        string
+		token       string
+		teardownErr error
+
+		wantEvent       string
+		wantReason      string
+		wantStatus      string
+		wantTransitions []string
+		wantDeployed    bool
+	}{
+		{
+			name:            "running",
+			token:           running.Token,
+			wantEvent:       "challengeStopped",
+			wantStatus:      models.AttemptStopped,
+			wantTransitions: []string{models.AttemptStopping, models.AttemptStopped},
+		},
+		{
+			name:            "teardown fails",
+			token:           running.Token,
+			teardownErr:     errors.New("cluster unreachable"),
+			wantEvent:       "challengeStopFailed",
+			wantStatus:      models.AttemptFailed,
+			wantTransitions: []string{models.AttemptStopping, models.AttemptFailed},
+			wantDeployed:    true,
+		},
+		{
+			name:         "never started",
+			token:        created.Token,
+			wantEvent:    "challengeStopFailed",
+			wantReason:   "invalidState",
+			wantStatus:   models.AttemptCreated,
+			wantDeployed: true,
+		},
+		{
+			name:         "unknown",
+			token:        "unknown",
+			wantEvent:    "challengeStopFailed",
+			wantReason:   "attemptNotFound",
+			wantDeployed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &memStore{
+				challenges: []models.Challenge{{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake"}},
+				attempts:   []models.Attempt{running, created},
+			}
+			fake := deploy.NewFake()
+			fake.TeardownErr = tt.teardownErr
+			deployRelease(t, fake, releaseOf(&running))
+			useStore(t, m)
+			useDeployer(t, fake)
+			events := useEvents(t)
+
+			msg, _ := json.Marshal(map[string]interface{}{"token": tt.token})
+			StopChallenge(nil, context.Background(), msg, "challengeStopped")
+
+			event := events.Last()
+			if event["eventStatus"] != tt.wantEvent {
+				t.Fatalf("events = %v, want %s", events.Statuses(), tt.wantEvent)
+			}
+			if reason, _ := event["failureReason"].(string); reason != tt.wantReason {
+				t.Errorf("failureReason = %q, want %q", reason, tt.wantReason)
+			}
+			if tt.wantReason == "invalidState" && event["status"] != tt.wantStatus {
+				t.Errorf("status = %v, want the current %s", event["status"], tt.wantStatus)
+			}
+
+			attempt := m.Attempt(tt.token)
+			if tt.wantStatus != "" && models.AttemptStatus(&attempt) != tt.wantStatus {
+				t.Errorf("attempt %s, want %s", models.AttemptStatus(&attempt), tt.wantStatus)
+			}
+			var transitions []string
+			for _, transition := range attempt.Transitions {
+				transitions = append(transitions, transition.To)
+			}
+			if !reflect.DeepEqual(transitions, tt.wantTransitions) {
+				t.Errorf("transitions = %v, want %v", transitions, tt.wantTransitions)
+			}
+			if _, deployed := fake.Spec(releaseOf(&running)); deployed != tt.wantDeployed {
+				t.Errorf("release deployed = %v, want %v", deployed, tt.wantDeployed)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/collections"
+	"sys.io/challenge-service/models"
+)
+
+// store is how the services reach the database, its fields default to the
+// collections functions and are swapped out by tests.
+var store = struct {
+	// attempts
+	CreateAttempt             func(attempt *models.Attempt) (*mongo.InsertOneResult, error)
+	GetAttempt                func(token string) (models.Attempt, error)
+	UpdateAttempt             func(attempt *models.Attempt) (*models.Attempt, error)
+	CountUnstartedAttempts    func(creatorName, challengeName string) (int64, error)
+	ListAttempts              func(creatorName, challengeName string) ([]models.Attempt, error)
+	DeleteAttempt             func(token string) error
+	DeleteAttempts            func(creatorName, challengeName string) (int64, error)
+	SetUnstartedAttemptsImage func(creatorName, challengeName, imageRegistryLink string) error
+	ListStartedAttempts       func() ([]models.Attempt, error)
+	ListStartingAttempts      func() ([]models.Attempt, error)
+	EndAttempt                func(token string, endedAt time.Time) error
+	SubmitAttempt             func(token string, submittedAt time.Time) (models.Attempt, error)
+	GradeAttempt              func(token string, result float64, feedback string, checks []models.GradeCheck, gradedAt time.Time) (models.Attempt, error)
+	TransitionAttempt         func(token, to string) (models.Attempt, error)
+	BeginAttempt              func(token, releaseName, namespace string) (models.Attempt, error)
+	SetAttemptRelease         func(token, releaseName, namespace string) error
+
+	// audit log
+	CreateAuditEntry func(entry *models.AuditEntry) (*mongo.InsertOneResult, error)
+	ListAuditEntries func(query models.AuditQuery) ([]models.AuditEntry, error)
+
+	// challenges
+	CreateChallenge             func(challenge *models.Challenge) (*mongo.InsertOneResult, error)
+	GetChallenge                func(creatorName, challengeName string) (models.Challenge, error)
+	UpdateChallenge             func(challenge *models.Challenge) error
+	DeleteChallenge             func(creatorName, challengeName string) error
+	ArchiveChallenge            func(creatorName, challengeName string, archivedAt time.Time) error
+	ListClosingChallenges       func(now time.Time) ([]models.Challenge, error)
+	CloseChallenge              func(creatorName, challengeName string, closedAt time.Time) error
+	AddChallengeParticipants    func(creatorName, challengeName string, participants []string) error
+	RemoveChallengeParticipants func(creatorName, challengeName string, participants []string) error
+	ListPoolChallenges          func() ([]models.Challenge, error)
+
+	// images
+	GetImage          func(creatorName, imageName, imageTag string) (models.Image, error)
+	ListImageVersions func(creatorName, imageName string) ([]models.ImageVersion, error)
+	ListImages        func(creatorName string) ([]models.ImageCatalogEntry, error)
+
+	// warm pools
+	CreatePoolInstance    func(instance *models.PoolInstance) (*mongo.InsertOneResult, error)
+	MarkPoolInstanceReady func(release string) error
+	TakePoolInstance      func(creatorName, challengeName string, revision int) (models.PoolInstance, error)
+	TakeStalePoolInstance func(creatorName, challengeName string, revision int) (models.PoolInstance, error)
+	ListPoolInstances     func(creatorName, challengeName string) ([]models.PoolInstance, error)
+	ListAllPoolInstances  func() ([]models.PoolInstance, error)
+	DeletePoolInstance    func(release string) error
+
+	// recordings
+	CreateRecording  func(recording *models.Recording) (*mongo.InsertOneResult, error)
+	ListRecordings   func(token string) ([]models.Recording, error)
+	DeleteRecordings func(tokens []string) (int64, error)
+}{
+	CreateAttempt:               collections.CreateAttempt,
+	GetAttempt:                  collections.GetAttempt,
+	UpdateAttempt:               collections.UpdateAttempt,
+	CountUnstartedAttempts:      collections.CountUnstartedAttempts,
+	ListAttempts:                collections.ListAttempts,
+	DeleteAttempt:               collections.DeleteAttempt,
+	DeleteAttempts:              collections.DeleteAttempts,
+	SetUnstartedAttemptsImage:   collections.SetUnstartedAttemptsImage,
+	ListStartedAttempts:         collections.ListStartedAttempts,
+	ListStartingAttempts:        collections.ListStartingAttempts,
+	EndAttempt:                  collections.EndAttempt,
+	SubmitAttempt:               collections.SubmitAttempt,
+	GradeAttempt:                collections.GradeAttempt,
+	TransitionAttempt:           collections.TransitionAttempt,
+	BeginAttempt:                collections.BeginAttempt,
+	SetAttemptRelease:           collections.SetAttemptRelease,
+	CreateAuditEntry:            collections.CreateAuditEntry,
+	ListAuditEntries:            collections.ListAuditEntries,
+	CreateChallenge:             collections.CreateChallenge,
+	GetChallenge:                collections.GetChallenge,
+	UpdateChallenge:             collections.UpdateChallenge,
+	DeleteChallenge:             collections.DeleteChallenge,
+	ArchiveChallenge:            collections.ArchiveChallenge,
+	ListClosingChallenges:       collections.ListClosingChallenges,
+	CloseChallenge:              collections.CloseChallenge,
+	AddChallengeParticipants:    collections.AddChallengeParticipants,
+	RemoveChallengeParticipants: collections.RemoveChallengeParticipants,
+	ListPoolChallenges:          collections.ListPoolChallenges,
+	GetImage:                    collections.GetImage,
+	ListImageVersions:           collections.ListImageVersions,
+	ListImages:                  collections.ListImages,
+	CreatePoolInstance:          collections.CreatePoolInstance,
+	MarkPoolInstanceReady:       collections.MarkPoolInstanceReady,
+	TakePoolInstance:            collections.TakePoolInstance,
+	TakeStalePoolInstance:       collections.TakeStalePoolInstance,
+	ListPoolInstances:           collections.ListPoolInstances,
+	ListAllPoolInstances:        collections.ListAllPoolInstances,
+	DeletePoolInstance:          collections.DeletePoolInstance,
+	CreateRecording:             collections.CreateRecording,
+	ListRecordings:              collections.ListRecordings,
+	DeleteRecordings:            collections.DeleteRecordings,
+}
@@ -0,0 +1,513 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/client-go/kubernetes"
+	"sys.io/challenge-service/collections"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+// memStore keeps the records the services work on in memory.
+type memStore struct {
+	mu         sync.Mutex
+	challenges []models.Challenge
+	attempts   []models.Attempt
+	instances  []models.PoolInstance
+	audit      []models.AuditEntry
+
+	// challengeErr, when set, is returned by every challenge lookup
+	challengeErr error
+	// attemptErrs fail creating the attempts of the participants they name
+	attemptErrs map[string]error
+}
+
+// useStore points the services at m until the test ends.
+func useStore(t *testing.T, m *memStore) {
+	saved := store
+	t.Cleanup(func() { store = saved })
+
+	store.GetChallenge = m.getChallenge
+	store.GetAttempt = m.getAttempt
+	store.CreateAttempt = m.createAttempt
+	store.DeleteAttempt = m.deleteAttempt
+	store.UpdateChallenge = m.updateChallenge
+	store.AddChallengeParticipants = m.addChallengeParticipants
+	store.RemoveChallengeParticipants = m.removeChallengeParticipants
+	store.ListAttempts = m.listAttempts
+	store.ListStartedAttempts = m.listStartedAttempts
+	store.ListStartingAttempts = m.listStartingAttempts
+	store.TransitionAttempt = m.transitionAttempt
+	store.BeginAttempt = m.beginAttempt
+	store.SetAttemptRelease = m.setAttemptRelease
+	store.EndAttempt = m.endAttempt
+	store.UpdateAttempt = m.updateAttempt
+	store.SubmitAttempt = m.submitAttempt
+	store.GradeAttempt = m.gradeAttempt
+	store.ListPoolInstances = m.listPoolInstances
+	store.ListAllPoolInstances = m.listAllPoolInstances
+	store.TakePoolInstance = m.takePoolInstance
+	store.CreatePoolInstance = m.createPoolInstance
+	store.DeletePoolInstance = m.deletePoolInstance
+	store.CreateAuditEntry = m.createAuditEntry
+	store.ListAuditEntries = m.listAuditEntries
+}
+
+// eventLog collects the events the services publish.
+type eventLog struct {
+	mu       sync.Mutex
+	events   []map[string]interface{}
+	channels []eventChannel
+}
+
+// useEvents captures published events until the test ends.
+func useEvents(t *testing.T) *eventLog {
+	saved := publish
+	t.Cleanup(func() { publish = saved })
+
+	log := &eventLog{}
+	publish = func(ch eventChannel, ctx context.Context, msg []byte, routingKey string) error {
+		var event map[string]interface{}
+		if err := json.Unmarshal(msg, &event); err != nil {
+			t.Errorf("published invalid JSON %s: %v", msg, err)
+		}
+		event["routingKey"] = routingKey
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		log.events = append(log.events, event)
+		log.channels = append(log.channels, ch)
+		return nil
+	}
+	return log
+}
+
+// Statuses returns the eventStatus of every event published so far.
+func (l *eventLog) Statuses() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := []string{}
+	for _, event := range l.events {
+		status, _ := event["eventStatus"].(string)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// PublishedOn returns the eventStatus of every event published on ch.
+func (l *eventLog) PublishedOn(ch eventChannel) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := []string{}
+	for i, event := range l.events {
+		if l.channels[i] == ch {
+			status, _ := event["eventStatus"].(string)
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// usePublisher sets the publisher of background events until the test ends.
+func usePublisher(t *testing.T, p eventChannel) {
+	saved := publisher
+	t.Cleanup(func() { publisher = saved })
+	publisher = p
+}
+
+// Last returns the event published last.
+func (l *eventLog) Last() map[string]interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.events) == 0 {
+		return nil
+	}
+	return l.events[len(l.events)-1]
+}
+
+// useKube points the services at a cluster client until the test ends.
+func useKube(t *testing.T, kube kubernetes.Interface) {
+	saved := kubeClient
+	t.Cleanup(func() { kubeClient = saved })
+	kubeClient = kube
+}
+
+// useDeployer registers d as the deployer named "fake" until the test ends.
+func useDeployer(t *testing.T, d deploy.Deployer) {
+	saved := deployers
+	deployers = map[string]deploy.Deployer{}
+	t.Cleanup(func() { deployers = saved })
+	SetDeployer("fake", d)
+}
+
+func (m *memStore) getChallenge(creatorName, challengeName string) (models.Challenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.challengeErr != nil {
+		return models.Challenge{}, m.challengeErr
+	}
+	for _, challenge := range m.challenges {
+		if challenge.CreatorName == creatorName && challenge.ChallengeName == challengeName {
+			return challenge, nil
+		}
+	}
+	return models.Challenge{}, mongo.ErrNoDocuments
+}
+
+func (m *memStore) updateChallenge(update *models.Challenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.challenges {
+		if m.challenges[i].CreatorName == update.CreatorName && m.challenges[i].ChallengeName == update.ChallengeName {
+			m.challenges[i] = *update
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (m *memStore) addChallengeParticipants(creatorName, challengeName string, participants []string) error {
+	return m.changeParticipants(creatorName, challengeName, func(listed []string) []string {
+		for _, participant := range participants {
+			if !contains(listed, participant) {
+				listed = append(listed, participant)
+			}
+		}
+		return listed
+	})
+}
+
+func (m *memStore) removeChallengeParticipants(creatorName, challengeName string, participants []string) error {
+	return m.changeParticipants(creatorName, challengeName, func(listed []string) []string {
+		kept := []string{}
+		for _, participant := range listed {
+			if !contains(participants, participant) {
+				kept = append(kept, participant)
+			}
+		}
+		return kept
+	})
+}
+
+func (m *memStore) changeParticipants(creatorName, challengeName string, change func([]string) []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.challenges {
+		if m.challenges[i].CreatorName == creatorName && m.challenges[i].ChallengeName == challengeName {
+			m.challenges[i].Participants = change(m.challenges[i].Participants)
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+// Challenge returns the stored copy of a challenge.
+func (m *memStore) Challenge(creatorName, challengeName string) models.Challenge {
+	challenge, _ := m.getChallenge(creatorName, challengeName)
+	return challenge
+}
+
+func (m *memStore) createAttempt(attempt *models.Attempt) (*mongo.InsertOneResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.attemptErrs[attempt.Participant]; err != nil {
+		return nil, err
+	}
+	m.attempts = append(m.attempts, *attempt)
+	return &mongo.InsertOneResult{}, nil
+}
+
+func (m *memStore) deleteAttempt(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.attempts {
+		if m.attempts[i].Token == token {
+			m.attempts = append(m.attempts[:i], m.attempts[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memStore) attempt(token string) *models.Attempt {
+	for i := range m.attempts {
+		if m.attempts[i].Token == token {
+			return &m.attempts[i]
+		}
+	}
+	return nil
+}
+
+// Attempt returns the stored copy of an attempt.
+func (m *memStore) Attempt(token string) models.Attempt {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempt := m.attempt(token); attempt != nil {
+		return *attempt
+	}
+	return models.Attempt{}
+}
+
+func (m *memStore) getAttempt(token string) (models.Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempt := m.attempt(token); attempt != nil {
+		return *attempt, nil
+	}
+	return models.Attempt{}, mongo.ErrNoDocuments
+}
+
+func (m *memStore) listAttempts(creatorName, challengeName string) ([]models.Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts := []models.Attempt{}
+	for _, attempt := range m.attempts {
+		if attempt.CreatorName == creatorName && attempt.ChallengeName == challengeName {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (m *memStore) listStartedAttempts() ([]models.Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts := []models.Attempt{}
+	for _, attempt := range m.attempts {
+		if attempt.StartedAt != nil {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (m *memStore) listStartingAttempts() ([]models.Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts := []models.Attempt{}
+	for _, attempt := range m.attempts {
+		if attempt.Status == models.AttemptStarting {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (m *memStore) transitionAttempt(token, to string) (models.Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.attempt(token)
+	if attempt == nil {
+		return models.Attempt{}, mongo.ErrNoDocuments
+	}
+	from := models.AttemptStatus(attempt)
+	if !models.CanTransition(from, to) {
+		return *attempt, &collections.TransitionError{Token: token, From: from, To: to}
+	}
+	attempt.Status = to
+	attempt.Transitions = append(attempt.Transitions, models.AttemptTransition{From: from, To: to, At: time.Now()})
+	return *attempt, nil
+}
+
+// beginAttempt moves an attempt to starting the way the collection does.
+func (m *memStore) beginAttempt(token, releaseName, namespace string) (models.Attempt, error) {
+	attempt, err := m.transitionAttempt(token, models.AttemptStarting)
+	if err != nil {
+		return attempt, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.attempt(token)
+	stored.ReleaseName = releaseName
+	stored.Namespace = namespace
+	stored.StartedAt = nil
+	stored.EndedAt = nil
+	stored.SubmittedAt = nil
+	return *stored, nil
+}
+
+func (m *memStore) setAttemptRelease(token, releaseName, namespace string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.attempt(token)
+	if attempt == nil || attempt.Status != models.AttemptStarting {
+		return mongo.ErrNoDocuments
+	}
+	attempt.ReleaseName = releaseName
+	attempt.Namespace = namespace
+	return nil
+}
+
+func (m *memStore) endAttempt(token string, endedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempt := m.attempt(token); attempt != nil {
+		attempt.EndedAt = &endedAt
+		attempt.Ipaddress = ""
+		attempt.Port = ""
+	}
+	return nil
+}
+
+// updateAttempt applies the start fields the way the collection does.
+func (m *memStore) updateAttempt(update *models.Attempt) (*models.Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.attempt(update.Token)
+	if attempt == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	attempt.Ipaddress = update.Ipaddress
+	attempt.Port = update.Port
+	attempt.Sshkey = update.Sshkey
+	attempt.Namespace = update.Namespace
+	attempt.StartedAt = update.StartedAt
+	attempt.ReleaseName = update.ReleaseName
+	attempt.EndedAt = nil
+	attempt.SubmittedAt = nil
+	updated := *attempt
+	return &updated, nil
+}
+
+// submitAttempt moves an attempt to submitted the way the collection does.
+func (m *memStore) submitAttempt(token string, submittedAt time.Time) (models.Attempt, error) {
+	if attempt, err := m.transitionAttempt(token, models.AttemptSubmitted); err != nil {
+		return attempt, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.attempt(token)
+	attempt.SubmittedAt = &submittedAt
+	attempt.Ipaddress = ""
+	attempt.Port = ""
+	return *attempt, nil
+}
+
+// gradeAttempt moves an attempt to graded the way the collection does.
+func (m *memStore) gradeAttempt(token string, result float64, feedback string, checks []models.GradeCheck, gradedAt time.Time) (models.Attempt, error) {
+	if attempt, err := m.transitionAttempt(token, models.AttemptGraded); err != nil {
+		return attempt, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.attempt(token)
+	attempt.Result = result
+	attempt.Feedback = feedback
+	attempt.Checks = checks
+	attempt.GradedAt = &gradedAt
+	return *attempt, nil
+}
+
+func (m *memStore) listPoolInstances(creatorName, challengeName string) ([]models.PoolInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instances := []models.PoolInstance{}
+	for _, instance := range m.instances {
+		if instance.CreatorName == creatorName && instance.ChallengeName == challengeName {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+func (m *memStore) listAllPoolInstances() ([]models.PoolInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]models.PoolInstance{}, m.instances...), nil
+}
+
+func (m *memStore) createPoolInstance(instance *models.PoolInstance) (*mongo.InsertOneResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.instances = append(m.instances, *instance)
+	return &mongo.InsertOneResult{}, nil
+}
+
+func (m *memStore) takePoolInstance(creatorName, challengeName string, revision int) (models.PoolInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, instance := range m.instances {
+		if instance.CreatorName == creatorName && instance.ChallengeName == challengeName &&
+			instance.Status == models.PoolReady && instance.Revision == revision {
+			m.instances = append(m.instances[:i], m.instances[i+1:]...)
+			return instance, nil
+		}
+	}
+	return models.PoolInstance{}, mongo.ErrNoDocuments
+}
+
+func (m *memStore) deletePoolInstance(release string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, instance := range m.instances {
+		if instance.Release == release {
+			m.instances = append(m.instances[:i], m.instances[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memStore) createAuditEntry(entry *models.AuditEntry) (*mongo.InsertOneResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.audit = append(m.audit, *entry)
+	return &mongo.InsertOneResult{}, nil
+}
+
+func (m *memStore) listAuditEntries(query models.AuditQuery) ([]models.AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []models.AuditEntry{}
+	for _, entry := range m.audit {
+		if (query.Token != "" && entry.Token != query.Token) ||
+			(query.CreatorName != "" && entry.CreatorName != query.CreatorName) ||
+			(query.ChallengeName != "" && entry.ChallengeName != query.ChallengeName) ||
+			(!query.Since.IsZero() && entry.At.Before(query.Since)) ||
+			(!query.Until.IsZero() && entry.At.After(query.Until)) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.After(entries[j].At) })
+	if query.Limit > 0 && int64(len(entries)) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+	return entries, nil
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"sys.io/challenge-service/config"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// SubmitAttempt records a participant's submission. The participant's
+// sessions are closed and the environment is frozen for grading or torn
+// down, as the challenge asks, and graded in the background if the
+// challenge has a grader.
+func SubmitAttempt(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	// the submission time is stored with the transition, recordings and
+	// frozen environments end by it
+	token, _ := data["token"].(string)
+	submittedAt := time.Now()
+	attempt, err := store.SubmitAttempt(token, submittedAt)
+	if err != nil {
+		rejectCommand(ch, ctx, data, err, "attemptSubmitFailed", routingKey)
+		return
+	}
+	auditTransition(ctx, &attempt)
+	data["submittedAt"] = submittedAt
+
+	if closed := sessions.Close(token); closed > 0 {
+		log.Printf("Closed %d sessions of submitted attempt %s", closed, token)
+	}
+
+	challenge, err := store.GetChallenge(attempt.CreatorName, attempt.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to find challenge %s: %s", attempt.ChallengeName, err)
+	}
+	if err == nil && challenge.Grader == nil && challenge.OnSubmit == models.SubmitTeardown {
+		endAttemptEnvironment(ctx, &attempt)
+	} else {
+		freezeAttempt(ctx, &attempt)
+	}
+
+	log.Printf("Attempt %s submitted", token)
+	publishEvent(ch, ctx, data, "attemptSubmitted", routingKey)
+
+	if err == nil && challenge.Grader != nil {
+		// graders run for minutes, the consumer must not wait for them
+		grading.Add(1)
+		gradingAttempts.Store(token, struct{}{})
+		go func() {
+			defer grading.Done()
+			defer gradingAttempts.Delete(token)
+			slots := graderSlots()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			runGrader(ctx, &challenge, attempt, data)
+		}()
+	}
+}
+
+var (
+	gradersOnce sync.Once
+	graders     chan struct{}
+	// grading counts the graders running in the background
+	grading sync.WaitGroup
+	// gradingAttempts holds the tokens of the attempts being graded
+	gradingAttempts sync.Map
+)
+
+// graderSlots bounds how many graders run at once to GRADER_CONCURRENCY.
+func graderSlots() chan struct{} {
+	gradersOnce.Do(func() {
+		concurrency := config.GRADER_CONCURRENCY
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		graders = make(chan struct{}, concurrency)
+	})
+	return graders
+}
+
+// gradeRelease runs a grader in a release, a var so tests can replace it.
+var gradeRelease = deploy.Grade
+
+// how often and how far apart the outcome of a grader is published again
+// while the broker is away
+var (
+	gradePublishRetries = 5
+	gradePublishBackoff = 5 * time.Second
+)
+
+// runGrader grades a submitted attempt with the challenge's grader and
+// publishes the outcome as attemptGraded or attemptGradeFailed. Attempts
+// the grader fails on keep their environment for a result to be recorded
+// by hand, unless the challenge tears it down.
+func runGrader(ctx context.Context, challenge *models.Challenge, attempt models.Attempt, data map[string]interface{}) {
+	event := attemptEvent(&attempt, data)
+	timeout := config.GRADER_TIMEOUT
+	if challenge.Grader.Timeout > 0 {
+		timeout = time.Duration(challenge.Grader.Timeout) * time.Second
+	}
+
+	graded, err := gradeRelease(ctx, restConfig, kubeClient, releaseOf(&attempt), deploy.Grader{
+		Command: challenge.Grader.Command,
+		Image:   challenge.Grader.Image,
+		Timeout: timeout,
+		Env:     []deploy.EnvVar{{Name: "ATTEMPT_TOKEN", Value: attempt.Token}},
+	})
+	if err == nil {
+		checks := make([]models.GradeCheck, len(graded.Checks))
+		for i, check := range graded.Checks {
+			checks[i] = models.GradeCheck(check)
+		}
+		_, err = gradeAttempt(ctx, attempt.Token, graded.Score, graded.Feedback, checks)
+		if err == nil {
+			event["result"] = graded.Score
+			event["feedback"] = graded.Feedback
+			event["checks"] = checks
+			publishGrade(ctx, event, "attemptGraded")
+			return
+		}
+	}
+
+	log.Printf("Failed to grade attempt %s: %s", attempt.Token, err)
+	if challenge.OnSubmit == models.SubmitTeardown {
+		endAttemptEnvironment(ctx, &attempt)
+	}
+	event["failureReason"] = "graderFailed"
+	event["failureMessage"] = err.Error()
+	publishGrade(ctx, event, "attemptGradeFailed")
+}
+
+// publishGrade publishes the outcome of a grader on the publisher, the
+// consumer's channel of the submission may be gone by now. The result is
+// stored already, failed publishes are retried with a growing backoff.
+func publishGrade(ctx context.Context, event map[string]interface{}, eventStatus string) {
+	backoff := gradePublishBackoff
+	for retry := 0; ; retry++ {
+		err := publishEvent(publisher, ctx, event, eventStatus, "attemptGraded")
+		if err == nil {
+			return
+		}
+		if retry == gradePublishRetries {
+			log.Printf("Gave up publishing %s of %s: %s", eventStatus, event["token"], err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// RecordResult stores the score and feedback of a submitted attempt and
+// removes its environment.
+func RecordResult(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	token, _ := data["token"].(string)
+	feedback, _ := data["feedback"].(string)
+	result, ok := data["result"].(float64)
+	if !ok {
+		data["failureReason"] = "invalidResult"
+		data["failureMessage"] = "result must be a number"
+		publishEvent(ch, ctx, data, "attemptResultFailed", routingKey)
+		return
+	}
+
+	// checks are optional, a malformed list fails the whole result
+	var body struct {
+		Checks []models.GradeCheck `json:"checks"`
+	}
+	if err := json.Unmarshal(msg, &body); err != nil {
+		data["failureReason"] = "invalidResult"
+		data["failureMessage"] = fmt.Sprintf("invalid checks: %s", err)
+		publishEvent(ch, ctx, data, "attemptResultFailed", routingKey)
+		return
+	}
+
+	if _, err := gradeAttempt(ctx, token, result, feedback, body.Checks); err != nil {
+		rejectCommand(ch, ctx, data, err, "attemptResultFailed", routingKey)
+		return
+	}
+
+	publishEvent(ch, ctx, data, "attemptGraded", routingKey)
+}
+
+// gradeAttempt moves a submitted attempt to graded with its result and
+// removes the environment it was frozen in.
+func gradeAttempt(ctx context.Context, token string, result float64, feedback string, checks []models.GradeCheck) (models.Attempt, error) {
+	attempt, err := store.GradeAttempt(token, result, feedback, checks, time.Now())
+	if err != nil {
+		return attempt, err
+	}
+	auditTransition(ctx, &attempt)
+	endAttemptEnvironment(ctx, &attempt)
+
+	log.Printf("Attempt %s graded: %v", token, result)
+	return attempt, nil
+}
+
+// freezeAttempt withdraws the participant's network access to a submitted
+// attempt's environment, which is kept for grading. Failures are logged, the
+// gateway and terminal refuse submitted attempts regardless.
+func freezeAttempt(ctx context.Context, attempt *models.Attempt) {
+	if err := deploy.Freeze(ctx, kubeClient, releaseOf(attempt)); err != nil {
+		log.Printf("Failed to freeze the environment of %s: %s", attempt.Token, err)
+	}
+}
+
+// endAttemptEnvironment tears down an attempt's release unless it ended
+// already. Failed teardowns are left to the reconciler.
+func endAttemptEnvironment(ctx context.Context, attempt *models.Attempt) {
+	if attempt.EndedAt != nil {
+		return
+	}
+	if err := teardownAttempt(ctx, attempt); err != nil {
+		log.Printf("Failed to remove the environment of %s: %s", attempt.Token, err)
+		return
+	}
+	if err := store.EndAttempt(attempt.Token, time.Now()); err != nil {
+		log.Printf("Failed to end attempt %s: %s", attempt.Token, err)
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestSubmitAttempt(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+
+	tests := []struct {
+		name     string
+		onSubmit string
+
+		wantDeployed bool
+		wantFrozen   bool
+	}{
+		{
+			name:         "freeze",
+			onSubmit:     models.SubmitFreeze,
+			wantDeployed: true,
+			wantFrozen:   true,
+		},
+		{
+			name:     "teardown",
+			onSubmit: models.SubmitTeardown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake", OnSubmit: tt.onSubmit}
+			attempt := models.Attempt{
+				Token:         "token",
+				CreatorName:   challenge.CreatorName,
+				ChallengeName: challenge.ChallengeName,
+				Status:        models.AttemptRunning,
+				StartedAt:     &startedAt,
+				Ipaddress:     "10.0.0.1",
+				Port:          "30022",
+			}
+			m := &memStore{challenges: []models.Challenge{challenge}, attempts: []models.Attempt{attempt}}
+			kube := fake.NewSimpleClientset()
+			fake := deploy.NewFake()
+			deployRelease(t, fake, releaseOf(&attempt))
+			useStore(t, m)
+			useDeployer(t, fake)
+			useKube(t, kube)
+			events := useEvents(t)
+
+			msg, _ := json.Marshal(map[string]interface{}{"token": attempt.Token})
+			SubmitAttempt(nil, context.Background(), msg, "attemptSubmit")
+
+			if statuses := events.Statuses(); len(statuses) != 1 || statuses[0] != "attemptSubmitted" {
+				t.Fatalf("events = %v, want attemptSubmitted", statuses)
+			}
+			submitted := m.Attempt(attempt.Token)
+			if submitted.Status != models.AttemptSubmitted || submitted.Ipaddress != "" {
+				t.Errorf("attempt = %s at %q, want submitted without an address", submitted.Status, submitted.Ipaddress)
+			}
+			if _, deployed := fake.Spec(releaseOf(&attempt)); deployed != tt.wantDeployed {
+				t.Errorf("release deployed = %v, want %v", deployed, tt.wantDeployed)
+			}
+			release := releaseOf(&attempt)
+			_, err := kube.NetworkingV1().NetworkPolicies(release.Namespace).Get(context.Background(), release.Name+"-frozen", v1.GetOptions{})
+			if frozen := err == nil; frozen != tt.wantFrozen {
+				t.Errorf("release frozen = %v, want %v", frozen, tt.wantFrozen)
+			}
+		})
+	}
+}
+
+func TestSubmitAttemptGradesInBackground(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	challenge := models.Challenge{
+		CreatorName:   "creator",
+		ChallengeName: "ctf",
+		Deployer:      "fake",
+		Grader:        &models.GraderSpec{Command: []string{"grade"}},
+	}
+	attempt := models.Attempt{
+		Token:         "token",
+		CreatorName:   challenge.CreatorName,
+		ChallengeName: challenge.ChallengeName,
+		Status:        models.AttemptRunning,
+		StartedAt:     &startedAt,
+	}
+	m := &memStore{challenges: []models.Challenge{challenge}, attempts: []models.Attempt{attempt}}
+	useStore(t, m)
+	useDeployer(t, deploy.NewFake())
+	// the release has no pod, grading fails once it gets to run
+	useKube(t, fake.NewSimpleClientset())
+	events := useEvents(t)
+
+	msg, _ := json.Marshal(map[string]interface{}{"token": attempt.Token})
+	SubmitAttempt(nil, context.Background(), msg, "attemptSubmit")
+
+	grading.Wait()
+
+	statuses := events.Statuses()
+	if len(statuses) != 2 || statuses[0] != "attemptSubmitted" || statuses[1] != "attemptGradeFailed" {
+		t.Errorf("events = %v, want attemptSubmitted then attemptGradeFailed", statuses)
+	}
+}
+
+func TestRunGraderPublishRetried(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	challenge := models.Challenge{
+		CreatorName:   "creator",
+		ChallengeName: "ctf",
+		Deployer:      "fake",
+		Grader:        &models.GraderSpec{Command: []string{"grade"}},
+	}
+	attempt := models.Attempt{
+		Token:         "token",
+		CreatorName:   challenge.CreatorName,
+		ChallengeName: challenge.ChallengeName,
+		Status:        models.AttemptSubmitted,
+		StartedAt:     &startedAt,
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+
+		wantEvents []string
+	}{
+		{
+			name:       "broker back",
+			failures:   2,
+			wantEvents: []string{"attemptGraded"},
+		},
+		{
+			name:       "broker gone",
+			failures:   gradePublishRetries + 1,
+			wantEvents: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &memStore{challenges: []models.Challenge{challenge}, attempts: []models.Attempt{attempt}}
+			useStore(t, m)
+			useDeployer(t, deploy.NewFake())
+			events := useEvents(t)
+
+			saved, savedBackoff := gradeRelease, gradePublishBackoff
+			t.Cleanup(func() { gradeRelease, gradePublishBackoff = saved, savedBackoff })
+			gradeRelease = func(ctx context.Context, restConfig *rest.Config, kube kubernetes.Interface, release deploy.Release, grader deploy.Grader) (*deploy.GradeResult, error) {
+				return &deploy.GradeResult{Score: 7, Feedback: "well done"}, nil
+			}
+			gradePublishBackoff = time.Millisecond
+
+			// the broker is away for the first publishes
+			captured, failures := publish, tt.failures
+			publish = func(ch eventChannel, ctx context.Context, msg []byte, routingKey string) error {
+				if failures > 0 {
+					failures--
+					return errors.New("channel closed")
+				}
+				return captured(ch, ctx, msg, routingKey)
+			}
+
+			runGrader(context.Background(), &challenge, attempt, map[string]interface{}{})
+
+			if statuses := events.PublishedOn(publisher); !reflect.DeepEqual(statuses, tt.wantEvents) {
+				t.Errorf("events = %v, want %v", statuses, tt.wantEvents)
+			}
+			// the result is kept whether or not it got out
+			graded := m.Attempt(attempt.Token)
+			if graded.Status != models.AttemptGraded || graded.Result != 7 || graded.GradedAt == nil {
+				t.Errorf("attempt %s with result %v, want graded with 7", graded.Status, graded.Result)
+			}
+			unpublished := 0
+			for _, entry := range m.audit {
+				if strings.Contains(entry.Error, "not published") {
+					unpublished++
+				}
+			}
+			if unpublished != tt.failures {
+				t.Errorf("audited %d unpublished events, want %d", unpublished, tt.failures)
+			}
+		})
+	}
+}
+
+func TestRecordResultRetried(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	challenge := models.Challenge{CreatorName: "creator", ChallengeName: "ctf", Deployer: "fake"}
+	attempt := models.Attempt{
+		Token:         "token",
+		CreatorName:   challenge.CreatorName,
+		ChallengeName: challenge.ChallengeName,
+		Status:        models.AttemptSubmitted,
+		StartedAt:     &startedAt,
+	}
+	m := &memStore{challenges: []models.Challenge{challenge}, attempts: []models.Attempt{attempt}}
+	useStore(t, m)
+	useDeployer(t, deploy.NewFake())
+	events := useEvents(t)
+
+	// the database is away for the first result
+	failures := 1
+	store.GradeAttempt = func(token string, result float64, feedback string, checks []models.GradeCheck, gradedAt time.Time) (models.Attempt, error) {
+		if failures > 0 {
+			failures--
+			return models.Attempt{}, errors.New("server selection timeout")
+		}
+		return m.gradeAttempt(token, result, feedback, checks, gradedAt)
+	}
+
+	msg, _ := json.Marshal(map[string]interface{}{"token": attempt.Token, "result": 7})
+	RecordResult(nil, context.Background(), msg, "attemptGraded")
+	if status := m.Attempt(attempt.Token).Status; status != models.AttemptSubmitted {
+		t.Fatalf("attempt %s after a failed save, want it still submitted", status)
+	}
+
+	RecordResult(nil, context.Background(), msg, "attemptGraded")
+	graded := m.Attempt(attempt.Token)
+	if graded.Status != models.AttemptGraded || graded.Result != 7 {
+		t.Errorf("attempt %s with result %v, want graded with 7", graded.Status, graded.Result)
+	}
+	if statuses := events.Statuses(); !reflect.DeepEqual(statuses, []string{"attemptResultFailed", "attemptGraded"}) {
+		t.Errorf("events = %v, want the failure then attemptGraded", statuses)
+	}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sys.io/challenge-service/models"
+	"sys.io/challenge-service/utils"
+)
+
+// UpdateChallenge changes an existing challenge's duration, image tag,
+// resource profile or participants and publishes what changed. Changes that
+// affect running attempts need the force flag.
+func UpdateChallenge(ch *amqp.Channel, ctx context.Context, msg []byte, routingKey string) {
+
+	// Unpack JSON data.
+	var data map[string]interface{}
+	err := json.Unmarshal(msg, &data)
+	if err != nil {
+		log.Printf("Failed to decode JSON message body: %s", err)
+		utils.FailOnError(err, "Failed to decode JSON message body")
+		return
+	}
+
+	creatorName, _ := data["creatorName"].(string)
+	challengeName, _ := data["challengeName"].(string)
+
+	var update models.ChallengeUpdate
+	if err := json.Unmarshal(msg, &update); err != nil {
+		log.Printf("Failed to decode challenge update: %s", err)
+		data["failureReason"] = "invalidUpdate"
+		data["failureMessage"] = err.Error()
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+
+	challenge, err := store.GetChallenge(creatorName, challengeName)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		data["failureReason"] = "challengeNotFound"
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to find challenge %s: %s", challengeName, err)
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+	if challenge.ArchivedAt != nil {
+		data["failureReason"] = "challengeArchived"
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+
+	updated := update.Apply(challenge)
+
+	if updated.Duration < 0 {
+		data["failureReason"] = "invalidDuration"
+		data["failureMessage"] = fmt.Sprintf("duration %d is negative", updated.Duration)
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+
+	// check the resource profile fits within the configured maxima
+	if _, err := challengeResources(&updated); err != nil {
+		log.Printf("Invalid resource profile: %s", err)
+		data["failureReason"] = "invalidResources"
+		data["failureMessage"] = err.Error()
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+
+	// a new tag is pinned like on create
+	if updated.ImageDigest == "" {
+		if err := pinChallengeImage(ctx, &updated, data); err != nil {
+			publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+			return
+		}
+	}
+
+	diff := models.DiffChallenge(&challenge, &updated)
+	data["diff"] = diff
+	if len(diff) == 0 {
+		publishEvent(ch, ctx, data, "challengeUpdated", routingKey)
+		return
+	}
+
+	attempts, err := store.ListAttempts(creatorName, challengeName)
+	if err != nil {
+		log.Printf("Failed to list attempts of %s: %s", challengeName, err)
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+
+	if conflicts := updateConflicts(&updated, diff, attempts, time.Now()); len(conflicts) > 0 && !update.Force {
+		data["failureReason"] = "attemptsActive"
+		data["failureMessage"] = strings.Join(conflicts, "; ")
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+
+	specChanged := false
+	for _, change := range diff {
+		specChanged = specChanged || change.SpecChanged()
+	}
+	if specChanged {
+		updated.Revision++
+	}
+
+	// attempts of new participants first, nothing has changed if that fails
+	added, removed := models.DiffParticipants(challenge.Participants, updated.Participants)
+	if created, err := addParticipants(&updated, added); err != nil {
+		log.Printf("Failed to create attempts: %s", err)
+		discardNewAttempts(&challenge, created)
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+
+	if err := store.UpdateChallenge(&updated); err != nil {
+		log.Printf("Failed to update challenge %s: %s", challengeName, err)
+		discardNewAttempts(&challenge, added)
+		publishEvent(ch, ctx, data, "challengeUpdateFailed", routingKey)
+		return
+	}
+	if updated.ImageRegistryLink != challenge.ImageRegistryLink {
+		if err := store.SetUnstartedAttemptsImage(creatorName, challengeName, updated.ImageRegistryLink); err != nil {
+			log.Printf("Failed to update the image of attempts of %s: %s", challengeName, err)
+		}
+	}
+
+	// removed participants are off the saved list, attempts whose teardown
+	// failed are kept to be removed again
+	removeParticipants(ctx, attempts, removed)
+
+	// replace out of date instances and resize for the new participants
+	if keepsPool(&updated) {
+		FillPool(updated)
+	}
+
+	log.Printf("Challenge %s updated", challengeName)
+	publishEvent(ch, ctx, data, "challengeUpdated", routingKey)
+}
+
+// discardNewAttempts deletes the attempts of participants an update failed
+// to add, unless they have started. The challenge does not list them, so
+// they would otherwise start with everyone else's.
+func discardNewAttempts(challenge *models.Challenge, participants []string) {
+	if len(participants) == 0 {
+		return
+	}
+	attempts, err := store.ListAttempts(challenge.CreatorName, challenge.ChallengeName)
+	if err != nil {
+		log.Printf("Failed to list attempts of %s: %s", challenge.ChallengeName, err)
+		return
+	}
+	for _, attempt := range attempts {
+		if contains(participants, attempt.Participant) && models.AttemptStatus(&attempt) == models.AttemptCreated {
+			if err := store.DeleteAttempt(attempt.Token); err != nil {
+				log.Printf("Failed to delete attempt %s: %s", attempt.Token, err)
+			}
+		}
+	}
+}
+
+// updateConflicts lists why an update would affect attempts that have an
+// environment: it changes what they run, cuts their time short or removes
+// their participant.
+func updateConflicts(updated *models.Challenge, diff []models.ChallengeChange, attempts []models.Attempt, now time.Time) []string {
+	var conflicts []string
+	for _, change := range diff {
+		var affected []string
+		for i := range attempts {
+			attempt := &attempts[i]
+			if !models.AttemptActive(models.AttemptStatus(attempt)) {
+				continue
+			}
+
+			switch {
+			case change.SpecChanged():
+			case change.Field == "duration":
+				if !attemptEnded(attempt, updated, now) {
+					continue
+				}
+			case change.Field == "participants":
+				if !contains(change.Removed, attempt.Participant) {
+					continue
+				}
+			default:
+				continue
+			}
+			affected = append(affected, attempt.Participant)
+		}
+		if len(affected) > 0 {
+			conflicts = append(conflicts, fmt.Sprintf("%s affects running attempts of %s", change.Field, strings.Join(affected, ", ")))
+		}
+	}
+	return conflicts
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"sys.io/challenge-service/deploy"
+	"sys.io/challenge-service/models"
+)
+
+func TestUpdateChallengeParticipants(t *testing.T) {
+	startedAt := time.Now().Add(-10 * time.Minute)
+	challenge := models.Challenge{
+		CreatorName:   "creator",
+		ChallengeName: "ctf",
+		Deployer:      "fake",
+		Participants:  []string{"alice", "bob"},
+		ImageDigest:   "sha256:pinned",
+	}
+
+	tests := []struct {
+		name        string
+		attemptErrs map[string]error
+
+		wantStatus       string
+		wantParticipants []string
+		wantAttempts     []string
+		wantDeployed     bool
+	}{
+		{
+			name:             "applied",
+			wantStatus:       "challengeUpdated",
+			wantParticipants: []string{"alice", "carol", "dave"},
+			wantAttempts:     []string{"alice", "carol", "dave"},
+		},
+		{
+			name:             "attempts not created",
+			attemptErrs:      map[string]error{"dave": errors.New("write conflict")},
+			wantStatus:       "challengeUpdateFailed",
+			wantParticipants: []string{"alice", "bob"},
+			wantAttempts:     []string{"alice", "bob"},
+			wantDeployed:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &memStore{
+				challenges: []models.Challenge{challenge},
+				attempts: []models.Attempt{
+					{Token: "alice", Participant: "alice", CreatorName: "creator", ChallengeName: "ctf"},
+					{Token: "bob", Participant: "bob", CreatorName: "creator", ChallengeName: "ctf", Status: models.AttemptRunning, StartedAt: &startedAt},
+				},
+				attemptErrs: tt.attemptErrs,
+			}
+			fake := deploy.NewFake()
+			deployRelease(t, fake, releaseOf(&m.attempts[1]))
+			useStore(t, m)
+			useDeployer(t, fake)
+			events := useEvents(t)
+
+			msg, _ := json.Marshal(map[string]interface{}{
+				"creatorName":   "creator",
+				"challengeName": "ctf",
+				"participants":  []string{"alice", "carol", "dave"},
+				"force":         true,
+			})
+			UpdateChallenge(nil, context.Background(), msg, "challengeUpdated")
+
+			if statuses := events.Statuses(); len(statuses) != 1 || statuses[0] != tt.wantStatus {
+				t.Fatalf("events = %v, want %s", statuses, tt.wantStatus)
+			}
+			if got := m.Challenge("creator", "ctf").Participants; !reflect.DeepEqual(got, tt.wantParticipants) {
+				t.Errorf("participants = %v, want %v", got, tt.wantParticipants)
+			}
+			attempts, _ := m.listAttempts("creator", "ctf")
+			got := []string{}
+			for _, attempt := range attempts {
+				got = append(got, attempt.Participant)
+			}
+			if !reflect.DeepEqual(got, tt.wantAttempts) {
+				t.Errorf("attempts of %v, want %v", got, tt.wantAttempts)
+			}
+
+			// bob keeps the environment unless the update went through
+			if _, deployed := fake.Spec(releaseOf(&models.Attempt{Token: "bob"})); deployed != tt.wantDeployed {
+				t.Errorf("release of bob deployed = %v, want %v", deployed, tt.wantDeployed)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultImageRegistry = "docker.io"
+	defaultImageTag      = "latest"
+)
+
+var (
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp        = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+	hostRegexp          = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?\.?)+(?::[0-9]+)?$|^\[[0-9a-fA-F:]+\](?::[0-9]+)?$`)
+)
+
+// ImageReference is a parsed OCI/Docker image reference such as
+// host:5000/group/image:tag@sha256:...
+type ImageReference struct {
+	// Registry is the host, with port if one was given.
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseImageReference parses an image reference. A leading http(s):// is
+// ignored, a missing registry means Docker Hub and a reference with neither
+// tag nor digest gets the latest tag.
+func ParseImageReference(ref string) (ImageReference, error) {
+	var image ImageReference
+
+	s := strings.TrimSpace(ref)
+	s = strings.TrimPrefix(s, "https://")
+	s = strings.TrimPrefix(s, "http://")
+	if s == "" {
+		return image, fmt.Errorf("empty image reference")
+	}
+
+	// digest
+	if i := strings.Index(s, "@"); i >= 0 {
+		image.Digest = s[i+1:]
+		s = s[:i]
+		if !digestRegexp.MatchString(image.Digest) {
+			return image, fmt.Errorf("invalid digest %q in image reference %q", image.Digest, ref)
+		}
+		if algo, hex, _ := strings.Cut(image.Digest, ":"); algo == "sha256" && len(hex) != 64 {
+			return image, fmt.Errorf("invalid sha256 digest %q in image reference %q", image.Digest, ref)
+		}
+	}
+
+	// tag, only looking past the last slash so a registry port is not taken for one
+	lastSlash := strings.LastIndex(s, "/")
+	if i := strings.LastIndex(s, ":"); i > lastSlash {
+		image.Tag = s[i+1:]
+		s = s[:i]
+		if !tagRegexp.MatchString(image.Tag) {
+			return image, fmt.Errorf("invalid tag %q in image reference %q", image.Tag, ref)
+		}
+	}
+
+	// registry, when the first component looks like a host
+	if first, rest, found := strings.Cut(s, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		if !hostRegexp.MatchString(first) {
+			return image, fmt.Errorf("invalid registry %q in image reference %q", first, ref)
+		}
+		image.Registry = first
+		s = rest
+	} else {
+		image.Registry = defaultImageRegistry
+		if !found {
+			s = "library/" + s
+		}
+	}
+
+	for _, component := range strings.Split(s, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return image, fmt.Errorf("invalid repository %q in image reference %q", s, ref)
+		}
+	}
+	image.Repository = s
+
+	if image.Tag == "" && image.Digest == "" {
+		image.Tag = defaultImageTag
+	}
+
+	return image, nil
+}
+
+// Name returns registry/repository without tag or digest.
+func (r ImageReference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the full reference. When a digest is set the tag is kept
+// for readability but the digest is what gets pulled.
+func (r ImageReference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    ImageReference
+		wantErr bool
+	}{
+		{"GitLab link", "https://registry.gitlab.com/group/project/main:latest",
+			ImageReference{"registry.gitlab.com", "group/project/main", "latest", ""}, false},
+		{"No tag", "registry.gitlab.com/group/image",
+			ImageReference{"registry.gitlab.com", "group/image", "latest", ""}, false},
+		{"Registry with port", "host:5000/img:tag",
+			ImageReference{"host:5000", "img", "tag", ""}, false},
+		{"Registry with port no tag", "localhost:5000/a/b",
+			ImageReference{"localhost:5000", "a/b", "latest", ""}, false},
+		{"Digest", "ghcr.io/org/img@" + digest,
+			ImageReference{"ghcr.io", "org/img", "", digest}, false},
+		{"Tag and digest", "host:5000/img:1.0@" + digest,
+			ImageReference{"host:5000", "img", "1.0", digest}, false},
+		{"Docker Hub short name", "ubuntu:22.04",
+			ImageReference{"docker.io", "library/ubuntu", "22.04", ""}, false},
+		{"Docker Hub user image", "user/image",
+			ImageReference{"docker.io", "user/image", "latest", ""}, false},
+		{"Localhost", "localhost/img:dev",
+			ImageReference{"localhost", "img", "dev", ""}, false},
+		{"Empty", "", ImageReference{}, true},
+		{"Uppercase repository", "registry.gitlab.com/Group/Image:tag", ImageReference{}, true},
+		{"Bad digest", "img@sha256:abc", ImageReference{}, true},
+		{"Empty tag", "img:", ImageReference{}, true},
+		{"Trailing slash", "registry.gitlab.com/group/", ImageReference{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseImageReference(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseImageReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseImageReference() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageReferenceString(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"https://registry.gitlab.com/group/image:v1", "registry.gitlab.com/group/image:v1"},
+		{"host:5000/img", "host:5000/img:latest"},
+		{"ubuntu", "docker.io/library/ubuntu:latest"},
+	}
+
+	for _, tt := range tests {
+		image, err := ParseImageReference(tt.ref)
+		if err != nil {
+			t.Fatalf("ParseImageReference(%q) error = %v", tt.ref, err)
+		}
+		if got := image.String(); got != tt.want {
+			t.Errorf("String() = %v, want %v", got, tt.want)
+		}
+	}
+}